@@ -32,5 +32,7 @@ func init() {
 	SchemeBuilder.Register(
 		&Instance{},
 		&InstanceList{},
+		&Repository{},
+		&RepositoryList{},
 	)
 }
@@ -31,17 +31,22 @@ type InstanceList struct {
 	Items []Instance `json:"items"`
 }
 
-// +kubebuilder:validation:XValidation:rule="has(self.artifact) || (has(self.url) && size(self.url) > 0)",message="either artifact or url must be specified"
-// +kubebuilder:validation:XValidation:rule="!has(self.artifact) || !has(self.kind) || self.kind == 'helm'",message="artifact is only supported for helm instances"
+// +kubebuilder:validation:XValidation:rule="has(self.artifact) || (has(self.url) && size(self.url) > 0) || has(self.repositoryRef)",message="either artifact, url or repositoryRef must be specified"
+// +kubebuilder:validation:XValidation:rule="!has(self.repositoryRef) || (!has(self.artifact) && (!has(self.url) || size(self.url) == 0) && !has(self.auth))",message="repositoryRef cannot be combined with artifact, url, or auth"
+// +kubebuilder:validation:XValidation:rule="!has(self.adopt) || !has(self.kind) || self.kind == 'helm' || (!has(self.adopt.releaseName) && !has(self.adopt.releaseNamespace))",message="adopt releaseName and releaseNamespace are only supported by helm instances"
+// +kubebuilder:validation:XValidation:rule="!has(self.kustomize) || self.kind == 'kustomize'",message="kustomize is only supported by kustomize instances"
+// +kubebuilder:validation:XValidation:rule="!has(self.manifests) || self.kind == 'manifests'",message="manifests is only supported by manifests instances"
+// +kubebuilder:validation:XValidation:rule="!has(self.jsonnet) || self.kind == 'jsonnet'",message="jsonnet is only supported by jsonnet instances"
+// +kubebuilder:validation:XValidation:rule="!has(self.cue) || self.kind == 'cue'",message="cue is only supported by cue instances"
 // +kubebuilder:validation:XValidation:rule="!has(self.artifact) || ((!has(self.url) || size(self.url) == 0) && (!has(self.version) || size(self.version) == 0) && (!has(self.chart) || size(self.chart) == 0) && (!has(self.path) || size(self.path) == 0) && !has(self.auth))",message="artifact cannot be combined with url, version, chart, path, or auth"
 type InstanceSpec struct {
 	// Kind instance kind.
 	// +kubebuilder:default=helm
 	Kind InstanceKind `json:"kind,omitempty"`
 
-	// Artifact references a verified chart archive stored in a Secret in the
-	// same namespace as the Instance. Artifact and URL-based sources are
-	// mutually exclusive.
+	// Artifact references a verified chart archive or, for the other kinds,
+	// a tar.gz or zip bundle stored in a Secret in the same namespace as the
+	// Instance. Artifact and URL-based sources are mutually exclusive.
 	// +kubebuilder:validation:Optional
 	Artifact *Artifact `json:"artifact,omitempty"`
 
@@ -49,6 +54,11 @@ type InstanceSpec struct {
 	// +kubebuilder:validation:Optional
 	URL string `json:"url,omitempty"`
 
+	// RepositoryRef refers to a Repository in the Instance namespace whose
+	// URL, credentials and cached index are used instead of URL and Auth.
+	// +kubebuilder:validation:Optional
+	RepositoryRef *corev1.LocalObjectReference `json:"repositoryRef,omitempty"`
+
 	// Version is the version of helm chart, git revision, etc.
 	Version string `json:"version,omitempty"`
 
@@ -85,26 +95,268 @@ type InstanceSpec struct {
 	// Supports inline basic auth and secretRef for pulling from private repositories.
 	// +kubebuilder:validation:Optional
 	Auth *RepositoryAuth `json:"auth,omitempty"`
+
+	// Adopt takes over a helm release or objects installed outside of the
+	// installer instead of installing them anew.
+	// +kubebuilder:validation:Optional
+	Adopt *Adopt `json:"adopt,omitempty"`
+
+	// Kustomize is an overlay generated over the kustomization of a kustomize
+	// instance, to customize a shared base per Instance.
+	// +kubebuilder:validation:Optional
+	Kustomize *KustomizeOverlay `json:"kustomize,omitempty"`
+
+	// Manifests configures how the files of a manifests instance are rendered.
+	// +kubebuilder:validation:Optional
+	Manifests *ManifestsSource `json:"manifests,omitempty"`
+
+	// Jsonnet configures the evaluation of a jsonnet instance.
+	// +kubebuilder:validation:Optional
+	Jsonnet *JsonnetSource `json:"jsonnet,omitempty"`
+
+	// Cue configures the evaluation of a cue instance.
+	// +kubebuilder:validation:Optional
+	Cue *CueSource `json:"cue,omitempty"`
+
+	// IgnoreDifferences selects fields of the rendered objects owned by other
+	// controllers. They are removed before apply and from the diff.
+	// +kubebuilder:validation:Optional
+	IgnoreDifferences []IgnoreDifference `json:"ignoreDifferences,omitempty"`
+
+	// DeletionPolicy is what the removal of the Instance does with its
+	// objects: Delete removes them, Orphan leaves them all, OrphanData leaves
+	// the PersistentVolumeClaims, Secrets and CustomResourceDefinitions and
+	// removes the others. Orphaned objects lose the identity labels of the
+	// Instance.
+	// +kubebuilder:default=Delete
+	// +kubebuilder:validation:Optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
 }
 
-// Artifact describes an immutable chart source stored in a Secret.
+// +kubebuilder:validation:Enum=Delete;Orphan;OrphanData
+type DeletionPolicy string
+
+const (
+	DeletionPolicyDelete     DeletionPolicy = "Delete"
+	DeletionPolicyOrphan     DeletionPolicy = "Orphan"
+	DeletionPolicyOrphanData DeletionPolicy = "OrphanData"
+)
+
+// IgnoreDifference selects fields of the objects of a kind, or of the object
+// of a name, by JSON pointers or CEL paths.
+// +kubebuilder:validation:XValidation:rule="has(self.jsonPointers) || has(self.celPaths)",message="either jsonPointers or celPaths must be specified"
+type IgnoreDifference struct {
+	// Group is the API group of the objects, empty for the core group.
+	// +kubebuilder:validation:Optional
+	Group string `json:"group,omitempty"`
+
+	// Kind is the kind of the objects.
+	Kind string `json:"kind"`
+
+	// Name selects the object of the name, all objects of the kind when empty.
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+
+	// JSONPointers are RFC 6901 pointers to the fields, such as
+	// /spec/replicas.
+	// +kubebuilder:validation:Optional
+	JSONPointers []string `json:"jsonPointers,omitempty"`
+
+	// CELPaths are CEL field selections on object, such as
+	// object.spec.replicas. A map macro selects the field in every item of a
+	// list: object.webhooks.map(w, w.clientConfig.caBundle).
+	// +kubebuilder:validation:Optional
+	CELPaths []string `json:"celPaths,omitempty"`
+}
+
+// CueSource configures the evaluation of the CUE module of a cue instance.
+// The values of the Instance are unified with the module, which validates
+// them against its schema, and the objects are exported from the result.
+type CueSource struct {
+	// Package is the package evaluated, relative to the source directory,
+	// "." by default.
+	// +kubebuilder:validation:Optional
+	Package string `json:"package,omitempty"`
+
+	// ValuesPath is the path the values are unified with, "values" by default.
+	// +kubebuilder:validation:Optional
+	ValuesPath string `json:"valuesPath,omitempty"`
+
+	// ObjectsPath is the path of the exported Kubernetes objects, "objects"
+	// by default. It may hold an object, a list, or a struct of them.
+	// +kubebuilder:validation:Optional
+	ObjectsPath string `json:"objectsPath,omitempty"`
+}
+
+// JsonnetSource configures the program of a jsonnet instance. The program is
+// evaluated in the directory at path of the source, which with its vendor and
+// lib subdirectories forms the library search paths.
+type JsonnetSource struct {
+	// Entrypoint is the file evaluated, relative to the source directory,
+	// main.jsonnet by default.
+	// +kubebuilder:validation:Optional
+	Entrypoint string `json:"entrypoint,omitempty"`
+
+	// LibPaths are additional library search paths relative to the source
+	// directory, searched before the default ones.
+	// +kubebuilder:validation:Optional
+	LibPaths []string `json:"libPaths,omitempty"`
+
+	// TopLevelArguments passes the top level values as top level arguments
+	// of the entrypoint function, they are always passed as external variables.
+	// +kubebuilder:validation:Optional
+	TopLevelArguments bool `json:"topLevelArguments,omitempty"`
+}
+
+// ManifestsSource configures the templating of the YAML and JSON files of a
+// manifests instance.
+type ManifestsSource struct {
+	// Templating renders the files against the values of the Instance before
+	// they are applied: GoTemplate executes them as Go templates with the
+	// sprig functions, Envsubst replaces ${VAR} placeholders. Files are
+	// applied as is by default.
+	// +kubebuilder:validation:Optional
+	Templating ManifestsTemplating `json:"templating,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=None;GoTemplate;Envsubst
+type ManifestsTemplating string
+
+const (
+	ManifestsTemplatingNone       ManifestsTemplating = "None"
+	ManifestsTemplatingGoTemplate ManifestsTemplating = "GoTemplate"
+	ManifestsTemplatingEnvsubst   ManifestsTemplating = "Envsubst"
+)
+
+// KustomizeOverlay holds the kustomization fields of an overlay using the
+// downloaded kustomization as its only resource, and the post-build settings.
+type KustomizeOverlay struct {
+	// NamePrefix is prepended to the names of all resources.
+	// +kubebuilder:validation:Optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
+	// NameSuffix is appended to the names of all resources.
+	// +kubebuilder:validation:Optional
+	NameSuffix string `json:"nameSuffix,omitempty"`
+
+	// CommonLabels are added to all resources and selectors.
+	// +kubebuilder:validation:Optional
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+
+	// Images overrides the name, tag or digest of container images.
+	// +kubebuilder:validation:Optional
+	Images []KustomizeImage `json:"images,omitempty"`
+
+	// Patches are strategic merge or JSON6902 patches applied to the resources.
+	// +kubebuilder:validation:Optional
+	Patches []KustomizePatch `json:"patches,omitempty"`
+
+	// Components are paths of kustomize components, relative to the kustomization.
+	// +kubebuilder:validation:Optional
+	Components []string `json:"components,omitempty"`
+
+	// Substitute replaces ${VAR} placeholders in the built resources with the
+	// values of the Instance, including valuesFrom. Nested values are named by
+	// joining their keys with "_", resources annotated with
+	// apps.xiaoshiai.cn/substitute: disabled are left as is.
+	// +kubebuilder:validation:Optional
+	Substitute bool `json:"substitute,omitempty"`
+}
+
+// KustomizeImage overrides the images named Name.
+type KustomizeImage struct {
+	// Name is the image name to match, without tag or digest.
+	Name string `json:"name"`
+
+	// NewName replaces the image name.
+	// +kubebuilder:validation:Optional
+	NewName string `json:"newName,omitempty"`
+
+	// NewTag replaces the image tag.
+	// +kubebuilder:validation:Optional
+	NewTag string `json:"newTag,omitempty"`
+
+	// Digest replaces the image tag with a digest.
+	// +kubebuilder:validation:Optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// KustomizePatch is an inline patch applied to the resources matching Target.
+type KustomizePatch struct {
+	// Patch is a strategic merge patch or a JSON6902 patch, as YAML or JSON.
+	Patch string `json:"patch"`
+
+	// Target selects the resources to patch, defaults to the resource named
+	// in a strategic merge patch. JSON6902 patches require a target.
+	// +kubebuilder:validation:Optional
+	Target *KustomizeSelector `json:"target,omitempty"`
+}
+
+// KustomizeSelector selects resources by group, version, kind, name,
+// namespace, labels and annotations. Names and namespaces are regular expressions.
+type KustomizeSelector struct {
+	// +kubebuilder:validation:Optional
+	Group string `json:"group,omitempty"`
+	// +kubebuilder:validation:Optional
+	Version string `json:"version,omitempty"`
+	// +kubebuilder:validation:Optional
+	Kind string `json:"kind,omitempty"`
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// LabelSelector is a label selector of the resources.
+	// +kubebuilder:validation:Optional
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// AnnotationSelector is an annotation selector of the resources.
+	// +kubebuilder:validation:Optional
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+}
+
+// Adopt configures taking over an existing installation.
+// Helm instances import the existing release, installing the chart over
+// existing objects not owned by any release. Other instances take over the
+// fields of existing objects set by client-side apply.
+type Adopt struct {
+	// ReleaseName is the name of the helm release to import, defaults to the Instance name.
+	// The release keeps this name for later upgrades and the uninstall.
+	// +kubebuilder:validation:Optional
+	ReleaseName string `json:"releaseName,omitempty"`
+
+	// ReleaseNamespace is the namespace of the helm release, defaults to the Instance namespace.
+	// +kubebuilder:validation:Optional
+	ReleaseNamespace string `json:"releaseNamespace,omitempty"`
+}
+
+// Artifact describes an immutable chart or bundle source stored in a Secret.
 type Artifact struct {
-	// SecretRef identifies the chart archive in the Instance namespace.
+	// SecretRef identifies the archive in the Instance namespace.
 	SecretRef ArtifactSecretRef `json:"secretRef"`
 
-	// Digest is the SHA-256 digest of the raw chart archive bytes.
+	// Digest is the SHA-256 digest of the raw archive bytes.
 	// When omitted, installer still computes and reports the actual digest.
+	// For chunked artifacts it covers the reassembled archive.
 	// +kubebuilder:validation:Optional
 	Digest string `json:"digest,omitempty"`
+
+	// Chunks lists, in order, further Secrets holding the remaining parts of
+	// an archive too large for a single Secret. Their data is appended to the
+	// data selected by SecretRef. Every Secret of a chunked artifact must be
+	// annotated with its zero-based apps.xiaoshiai.cn/chunk-index.
+	// A single Secret may also split the archive into the numbered keys
+	// "<key>.0", "<key>.1", ... when the key itself is absent.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MaxItems=64
+	Chunks []ArtifactSecretRef `json:"chunks,omitempty"`
 }
 
-// ArtifactSecretRef references a chart archive in a Kubernetes Secret.
+// ArtifactSecretRef references an archive in a Kubernetes Secret.
 type ArtifactSecretRef struct {
 	// Name is the Secret name in the Instance namespace.
 	// +kubebuilder:validation:MinLength=1
 	Name string `json:"name"`
 
-	// Key is the Secret data key containing the chart archive.
+	// Key is the Secret data key containing the archive.
 	// +kubebuilder:validation:MinLength=1
 	Key string `json:"key"`
 }
@@ -217,6 +469,93 @@ type InstanceStatus struct {
 	// Extensions is the list of extensions that were applied during the last sync.
 	// Used to detect extension changes that require re-apply.
 	Extensions []Extension `json:"extensions,omitempty"`
+
+	// History lists the latest installs, upgrades and adoptions, oldest first.
+	// For helm instances it mirrors the release history.
+	// +kubebuilder:validation:MaxItems=10
+	History []InstanceRevision `json:"history,omitempty"`
+
+	// Release is the helm release of an instance which adopted a release with
+	// another name or namespace. The release keeps it after adopt is removed.
+	Release *InstanceRelease `json:"release,omitempty"`
+
+	// Hooks lists the lifecycle hooks run by the last apply of a non-helm
+	// instance, in the order they ran.
+	Hooks []HookResult `json:"hooks,omitempty"`
+
+	// Removing lists the objects of a deleted instance still terminating,
+	// the instance is kept until they are gone.
+	Removing []RemovingResource `json:"removing,omitempty"`
+
+	// Waiting is the stage an unfinished apply or removal waits at, the
+	// instance is reconciled again until its objects are ready or gone.
+	Waiting *InstanceWait `json:"waiting,omitempty"`
+}
+
+// InstanceWait is the wait of an apply or removal for its objects.
+type InstanceWait struct {
+	// Stage is what is waited for, such as an apply wave.
+	Stage string `json:"stage"`
+	// Since is when the wait for the stage started.
+	Since metav1.Time `json:"since"`
+}
+
+// RemovingResource is an object still terminating after the removal of its
+// instance.
+type RemovingResource struct {
+	ManagedResource `json:",inline"`
+	// Finalizers are the finalizers the object waits for.
+	Finalizers []string `json:"finalizers,omitempty"`
+}
+
+// HookResult records a lifecycle hook run.
+type HookResult struct {
+	// Name is the name of the hook object.
+	Name string `json:"name"`
+	// Kind is the kind of the hook object.
+	Kind string `json:"kind,omitempty"`
+	// Event is the lifecycle event the hook ran at.
+	Event string `json:"event"`
+	// Phase is the result of the hook.
+	// +kubebuilder:validation:Enum=Running;Succeeded;Failed
+	Phase HookPhase `json:"phase"`
+	// Message describes a failure of the hook.
+	Message string `json:"message,omitempty"`
+	// StartedAt is the time the hook was created.
+	StartedAt metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is the time the hook completed or failed.
+	CompletedAt metav1.Time `json:"completedAt,omitempty"`
+}
+
+type HookPhase string
+
+const (
+	HookPhaseRunning   HookPhase = "Running"
+	HookPhaseSucceeded HookPhase = "Succeeded"
+	HookPhaseFailed    HookPhase = "Failed"
+)
+
+// MaxInstanceHistory is the number of revisions kept in the status.
+const MaxInstanceHistory = 10
+
+// InstanceRelease identifies the helm release of an instance.
+type InstanceRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// InstanceRevision records an install, upgrade or adoption of an instance.
+type InstanceRevision struct {
+	// Revision is the helm release revision, or a counter for other instances.
+	Revision int `json:"revision"`
+	// Version is the version installed by the revision.
+	Version string `json:"version,omitempty"`
+	// AppVersion is the app version installed by the revision.
+	AppVersion string `json:"appVersion,omitempty"`
+	// Description describes the revision.
+	Description string `json:"description,omitempty"`
+	// UpdatedTime is the time the revision was applied.
+	UpdatedTime metav1.Time `json:"updatedTime,omitempty"`
 }
 
 // ArtifactStatus records the last successfully installed artifact.
@@ -254,13 +593,16 @@ func (obj *ManagedResource) GetObjectKind() schema.ObjectKind { return obj }
 
 type Phase string
 
-// +kubebuilder:validation:Enum=helm;kustomize;template
+// +kubebuilder:validation:Enum=helm;kustomize;template;manifests;jsonnet;cue
 type InstanceKind string
 
 const (
 	InstanceKindHelm      InstanceKind = "helm"
 	InstanceKindKustomize InstanceKind = "kustomize"
 	InstanceKindTemplate  InstanceKind = "template"
+	InstanceKindManifests InstanceKind = "manifests"
+	InstanceKindJsonnet   InstanceKind = "jsonnet"
+	InstanceKindCue       InstanceKind = "cue"
 )
 
 const (
@@ -331,4 +673,9 @@ const (
 	ConditionReady = "Ready"
 	// ConditionExpressionsReady indicates whether configured status expressions evaluated successfully.
 	ConditionExpressionsReady = "ExpressionsReady"
+	// ConditionValuesValid indicates whether the values satisfy the schema of a cue instance.
+	ConditionValuesValid = "ValuesValid"
+	// ConditionApplyConflict indicates whether the last apply conflicted with
+	// the fields of other field managers.
+	ConditionApplyConflict = "ApplyConflict"
 )
@@ -0,0 +1,90 @@
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Namespaced
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="URL",type="string",JSONPath=".spec.url",description="Repository URL"
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status",description="Index ready"
+// +kubebuilder:printcolumn:name="REFRESHED",type="date",JSONPath=".status.lastRefreshTime",description="Last index refresh"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp",description="Creation time"
+type Repository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RepositorySpec   `json:"spec,omitempty"`
+	Status RepositoryStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type RepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Repository `json:"items"`
+}
+
+type RepositorySpec struct {
+	// URL is the URL of the helm repository or OCI registry.
+	// +kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+
+	// Auth holds credentials for accessing the repository, shared by all
+	// Instances referring to it.
+	// +kubebuilder:validation:Optional
+	Auth *RepositoryAuth `json:"auth,omitempty"`
+
+	// TLS configures the TLS connection to the repository.
+	// +kubebuilder:validation:Optional
+	TLS *RepositoryTLS `json:"tls,omitempty"`
+
+	// Interval is the period between two refreshes of the repository index.
+	// +kubebuilder:default="10m"
+	// +kubebuilder:validation:Optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// RepositoryTLS configures the TLS connection to a repository.
+type RepositoryTLS struct {
+	// InsecureSkipVerify skips the verification of the repository certificate.
+	// +kubebuilder:validation:Optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// SecretRef references a Secret holding PEM encoded "ca.crt" to verify the
+	// repository and optional "tls.crt" and "tls.key" for client authentication.
+	// +kubebuilder:validation:Optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+type RepositoryStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the repository's state.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// LastRefreshTime is the time the index was last fetched successfully.
+	LastRefreshTime metav1.Time `json:"lastRefreshTime,omitempty"`
+
+	// IndexDigest is the SHA-256 digest of the cached index.
+	IndexDigest string `json:"indexDigest,omitempty"`
+
+	// Charts lists the charts available in the repository.
+	Charts []RepositoryChart `json:"charts,omitempty"`
+}
+
+// RepositoryChart describes a chart available in a repository.
+type RepositoryChart struct {
+	Name string `json:"name"`
+	// Versions are the most recent versions of the chart, newest first.
+	Versions []string `json:"versions,omitempty"`
+}
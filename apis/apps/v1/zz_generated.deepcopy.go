@@ -26,10 +26,30 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Adopt) DeepCopyInto(out *Adopt) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Adopt.
+func (in *Adopt) DeepCopy() *Adopt {
+	if in == nil {
+		return nil
+	}
+	out := new(Adopt)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Artifact) DeepCopyInto(out *Artifact) {
 	*out = *in
 	out.SecretRef = in.SecretRef
+	if in.Chunks != nil {
+		in, out := &in.Chunks, &out.Chunks
+		*out = make([]ArtifactSecretRef, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Artifact.
@@ -72,6 +92,21 @@ func (in *ArtifactStatus) DeepCopy() *ArtifactStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CueSource) DeepCopyInto(out *CueSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CueSource.
+func (in *CueSource) DeepCopy() *CueSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CueSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Endpoint) DeepCopyInto(out *Endpoint) {
 	*out = *in
@@ -114,6 +149,48 @@ func (in *Extension) DeepCopy() *Extension {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HookResult) DeepCopyInto(out *HookResult) {
+	*out = *in
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	in.CompletedAt.DeepCopyInto(&out.CompletedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HookResult.
+func (in *HookResult) DeepCopy() *HookResult {
+	if in == nil {
+		return nil
+	}
+	out := new(HookResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IgnoreDifference) DeepCopyInto(out *IgnoreDifference) {
+	*out = *in
+	if in.JSONPointers != nil {
+		in, out := &in.JSONPointers, &out.JSONPointers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CELPaths != nil {
+		in, out := &in.CELPaths, &out.CELPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IgnoreDifference.
+func (in *IgnoreDifference) DeepCopy() *IgnoreDifference {
+	if in == nil {
+		return nil
+	}
+	out := new(IgnoreDifference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Instance) DeepCopyInto(out *Instance) {
 	*out = *in
@@ -173,12 +250,64 @@ func (in *InstanceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstanceWait) DeepCopyInto(out *InstanceWait) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceWait.
+func (in *InstanceWait) DeepCopy() *InstanceWait {
+	if in == nil {
+		return nil
+	}
+	out := new(InstanceWait)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstanceRelease) DeepCopyInto(out *InstanceRelease) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceRelease.
+func (in *InstanceRelease) DeepCopy() *InstanceRelease {
+	if in == nil {
+		return nil
+	}
+	out := new(InstanceRelease)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstanceRevision) DeepCopyInto(out *InstanceRevision) {
+	*out = *in
+	in.UpdatedTime.DeepCopyInto(&out.UpdatedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceRevision.
+func (in *InstanceRevision) DeepCopy() *InstanceRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(InstanceRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *InstanceSpec) DeepCopyInto(out *InstanceSpec) {
 	*out = *in
 	if in.Artifact != nil {
 		in, out := &in.Artifact, &out.Artifact
 		*out = new(Artifact)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RepositoryRef != nil {
+		in, out := &in.RepositoryRef, &out.RepositoryRef
+		*out = new(corev1.LocalObjectReference)
 		**out = **in
 	}
 	if in.Dependencies != nil {
@@ -209,6 +338,38 @@ func (in *InstanceSpec) DeepCopyInto(out *InstanceSpec) {
 		*out = new(RepositoryAuth)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Adopt != nil {
+		in, out := &in.Adopt, &out.Adopt
+		*out = new(Adopt)
+		**out = **in
+	}
+	if in.Kustomize != nil {
+		in, out := &in.Kustomize, &out.Kustomize
+		*out = new(KustomizeOverlay)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Manifests != nil {
+		in, out := &in.Manifests, &out.Manifests
+		*out = new(ManifestsSource)
+		**out = **in
+	}
+	if in.Jsonnet != nil {
+		in, out := &in.Jsonnet, &out.Jsonnet
+		*out = new(JsonnetSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Cue != nil {
+		in, out := &in.Cue, &out.Cue
+		*out = new(CueSource)
+		**out = **in
+	}
+	if in.IgnoreDifferences != nil {
+		in, out := &in.IgnoreDifferences, &out.IgnoreDifferences
+		*out = make([]IgnoreDifference, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceSpec.
@@ -270,6 +431,37 @@ func (in *InstanceStatus) DeepCopyInto(out *InstanceStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]InstanceRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Release != nil {
+		in, out := &in.Release, &out.Release
+		*out = new(InstanceRelease)
+		**out = **in
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = make([]HookResult, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Removing != nil {
+		in, out := &in.Removing, &out.Removing
+		*out = make([]RemovingResource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Waiting != nil {
+		in, out := &in.Waiting, &out.Waiting
+		*out = new(InstanceWait)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstanceStatus.
@@ -282,6 +474,115 @@ func (in *InstanceStatus) DeepCopy() *InstanceStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JsonnetSource) DeepCopyInto(out *JsonnetSource) {
+	*out = *in
+	if in.LibPaths != nil {
+		in, out := &in.LibPaths, &out.LibPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JsonnetSource.
+func (in *JsonnetSource) DeepCopy() *JsonnetSource {
+	if in == nil {
+		return nil
+	}
+	out := new(JsonnetSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KustomizeImage) DeepCopyInto(out *KustomizeImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KustomizeImage.
+func (in *KustomizeImage) DeepCopy() *KustomizeImage {
+	if in == nil {
+		return nil
+	}
+	out := new(KustomizeImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KustomizeOverlay) DeepCopyInto(out *KustomizeOverlay) {
+	*out = *in
+	if in.CommonLabels != nil {
+		in, out := &in.CommonLabels, &out.CommonLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]KustomizeImage, len(*in))
+		copy(*out, *in)
+	}
+	if in.Patches != nil {
+		in, out := &in.Patches, &out.Patches
+		*out = make([]KustomizePatch, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KustomizeOverlay.
+func (in *KustomizeOverlay) DeepCopy() *KustomizeOverlay {
+	if in == nil {
+		return nil
+	}
+	out := new(KustomizeOverlay)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KustomizePatch) DeepCopyInto(out *KustomizePatch) {
+	*out = *in
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(KustomizeSelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KustomizePatch.
+func (in *KustomizePatch) DeepCopy() *KustomizePatch {
+	if in == nil {
+		return nil
+	}
+	out := new(KustomizePatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KustomizeSelector) DeepCopyInto(out *KustomizeSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KustomizeSelector.
+func (in *KustomizeSelector) DeepCopy() *KustomizeSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(KustomizeSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ManagedResource) DeepCopyInto(out *ManagedResource) {
 	*out = *in
@@ -297,6 +598,21 @@ func (in *ManagedResource) DeepCopy() *ManagedResource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestsSource) DeepCopyInto(out *ManifestsSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestsSource.
+func (in *ManifestsSource) DeepCopy() *ManifestsSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestsSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Option) DeepCopyInto(out *Option) {
 	*out = *in
@@ -312,6 +628,54 @@ func (in *Option) DeepCopy() *Option {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemovingResource) DeepCopyInto(out *RemovingResource) {
+	*out = *in
+	out.ManagedResource = in.ManagedResource
+	if in.Finalizers != nil {
+		in, out := &in.Finalizers, &out.Finalizers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemovingResource.
+func (in *RemovingResource) DeepCopy() *RemovingResource {
+	if in == nil {
+		return nil
+	}
+	out := new(RemovingResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Repository) DeepCopyInto(out *Repository) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Repository.
+func (in *Repository) DeepCopy() *Repository {
+	if in == nil {
+		return nil
+	}
+	out := new(Repository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Repository) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RepositoryAuth) DeepCopyInto(out *RepositoryAuth) {
 	*out = *in
@@ -332,6 +696,134 @@ func (in *RepositoryAuth) DeepCopy() *RepositoryAuth {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryChart) DeepCopyInto(out *RepositoryChart) {
+	*out = *in
+	if in.Versions != nil {
+		in, out := &in.Versions, &out.Versions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryChart.
+func (in *RepositoryChart) DeepCopy() *RepositoryChart {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryChart)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryList) DeepCopyInto(out *RepositoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Repository, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryList.
+func (in *RepositoryList) DeepCopy() *RepositoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RepositoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositorySpec) DeepCopyInto(out *RepositorySpec) {
+	*out = *in
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(RepositoryAuth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(RepositoryTLS)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositorySpec.
+func (in *RepositorySpec) DeepCopy() *RepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryStatus) DeepCopyInto(out *RepositoryStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.LastRefreshTime.DeepCopyInto(&out.LastRefreshTime)
+	if in.Charts != nil {
+		in, out := &in.Charts, &out.Charts
+		*out = make([]RepositoryChart, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryStatus.
+func (in *RepositoryStatus) DeepCopy() *RepositoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryTLS) DeepCopyInto(out *RepositoryTLS) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryTLS.
+func (in *RepositoryTLS) DeepCopy() *RepositoryTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *State) DeepCopyInto(out *State) {
 	*out = *in
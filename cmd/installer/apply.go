@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	controllers "xiaoshiai.cn/installer/controller"
+)
+
+func NewApplyCmd() *cobra.Command {
+	options := controllers.NewDefaultApplyOptions()
+	var filenames []string
+	namespace := "default"
+	cmd := &cobra.Command{
+		Use:   "apply -f instances.yaml",
+		Short: "sync instances once without the controller",
+		Long: `Sync instances once without the controller, e.g. to bootstrap a cluster.
+
+Instances are synced in the order of their dependencies, after the index of
+the Repositories in the files is refreshed. When the Instance CRD is installed
+they are created or updated in the cluster with their status, so the
+controller takes them over once it runs.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(filenames) == 0 {
+				return fmt.Errorf("at least one file must be specified with -f")
+			}
+			objects, err := controllers.ReadObjects(filenames)
+			if err != nil {
+				return err
+			}
+			var instances []*appsv1.Instance
+			for _, obj := range objects {
+				switch obj := obj.(type) {
+				case *appsv1.Instance:
+					if obj.Namespace == "" {
+						obj.Namespace = namespace
+					}
+					instances = append(instances, obj)
+				case *appsv1.Repository:
+					if obj.Namespace == "" {
+						obj.Namespace = namespace
+					}
+					options.Repositories = append(options.Repositories, obj)
+				}
+			}
+			if len(instances) == 0 {
+				return fmt.Errorf("no instance found")
+			}
+			cfg, err := ctrl.GetConfig()
+			if err != nil {
+				return err
+			}
+			cli, err := client.NewWithWatch(cfg, client.Options{Scheme: controllers.GetScheme()})
+			if err != nil {
+				return err
+			}
+			ctx := logr.NewContext(cmd.Context(), zap.New(zap.UseDevMode(true), zap.WriteTo(cmd.ErrOrStderr())))
+			return controllers.Apply(ctx, cfg, cli, instances, options)
+		},
+	}
+	cmd.Flags().StringSliceVarP(&filenames, "filename", "f", filenames, "files or directories holding instances and repositories, - reads stdin")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace of instances which do not set one")
+	cmd.Flags().StringVarP(&options.CacheDir, "cache-dir", "", options.CacheDir, "cache directory for downloaded bundle charts")
+	cmd.Flags().StringSliceVar(&options.AllowClusterScopedNamespaces, "allow-cluster-scoped-namespaces", options.AllowClusterScopedNamespaces, "namespaces whose instances are allowed to create cluster-scoped resources")
+	cmd.Flags().DurationVar(&options.Timeout, "timeout", options.Timeout, "time to wait for the dependencies of an instance to become ready and for the instance to be synced")
+	return cmd
+}
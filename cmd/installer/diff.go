@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	controllers "xiaoshiai.cn/installer/controller"
+)
+
+func NewDiffCmd() *cobra.Command {
+	options := controllers.NewDefaultDiffOptions()
+	var filenames []string
+	namespace := "default"
+	cmd := &cobra.Command{
+		Use:   "diff [NAME...] [-f instance.yaml]",
+		Short: "show the changes syncing instances would make to the cluster",
+		Long: `Show the changes syncing instances would make to the cluster.
+
+Instances are read from files or from the cluster by name, rendered as the
+controller would and server-side dry-run applied. The objects listed in the
+status of an existing Instance but no longer rendered are shown as pruned.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(filenames) == 0 && len(args) == 0 {
+				return fmt.Errorf("either instance names or files with -f must be specified")
+			}
+			cfg, err := ctrl.GetConfig()
+			if err != nil {
+				return err
+			}
+			cli, err := client.New(cfg, client.Options{Scheme: controllers.GetScheme()})
+			if err != nil {
+				return err
+			}
+			var instances []*appsv1.Instance
+			objects, err := controllers.ReadObjects(filenames)
+			if err != nil {
+				return err
+			}
+			for _, obj := range objects {
+				if instance, ok := obj.(*appsv1.Instance); ok {
+					if instance.Namespace == "" {
+						instance.Namespace = namespace
+					}
+					instances = append(instances, instance)
+				}
+			}
+			for _, name := range args {
+				instance := &appsv1.Instance{}
+				if err := cli.Get(cmd.Context(), client.ObjectKey{Namespace: namespace, Name: name}, instance); err != nil {
+					return err
+				}
+				instances = append(instances, instance)
+			}
+			out, err := controllers.Diff(cmd.Context(), cfg, cli, instances, options)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	cmd.Flags().StringSliceVarP(&filenames, "filename", "f", filenames, "files or directories holding instances, - reads stdin")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace of the instances")
+	cmd.Flags().StringVarP(&options.CacheDir, "cache-dir", "", options.CacheDir, "cache directory for downloaded bundle charts")
+	cmd.Flags().StringSliceVar(&options.AllowClusterScopedNamespaces, "allow-cluster-scoped-namespaces", options.AllowClusterScopedNamespaces, "namespaces whose instances are allowed to create cluster-scoped resources")
+	return cmd
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+	controllers "xiaoshiai.cn/installer/controller"
+)
+
+func NewExportCmd() *cobra.Command {
+	options := &controllers.ExportOptions{Namespace: "default"}
+	cmd := &cobra.Command{
+		Use:   "export --namespace NAMESPACE",
+		Short: "generate instances adopting the helm releases of a namespace",
+		Long: `Generate instances adopting the helm releases of a namespace.
+
+Every release not installed by the installer becomes an Instance with the chart,
+version and user-supplied values of the release and spec.adopt set, so applying
+the output moves the releases under installer management without reinstalling
+them. Releases do not record their chart repository; pass it with --repository
+or --repository-ref.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := ctrl.GetConfig()
+			if err != nil {
+				return err
+			}
+			out, err := controllers.ExportReleases(cmd.Context(), cfg, options)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&options.Namespace, "namespace", "n", options.Namespace, "namespace of the helm releases")
+	cmd.Flags().StringVar(&options.Repository, "repository", options.Repository, "chart repository URL set on the instances")
+	cmd.Flags().StringVar(&options.RepositoryRef, "repository-ref", options.RepositoryRef, "Repository the instances refer to instead of --repository")
+	return cmd
+}
@@ -36,6 +36,11 @@ func NewInstallerCmd() *cobra.Command {
 	cmd.Flags().BoolVarP(&options.LeaderElection, "leader-election", "", options.LeaderElection, "enable leader election")
 	cmd.Flags().StringVarP(&options.LeaderElectionID, "leader-election-id", "", options.LeaderElectionID, "leader election id")
 	cmd.Flags().StringVarP(&options.CacheDir, "cache-dir", "", options.CacheDir, "cache directory for downloaded bundle charts")
+	cmd.Flags().Int64Var(&options.CacheMaxSize, "cache-max-size", options.CacheMaxSize, "maximum total size in bytes of the download cache, 0 means unlimited")
+	cmd.Flags().DurationVar(&options.CacheMaxAge, "cache-max-age", options.CacheMaxAge, "evict cache entries unused for longer than this duration, 0 means never")
+	cmd.Flags().DurationVar(&options.CacheGCInterval, "cache-gc-interval", options.CacheGCInterval, "interval between two cache garbage collections")
+	cmd.Flags().DurationVar(&options.RemovalTimeout, "removal-timeout", options.RemovalTimeout, "time the objects of a deleted instance have to terminate before its removal is reported failed")
 	cmd.Flags().StringSliceVar(&options.AllowClusterScopedNamespaces, "allow-cluster-scoped-namespaces", options.AllowClusterScopedNamespaces, "namespaces whose instances are allowed to create cluster-scoped resources")
+	cmd.AddCommand(NewTemplateCmd(), NewDiffCmd(), NewApplyCmd(), NewExportCmd())
 	return cmd
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	controllers "xiaoshiai.cn/installer/controller"
+)
+
+func NewTemplateCmd() *cobra.Command {
+	options := controllers.NewDefaultTemplateOptions()
+	var filenames []string
+	cmd := &cobra.Command{
+		Use:   "template -f instance.yaml",
+		Short: "render instances as the controller would apply them",
+		Long: `Render instances as the controller would apply them, without a cluster.
+
+The files may also hold the ConfigMaps, Secrets and Repositories the instances
+refer to with valuesFrom, artifact and repositoryRef, and CustomResourceDefinitions
+used to tell namespaced from cluster-scoped resources.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(filenames) == 0 {
+				return fmt.Errorf("at least one file must be specified with -f")
+			}
+			objects, err := controllers.ReadObjects(filenames)
+			if err != nil {
+				return err
+			}
+			out, err := controllers.Template(cmd.Context(), objects, options)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	cmd.Flags().StringSliceVarP(&filenames, "filename", "f", filenames, "files or directories holding instances and the objects they refer to, - reads stdin")
+	cmd.Flags().StringVarP(&options.Namespace, "namespace", "n", options.Namespace, "namespace of objects which do not set one")
+	cmd.Flags().StringVarP(&options.CacheDir, "cache-dir", "", options.CacheDir, "cache directory for downloaded bundle charts")
+	cmd.Flags().StringSliceVar(&options.AllowClusterScopedNamespaces, "allow-cluster-scoped-namespaces", options.AllowClusterScopedNamespaces, "namespaces whose instances are allowed to create cluster-scoped resources")
+	return cmd
+}
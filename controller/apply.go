@@ -0,0 +1,357 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install/delegate"
+)
+
+const (
+	defaultApplyTimeout  = 5 * time.Minute
+	defaultApplyInterval = 5 * time.Second
+	// applyRequeueDelay is the wait before an Instance which asked to be
+	// requeued right away is reconciled again.
+	applyRequeueDelay = 100 * time.Millisecond
+)
+
+// ApplyOptions configures syncing Instances without the controller.
+type ApplyOptions struct {
+	// CacheDir is the directory sources are downloaded into.
+	CacheDir string
+	// AllowClusterScopedNamespaces is the static set of namespaces allowed to
+	// create cluster-scoped resources, as passed to the controller.
+	AllowClusterScopedNamespaces []string
+	// Timeout bounds waiting for the dependencies of an Instance to become
+	// ready and for the Instance to be synced.
+	Timeout time.Duration
+	// Interval is the period between two checks of the dependencies.
+	Interval time.Duration
+	// Repositories are stored and their index refreshed before the Instances
+	// are synced, for the Instances referring to them.
+	Repositories []*appsv1.Repository
+}
+
+func NewDefaultApplyOptions() *ApplyOptions {
+	defaults := NewDefaultOptions()
+	return &ApplyOptions{
+		CacheDir:                     defaults.CacheDir,
+		AllowClusterScopedNamespaces: defaults.AllowClusterScopedNamespaces,
+		Timeout:                      defaultApplyTimeout,
+		Interval:                     defaultApplyInterval,
+	}
+}
+
+// Apply syncs instances once in-process, in the order of their dependencies,
+// after refreshing the index of the Repositories of options. When their CRDs
+// are installed the Instances and Repositories are created or updated in the
+// cluster and their status is written back, so the controller takes them
+// over; otherwise the kinds without a CRD are only kept in memory. Instances depending on a failed
+// Instance are skipped, the errors of all failed Instances are returned.
+func Apply(ctx context.Context, cfg *rest.Config, cli client.WithWatch, instances []*appsv1.Instance, options *ApplyOptions) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	ordered, err := orderInstances(instances)
+	if err != nil {
+		return err
+	}
+	var inMemory []string
+	for _, kind := range []string{"Instance", "Repository"} {
+		_, err := cli.RESTMapper().RESTMapping(appsv1.Kind(kind), appsv1.GroupVersion.Version)
+		switch {
+		case meta.IsNoMatchError(err):
+			log.Info("CRD is not installed, status is kept in memory", "kind", kind)
+			inMemory = append(inMemory, kind)
+		case err != nil:
+			return err
+		}
+	}
+	if len(inMemory) > 0 {
+		cli = withInMemoryKinds(cli, inMemory...)
+	}
+
+	allowNS := make(map[string]struct{}, len(options.AllowClusterScopedNamespaces))
+	for _, ns := range options.AllowClusterScopedNamespaces {
+		allowNS[ns] = struct{}{}
+	}
+	r := &InstanceReconciler{
+		Client:                       cli,
+		Scheme:                       cli.Scheme(),
+		Applier:                      delegate.NewDelegate(cfg, cli, &delegate.Options{CacheDir: options.CacheDir}),
+		CacheDir:                     options.CacheDir,
+		AllowClusterScopedNamespaces: allowNS,
+	}
+
+	var errs []error
+	repositories := &RepositoryReconciler{Client: cli, CacheDir: options.CacheDir}
+	for _, repository := range options.Repositories {
+		key := client.ObjectKeyFromObject(repository)
+		log.Info("refreshing repository", "repository", key)
+		if err := repositories.refreshOnce(ctx, repository); err != nil {
+			// the Instances referring to it report it as not ready
+			log.Error(err, "refresh repository", "repository", key)
+			errs = append(errs, fmt.Errorf("repository %s: %w", key, err))
+		}
+	}
+
+	failed := map[client.ObjectKey]bool{}
+	for _, instance := range ordered {
+		key := client.ObjectKeyFromObject(instance)
+		log := log.WithValues("instance", key)
+		if dep, ok := failedDependency(instance, failed); ok {
+			failed[key] = true
+			errs = append(errs, fmt.Errorf("instance %s: dependency %s failed", key, dep))
+			continue
+		}
+		log.Info("applying instance")
+		if err := r.applyOnce(logr.NewContext(ctx, log), instance, ordered, options); err != nil {
+			log.Error(err, "apply instance")
+			failed[key] = true
+			errs = append(errs, fmt.Errorf("instance %s: %w", key, err))
+			continue
+		}
+		log.Info("applied instance")
+	}
+	return errors.Join(errs...)
+}
+
+// applyOnce stores instance and reconciles it until it is synced, waiting
+// for its dependencies to become ready and for the requeues it asks for.
+func (r *InstanceReconciler) applyOnce(ctx context.Context, instance *appsv1.Instance, instances []*appsv1.Instance, options *ApplyOptions) error {
+	log := logr.FromContextOrDiscard(ctx)
+	if err := r.storeInstance(ctx, instance); err != nil {
+		return err
+	}
+	timeout, interval := options.Timeout, options.Interval
+	if timeout <= 0 {
+		timeout = defaultApplyTimeout
+	}
+	if interval <= 0 {
+		interval = defaultApplyInterval
+	}
+	deadline := time.Now().Add(timeout)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+	for {
+		result, err := r.Reconcile(ctx, req)
+		if err == nil && !result.Requeue && result.RequeueAfter <= 0 {
+			return nil
+		}
+		if err != nil && !errors.As(err, &DependencyError{}) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("not synced after %s", timeout)
+		}
+		wait := interval
+		switch {
+		case err != nil:
+			log.Info("waiting for dependencies", "reason", err.Error())
+		case result.RequeueAfter > 0:
+			wait = result.RequeueAfter
+		default:
+			wait = applyRequeueDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(wait, time.Until(deadline))):
+		}
+		if err == nil {
+			continue
+		}
+		// nothing else refreshes the readiness of the dependencies
+		for _, dep := range instanceDependencies(instance, instances) {
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(dep)}); err != nil {
+				return fmt.Errorf("refresh dependency %s: %w", client.ObjectKeyFromObject(dep), err)
+			}
+		}
+	}
+}
+
+// storeInstance creates instance or updates the spec of the stored Instance.
+func (r *InstanceReconciler) storeInstance(ctx context.Context, instance *appsv1.Instance) error {
+	existing := &appsv1.Instance{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(instance), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		created := instance.DeepCopy()
+		created.Status = appsv1.InstanceStatus{}
+		created.ResourceVersion = ""
+		return r.Client.Create(ctx, created)
+	}
+	if equality.Semantic.DeepEqual(existing.Spec, instance.Spec) &&
+		equality.Semantic.DeepEqual(existing.Labels, instance.Labels) &&
+		equality.Semantic.DeepEqual(existing.Annotations, instance.Annotations) {
+		return nil
+	}
+	existing.Spec = instance.Spec
+	existing.Labels, existing.Annotations = instance.Labels, instance.Annotations
+	return r.Client.Update(ctx, existing)
+}
+
+// refreshOnce stores repository and refreshes its index.
+func (r *RepositoryReconciler) refreshOnce(ctx context.Context, repository *appsv1.Repository) error {
+	existing := &appsv1.Repository{}
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(repository), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		created := repository.DeepCopy()
+		created.Status = appsv1.RepositoryStatus{}
+		created.ResourceVersion = ""
+		if err := r.Client.Create(ctx, created); err != nil {
+			return err
+		}
+	} else if !equality.Semantic.DeepEqual(existing.Spec, repository.Spec) {
+		existing.Spec = repository.Spec
+		if err := r.Client.Update(ctx, existing); err != nil {
+			return err
+		}
+	}
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(repository)})
+	return err
+}
+
+// orderInstances sorts instances so that every Instance follows the Instances
+// it depends on, keeping the given order otherwise.
+func orderInstances(instances []*appsv1.Instance) ([]*appsv1.Instance, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[client.ObjectKey]int, len(instances))
+	ordered := make([]*appsv1.Instance, 0, len(instances))
+	var visit func(instance *appsv1.Instance) error
+	visit = func(instance *appsv1.Instance) error {
+		key := client.ObjectKeyFromObject(instance)
+		switch state[key] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle at instance %s", key)
+		}
+		state[key] = visiting
+		for _, dep := range instanceDependencies(instance, instances) {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[key] = visited
+		ordered = append(ordered, instance)
+		return nil
+	}
+	for _, instance := range instances {
+		if err := visit(instance); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// instanceDependencies returns the dependencies of instance found in instances.
+func instanceDependencies(instance *appsv1.Instance, instances []*appsv1.Instance) []*appsv1.Instance {
+	var deps []*appsv1.Instance
+	for _, key := range instanceDependencyKeys(instance) {
+		for _, candidate := range instances {
+			if client.ObjectKeyFromObject(candidate) == key {
+				deps = append(deps, candidate)
+				break
+			}
+		}
+	}
+	return deps
+}
+
+func instanceDependencyKeys(instance *appsv1.Instance) []client.ObjectKey {
+	var keys []client.ObjectKey
+	for _, dep := range instance.Spec.Dependencies {
+		if dep.Name == "" || (dep.Kind != "" && dep.Kind != "Instance") {
+			continue
+		}
+		if dep.Namespace == "" {
+			dep.Namespace = instance.Namespace
+		}
+		keys = append(keys, client.ObjectKey{Namespace: dep.Namespace, Name: dep.Name})
+	}
+	return keys
+}
+
+func failedDependency(instance *appsv1.Instance, failed map[client.ObjectKey]bool) (client.ObjectKey, bool) {
+	for _, key := range instanceDependencyKeys(instance) {
+		if failed[key] {
+			return key, true
+		}
+	}
+	return client.ObjectKey{}, false
+}
+
+// withInMemoryKinds serves the objects of the apps kinds given, and their
+// lists, from memory and all other objects from cli, for clusters without
+// their CRDs.
+func withInMemoryKinds(cli client.WithWatch, kinds ...string) client.WithWatch {
+	store := fake.NewClientBuilder().
+		WithScheme(cli.Scheme()).
+		WithStatusSubresource(&appsv1.Instance{}, &appsv1.Repository{}).
+		Build()
+	served := map[schema.GroupVersionKind]bool{}
+	for _, kind := range kinds {
+		served[appsv1.GroupVersion.WithKind(kind)] = true
+		served[appsv1.GroupVersion.WithKind(kind+"List")] = true
+	}
+	inMemory := func(obj runtime.Object) bool {
+		gvk, err := apiutil.GVKForObject(obj, cli.Scheme())
+		return err == nil && served[gvk]
+	}
+	return interceptor.NewClient(cli, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if inMemory(obj) {
+				return store.Get(ctx, key, obj, opts...)
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+		List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if inMemory(list) {
+				return store.List(ctx, list, opts...)
+			}
+			return c.List(ctx, list, opts...)
+		},
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if inMemory(obj) {
+				return store.Create(ctx, obj, opts...)
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if inMemory(obj) {
+				return store.Update(ctx, obj, opts...)
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+		SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+			if inMemory(obj) {
+				return store.SubResource(subResourceName).Update(ctx, obj, opts...)
+			}
+			return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+		},
+	})
+}
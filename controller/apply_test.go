@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+func newApplyTestInstance(t *testing.T, namespace, name string, deps ...corev1.ObjectReference) *appsv1.Instance {
+	t.Helper()
+	dir, err := filepath.Abs(filepath.Join("..", "testdata", "kustomize-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.InstanceSpec{
+			Kind:         appsv1.InstanceKindKustomize,
+			URL:          "file://" + dir,
+			Dependencies: deps,
+		},
+	}
+}
+
+func TestOrderInstances(t *testing.T) {
+	a := newApplyTestInstance(t, "apps", "a")
+	b := newApplyTestInstance(t, "apps", "b", corev1.ObjectReference{Name: "a"})
+	c := newApplyTestInstance(t, "other", "c", corev1.ObjectReference{Name: "b", Namespace: "apps"}, corev1.ObjectReference{Name: "external"})
+
+	ordered, err := orderInstances([]*appsv1.Instance{c, b, a})
+	if err != nil {
+		t.Fatalf("order: %v", err)
+	}
+	var names []string
+	for _, instance := range ordered {
+		names = append(names, instance.Name)
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Fatalf("expected dependencies first, got %v", names)
+	}
+
+	a.Spec.Dependencies = []corev1.ObjectReference{{Name: "b"}}
+	if _, err := orderInstances([]*appsv1.Instance{a, b}); err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Fatalf("expected dependency cycle, got %v", err)
+	}
+}
+
+func TestApplyWithoutInstanceCRD(t *testing.T) {
+	scheme := GetScheme()
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithRESTMapper(mapper).Build()
+
+	instances := []*appsv1.Instance{
+		newApplyTestInstance(t, "b", "second", corev1.ObjectReference{Name: "first", Namespace: "a"}),
+		newApplyTestInstance(t, "a", "first"),
+		newApplyTestInstance(t, "c", "missing-dependency", corev1.ObjectReference{Name: "absent"}),
+		newApplyTestInstance(t, "c", "skipped", corev1.ObjectReference{Name: "missing-dependency"}),
+	}
+	options := &ApplyOptions{CacheDir: t.TempDir(), Timeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond}
+	err := Apply(context.Background(), nil, cli, instances, options)
+	if err == nil {
+		t.Fatal("expected the instances with unmet dependencies to fail")
+	}
+	for _, expected := range []string{
+		"instance c/missing-dependency: dependency c/absent",
+		"instance c/skipped: dependency c/missing-dependency failed",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+	for _, ns := range []string{"a", "b"} {
+		cm := &corev1.ConfigMap{}
+		if err := cli.Get(context.Background(), client.ObjectKey{Namespace: ns, Name: "kustomize-test"}, cm); err != nil {
+			t.Errorf("expected configmap applied in namespace %s: %v", ns, err)
+		}
+	}
+}
+
+func TestApplyWritesStatus(t *testing.T) {
+	scheme := GetScheme()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(newOfflineRESTMapper(scheme, nil)).
+		WithStatusSubresource(&appsv1.Instance{}).
+		Build()
+
+	instance := newApplyTestInstance(t, "apps", "demo")
+	if err := Apply(context.Background(), nil, cli, []*appsv1.Instance{instance}, &ApplyOptions{CacheDir: t.TempDir()}); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	stored := &appsv1.Instance{}
+	if err := cli.Get(context.Background(), client.ObjectKeyFromObject(instance), stored); err != nil {
+		t.Fatalf("get instance: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(stored.Status.Conditions, appsv1.ConditionInstalled) {
+		t.Fatalf("expected installed condition, got %#v", stored.Status.Conditions)
+	}
+	if len(stored.Status.Resources) != 1 || stored.Status.Resources[0].Name != "kustomize-test" {
+		t.Fatalf("expected managed configmap in status, got %#v", stored.Status.Resources)
+	}
+	if len(stored.Finalizers) != 1 || stored.Finalizers[0] != FinalizerName {
+		t.Fatalf("expected finalizer for the controller to take over, got %v", stored.Finalizers)
+	}
+}
+
+func TestApplyWithoutRepositoryCRD(t *testing.T) {
+	scheme := GetScheme()
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion, appsv1.GroupVersion})
+	mapper.Add(appsv1.GroupVersion.WithKind("Instance"), meta.RESTScopeNamespace)
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(mapper).
+		WithStatusSubresource(&appsv1.Instance{}).
+		Build()
+
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "apps"},
+		Spec:       appsv1.RepositorySpec{URL: "oci://registry.example.test/charts"},
+	}
+	options := &ApplyOptions{CacheDir: t.TempDir(), Repositories: []*appsv1.Repository{repository}}
+	if err := Apply(context.Background(), nil, cli, nil, options); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if err := cli.Get(context.Background(), client.ObjectKeyFromObject(repository), &appsv1.Repository{}); !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		t.Fatalf("repository written to a cluster without its CRD: %v", err)
+	}
+}
+
+func TestApplyRefreshesRepositories(t *testing.T) {
+	scheme := GetScheme()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(newOfflineRESTMapper(scheme, nil)).
+		WithStatusSubresource(&appsv1.Instance{}, &appsv1.Repository{}).
+		Build()
+
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "apps"},
+		Spec:       appsv1.RepositorySpec{URL: "oci://registry.example.test/charts"},
+	}
+	options := &ApplyOptions{CacheDir: t.TempDir(), Repositories: []*appsv1.Repository{repository}}
+	if err := Apply(context.Background(), nil, cli, nil, options); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	stored := &appsv1.Repository{}
+	if err := cli.Get(context.Background(), client.ObjectKeyFromObject(repository), stored); err != nil {
+		t.Fatalf("get repository: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(stored.Status.Conditions, appsv1.ConditionReady) {
+		t.Fatalf("expected ready repository for the instances referring to it, got %#v", stored.Status.Conditions)
+	}
+}
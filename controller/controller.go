@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
@@ -16,6 +17,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install/download"
 )
 
 func GetScheme() *runtime.Scheme {
@@ -35,12 +37,18 @@ type Options struct {
 
 	CacheDir string `json:"cacheDir,omitempty" description:"The directory to cache downloaded bundle charts."`
 
+	CacheMaxSize    int64         `json:"cacheMaxSize,omitempty" description:"The maximum total size in bytes of the download cache, 0 means unlimited."`
+	CacheMaxAge     time.Duration `json:"cacheMaxAge,omitempty" description:"Evict cache entries unused for longer than this duration, 0 means never."`
+	CacheGCInterval time.Duration `json:"cacheGCInterval,omitempty" description:"The interval between two cache garbage collections."`
+
 	Concurrency int `json:"concurrency,omitempty" description:"The number of concurrent reconciles for each controller."`
 
 	// AllowClusterScopedNamespaces is a list of namespaces whose instances are always allowed
 	// to create cluster-scoped resources. Namespaces not in this list can still be allowed
 	// via the "installer.xiaoshiai.cn/allow-cluster-scoped" annotation on the Namespace.
 	AllowClusterScopedNamespaces []string `json:"allowClusterScopedNamespaces,omitempty" description:"Namespaces allowed to create cluster-scoped resources."`
+
+	RemovalTimeout time.Duration `json:"removalTimeout,omitempty" description:"The time the objects of a deleted instance have to terminate before its removal is reported failed."`
 }
 
 func NewDefaultOptions() *Options {
@@ -51,7 +59,11 @@ func NewDefaultOptions() *Options {
 		LeaderElection:   false,
 		LeaderElectionID: "installer-leader-election",
 		CacheDir:         filepath.Join(home, ".cache", "installer"),
+		CacheMaxSize:     download.DefaultCacheMaxSize,
+		CacheMaxAge:      download.DefaultCacheMaxAge,
+		CacheGCInterval:  download.DefaultCacheGCInterval,
 		Concurrency:      5,
+		RemovalTimeout:   DefaultRemovalTimeout,
 		AllowClusterScopedNamespaces: []string{
 			"rune-system",
 			"kube-system",
@@ -0,0 +1,312 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/controller/postrender"
+	"xiaoshiai.cn/installer/install/delegate"
+	"xiaoshiai.cn/installer/install/native"
+	"xiaoshiai.cn/installer/utils"
+)
+
+type DiffAction string
+
+const (
+	DiffActionCreate DiffAction = "create"
+	DiffActionUpdate DiffAction = "update"
+	DiffActionPrune  DiffAction = "prune"
+)
+
+// DiffOptions configures comparing rendered Instances with the cluster.
+type DiffOptions struct {
+	// CacheDir is the directory sources are downloaded into.
+	CacheDir string
+	// AllowClusterScopedNamespaces is the static set of namespaces allowed to
+	// create cluster-scoped resources, as passed to the controller.
+	AllowClusterScopedNamespaces []string
+	// FieldOwner is the field manager used for the server-side dry-run, the
+	// one of the Instance when empty.
+	FieldOwner string
+}
+
+func NewDefaultDiffOptions() *DiffOptions {
+	defaults := NewDefaultOptions()
+	return &DiffOptions{
+		CacheDir:                     defaults.CacheDir,
+		AllowClusterScopedNamespaces: defaults.AllowClusterScopedNamespaces,
+	}
+}
+
+// ObjectDiff is the change a sync of an Instance makes to a single object.
+type ObjectDiff struct {
+	Action DiffAction
+	Object appsv1.ManagedResource
+	// Live is the object in the cluster, nil if it does not exist.
+	Live *unstructured.Unstructured
+	// Desired is the object after the change, nil if it is pruned.
+	Desired *unstructured.Unstructured
+}
+
+// Unified returns the unified diff between the live and the desired object,
+// empty if they are equal. The data of Secrets is masked.
+func (d ObjectDiff) Unified() (string, error) {
+	liveObj, desiredObj := d.Live, d.Desired
+	if d.Object.APIVersion == "v1" && d.Object.Kind == "Secret" {
+		liveObj, desiredObj = maskSecret(liveObj, desiredObj)
+	}
+	live, err := diffYAML(liveObj)
+	if err != nil {
+		return "", err
+	}
+	desired, err := diffYAML(desiredObj)
+	if err != nil {
+		return "", err
+	}
+	if live == desired {
+		return "", nil
+	}
+	name := diffObjectName(d.Object)
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(live),
+		B:        diffLines(desired),
+		FromFile: "live/" + name,
+		ToFile:   "desired/" + name,
+		Context:  3,
+	})
+}
+
+const (
+	maskedValue       = "***"
+	maskedValueBefore = "*** (before)"
+	maskedValueAfter  = "*** (after)"
+)
+
+// maskSecret replaces the values of the data and stringData of the live and
+// desired Secret with asterisks, as kubectl diff does. A changed value is
+// marked before and after, so the diff still shows which keys change.
+func maskSecret(live, desired *unstructured.Unstructured) (*unstructured.Unstructured, *unstructured.Unstructured) {
+	if live != nil {
+		live = live.DeepCopy()
+	}
+	if desired != nil {
+		desired = desired.DeepCopy()
+	}
+	dataOf := func(obj *unstructured.Unstructured, field string) map[string]any {
+		if obj == nil {
+			return nil
+		}
+		data, _ := obj.Object[field].(map[string]any)
+		return data
+	}
+	for _, field := range []string{"data", "stringData"} {
+		from, to := dataOf(live, field), dataOf(desired, field)
+		for key, value := range from {
+			toValue, ok := to[key]
+			switch {
+			case !ok:
+				from[key] = maskedValue
+			case reflect.DeepEqual(value, toValue):
+				from[key], to[key] = maskedValue, maskedValue
+			default:
+				from[key], to[key] = maskedValueBefore, maskedValueAfter
+			}
+		}
+		for key := range to {
+			if _, ok := from[key]; !ok {
+				to[key] = maskedValue
+			}
+		}
+	}
+	return live, desired
+}
+
+func diffObjectName(ref appsv1.ManagedResource) string {
+	parts := []string{ref.APIVersion, ref.Kind}
+	if ref.Namespace != "" {
+		parts = append(parts, ref.Namespace)
+	}
+	return strings.Join(append(parts, ref.Name), "/")
+}
+
+func diffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return difflib.SplitLines(s)
+}
+
+// diffYAML marshals obj without the fields which change on every write.
+func diffYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	obj = obj.DeepCopy()
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Diff renders instance as a sync would and compares the result with the
+// cluster. Rendered objects are server-side dry-run applied, objects listed in
+// the status or the inventory but no longer rendered are reported as pruned.
+func (r *InstanceReconciler) Diff(ctx context.Context, instance *appsv1.Instance, fieldOwner string) ([]ObjectDiff, error) {
+	rendered, err := r.Template(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := utils.SplitYAML(rendered)
+	if err != nil {
+		return nil, err
+	}
+	// hooks are run by the sync rather than applied
+	if resources, _, err = native.SplitHooks(resources); err != nil {
+		return nil, err
+	}
+	inventory, err := native.ReadInventory(ctx, r.Client, instance.Namespace, instance.Name)
+	if err != nil {
+		return nil, err
+	}
+	managed := append(append([]appsv1.ManagedResource{}, instance.Status.Resources...), inventory.Resources()...)
+	if fieldOwner == "" {
+		fieldOwner = native.FieldManagerOf(instance.Name, instance.Spec.Options)
+	}
+	result := native.DiffWithDefaultNamespace(r.Client, instance.Namespace, managed, resources)
+
+	// the rendered autoscalers may not exist yet
+	autoscalers := append(r.liveAutoscalers(ctx, instance.Namespace), resources...)
+	var diffs []ObjectDiff
+	for _, item := range append(result.Creats, result.Applys...) {
+		diff, err := r.dryRunApply(ctx, item, fieldOwner)
+		if err != nil {
+			return nil, fmt.Errorf("dry-run %s: %w", diffObjectName(appsv1.GetReference(item)), err)
+		}
+		// fields owned by other controllers are not drift
+		for _, obj := range []**unstructured.Unstructured{&diff.Live, &diff.Desired} {
+			if *obj == nil {
+				continue
+			}
+			*obj = (*obj).DeepCopy()
+			if err := postrender.IgnoreDifferences(*obj, instance.Spec.IgnoreDifferences, autoscalers); err != nil {
+				return nil, err
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	for _, item := range result.Removes {
+		// the same objects are kept as when syncing
+		if native.IsCRD(item) {
+			continue
+		}
+		live, err := r.getLive(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		if live == nil || native.IsSkipDelete(live) {
+			continue
+		}
+		diffs = append(diffs, ObjectDiff{Action: DiffActionPrune, Object: appsv1.GetReference(item), Live: live})
+	}
+	return diffs, nil
+}
+
+func (r *InstanceReconciler) dryRunApply(ctx context.Context, item *unstructured.Unstructured, fieldOwner string) (ObjectDiff, error) {
+	diff := ObjectDiff{Object: appsv1.GetReference(item)}
+	live, err := r.getLive(ctx, item)
+	if err != nil {
+		return diff, err
+	}
+	diff.Live = live
+	if live == nil {
+		diff.Action = DiffActionCreate
+	} else {
+		diff.Action = DiffActionUpdate
+	}
+	if live != nil && native.IsSkipUpdate(item) {
+		diff.Desired = live
+		return diff, nil
+	}
+	desired := item.DeepCopy()
+	desired.SetManagedFields(nil)
+	err = r.Client.Patch(ctx, desired, client.Apply, client.DryRunAll, client.FieldOwner(fieldOwner), client.ForceOwnership)
+	if err != nil {
+		// the namespace is created on sync
+		if apierrors.IsNotFound(err) && live == nil {
+			diff.Desired = item
+			return diff, nil
+		}
+		return diff, err
+	}
+	diff.Desired = desired
+	return diff, nil
+}
+
+func (r *InstanceReconciler) getLive(ctx context.Context, item *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(item.GroupVersionKind())
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(item), live); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return live, nil
+}
+
+// Diff compares the Instances with the cluster cfg connects to and returns
+// the unified diff of every changed object. The status of an Instance already
+// in the cluster is used to find the objects it would prune.
+func Diff(ctx context.Context, cfg *rest.Config, cli client.Client, instances []*appsv1.Instance, options *DiffOptions) ([]byte, error) {
+	allowNS := make(map[string]struct{}, len(options.AllowClusterScopedNamespaces))
+	for _, ns := range options.AllowClusterScopedNamespaces {
+		allowNS[ns] = struct{}{}
+	}
+	r := &InstanceReconciler{
+		Client:                       cli,
+		Scheme:                       cli.Scheme(),
+		Applier:                      delegate.NewDelegate(cfg, cli, &delegate.Options{CacheDir: options.CacheDir}),
+		CacheDir:                     options.CacheDir,
+		AllowClusterScopedNamespaces: allowNS,
+	}
+	out := &bytes.Buffer{}
+	for _, instance := range instances {
+		existing := &appsv1.Instance{}
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(instance), existing); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+		} else {
+			instance.Status = existing.Status
+		}
+		diffs, err := r.Diff(ctx, instance, options.FieldOwner)
+		if err != nil {
+			return nil, fmt.Errorf("diff instance %s/%s: %w", instance.Namespace, instance.Name, err)
+		}
+		for _, diff := range diffs {
+			unified, err := diff.Unified()
+			if err != nil {
+				return nil, err
+			}
+			if unified == "" {
+				continue
+			}
+			fmt.Fprintf(out, "# %s %s (instance %s/%s)\n", diff.Action, diffObjectName(diff.Object), instance.Namespace, instance.Name)
+			out.WriteString(unified)
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+func TestDiff(t *testing.T) {
+	chartDir := writeTemplateChart(t)
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "platform"},
+		Spec: appsv1.InstanceSpec{
+			Kind:   appsv1.InstanceKindHelm,
+			URL:    "file://" + chartDir,
+			Values: appsv1.Values{Object: map[string]any{"greeting": "hello", "token": "t"}},
+			// the token is rotated by another controller
+			IgnoreDifferences: []appsv1.IgnoreDifference{{Kind: "ConfigMap", Name: "demo", JSONPointers: []string{"/data/token"}}},
+		},
+		Status: appsv1.InstanceStatus{
+			Resources: []appsv1.ManagedResource{
+				{APIVersion: "v1", Kind: "ConfigMap", Namespace: "platform", Name: "demo"},
+				{APIVersion: "v1", Kind: "ConfigMap", Namespace: "platform", Name: "stale"},
+			},
+		},
+	}
+	current := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "platform", Labels: map[string]string{"app.kubernetes.io/instance": "demo"}},
+		Data:       map[string]string{"greeting": "old", "token": "rotated"},
+	}
+	stale := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "platform"}}
+
+	scheme := GetScheme()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(newOfflineRESTMapper(scheme, nil)).
+		WithObjects(instance, current, stale).
+		Build()
+
+	// the local instance has no status, it is read from the cluster
+	local := instance.DeepCopy()
+	local.Status = appsv1.InstanceStatus{}
+	options := &DiffOptions{CacheDir: t.TempDir(), AllowClusterScopedNamespaces: []string{"platform"}}
+	out, err := Diff(context.Background(), nil, cli, []*appsv1.Instance{local}, options)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	for _, expected := range []string{
+		"# update v1/ConfigMap/platform/demo (instance platform/demo)",
+		"-  greeting: old\n+  greeting: hello\n",
+		"# create rbac.authorization.k8s.io/v1/ClusterRole/demo (instance platform/demo)",
+		"+++ desired/rbac.authorization.k8s.io/v1/ClusterRole/demo",
+		"# prune v1/ConfigMap/platform/stale (instance platform/demo)",
+		"-  name: stale\n",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("expected %q in diff:\n%s", expected, out)
+		}
+	}
+
+	if strings.Contains(string(out), "token") {
+		t.Errorf("ignored field reported in diff:\n%s", out)
+	}
+
+	live := &corev1.ConfigMap{}
+	if err := cli.Get(context.Background(), client.ObjectKeyFromObject(current), live); err != nil {
+		t.Fatal(err)
+	}
+	if live.Data["greeting"] != "old" {
+		t.Fatalf("expected diff not to modify the cluster, got %v", live.Data)
+	}
+}
+
+func TestUnifiedMasksSecretData(t *testing.T) {
+	secret := func(data map[string]any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]any{"name": "demo", "namespace": "platform"},
+			"data":       data,
+		}}
+	}
+	live := secret(map[string]any{"kept": "a2VwdA==", "rotated": "b2xk", "dropped": "ZHJvcHBlZA=="})
+	desired := secret(map[string]any{"kept": "a2VwdA==", "rotated": "bmV3", "added": "YWRkZWQ="})
+	diff := ObjectDiff{Action: DiffActionUpdate, Object: appsv1.GetReference(live), Live: live, Desired: desired}
+	out, err := diff.Unified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"a2VwdA==", "b2xk", "bmV3", "ZHJvcHBlZA==", "YWRkZWQ="} {
+		if strings.Contains(out, value) {
+			t.Errorf("secret value %q in diff:\n%s", value, out)
+		}
+	}
+	for _, expected := range []string{
+		"-  dropped: '***'\n",
+		"+  added: '***'\n",
+		"-  rotated: '*** (before)'\n+  rotated: '*** (after)'\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in diff:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "-  kept") || strings.Contains(out, "+  kept") {
+		t.Errorf("unchanged key reported in diff:\n%s", out)
+	}
+	if live.Object["data"].(map[string]any)["kept"] != "a2VwdA==" {
+		t.Error("masking modified the live object")
+	}
+}
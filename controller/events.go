@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// eventDedupInterval is the interval an identical event of an object is not
+// recorded again, so dependency waits and removal polls do not flood events.
+const eventDedupInterval = 5 * time.Minute
+
+type eventKey struct {
+	object    string
+	eventtype string
+	reason    string
+	message   string
+}
+
+// dedupRecorder records an identical event of an object at most once per
+// interval.
+type dedupRecorder struct {
+	record.EventRecorder
+	interval time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	recorded  map[eventKey]time.Time
+	lastPrune time.Time
+}
+
+func newDedupRecorder(recorder record.EventRecorder) *dedupRecorder {
+	return &dedupRecorder{
+		EventRecorder: recorder,
+		interval:      eventDedupInterval,
+		now:           time.Now,
+		recorded:      map[eventKey]time.Time{},
+	}
+}
+
+func (r *dedupRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.recent(object, eventtype, reason, message) {
+		return
+	}
+	r.EventRecorder.Event(object, eventtype, reason, message)
+}
+
+func (r *dedupRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...any) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *dedupRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...any) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if r.recent(object, eventtype, reason, message) {
+		return
+	}
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+}
+
+// recent reports whether the event was recorded within the interval, and
+// marks it recorded otherwise.
+func (r *dedupRecorder) recent(object runtime.Object, eventtype, reason, message string) bool {
+	key := eventKey{eventtype: eventtype, reason: reason, message: message}
+	if accessor, err := meta.Accessor(object); err == nil {
+		key.object = string(accessor.GetUID())
+		if key.object == "" {
+			key.object = accessor.GetNamespace() + "/" + accessor.GetName()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.lastPrune) >= r.interval {
+		for k, recorded := range r.recorded {
+			if now.Sub(recorded) >= r.interval {
+				delete(r.recorded, k)
+			}
+		}
+		r.lastPrune = now
+	}
+	if recorded, ok := r.recorded[key]; ok && now.Sub(recorded) < r.interval {
+		return true
+	}
+	r.recorded[key] = now
+	return false
+}
+
+// instanceRecorder records the events of an installer on the Instance.
+type instanceRecorder struct {
+	recorder record.EventRecorder
+	instance *appsv1.Instance
+}
+
+func (r instanceRecorder) Eventf(eventtype, reason, messageFmt string, args ...any) {
+	r.recorder.Eventf(r.instance, eventtype, reason, messageFmt, args...)
+}
+
+// recorderFor returns the recorder of the installer for instance, nil when
+// the reconciler records no events.
+func (r *InstanceReconciler) recorderFor(instance *appsv1.Instance) install.Recorder {
+	if r.Recorder == nil {
+		return nil
+	}
+	return instanceRecorder{recorder: r.Recorder, instance: instance}
+}
+
+// eventf records an event on instance.
+func (r *InstanceReconciler) eventf(instance *appsv1.Instance, eventtype, reason, messageFmt string, args ...any) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(instance, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// phaseEventType is the type of the event of a transition to phase.
+func phaseEventType(phase appsv1.Phase) string {
+	switch phase {
+	case appsv1.PhaseFailed, appsv1.PhaseDegraded, appsv1.PhaseUnhealthy, appsv1.PhasePartialFailed:
+		return corev1.EventTypeWarning
+	default:
+		return corev1.EventTypeNormal
+	}
+}
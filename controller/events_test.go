@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestDedupRecorder(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := newDedupRecorder(fakeRecorder)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+
+	demo := &appsv1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "1"}}
+	other := &appsv1.Instance{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "2"}}
+
+	recorder.Eventf(demo, corev1.EventTypeWarning, "DependencyNotReady", "Waiting for %s", "db")
+	recorder.Eventf(demo, corev1.EventTypeWarning, "DependencyNotReady", "Waiting for %s", "db")
+	recorder.Eventf(other, corev1.EventTypeWarning, "DependencyNotReady", "Waiting for %s", "db")
+	recorder.Eventf(demo, corev1.EventTypeWarning, "DependencyNotReady", "Waiting for %s", "cache")
+	now = now.Add(eventDedupInterval)
+	recorder.Eventf(demo, corev1.EventTypeWarning, "DependencyNotReady", "Waiting for %s", "db")
+
+	expected := []string{
+		"Warning DependencyNotReady Waiting for db",
+		"Warning DependencyNotReady Waiting for db",
+		"Warning DependencyNotReady Waiting for cache",
+		"Warning DependencyNotReady Waiting for db",
+	}
+	events := recordedEvents(fakeRecorder)
+	if len(events) != len(expected) {
+		t.Fatalf("recorded events %q, want %q", events, expected)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d = %q, want %q", i, events[i], expected[i])
+		}
+	}
+	if len(recorder.recorded) != 1 {
+		t.Errorf("expired events are not pruned, %d remembered", len(recorder.recorded))
+	}
+}
+
+func TestSyncRecordsApplyEvents(t *testing.T) {
+	fakeRecorder := record.NewFakeRecorder(10)
+	reconciler := &InstanceReconciler{
+		Client:                       fake.NewClientBuilder().WithScheme(GetScheme()).Build(),
+		Applier:                      &countingInstaller{},
+		AllowClusterScopedNamespaces: map[string]struct{}{},
+		Recorder:                     fakeRecorder,
+	}
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 2},
+		Spec: appsv1.InstanceSpec{
+			Kind: appsv1.InstanceKindHelm,
+			URL:  "oci://example.test/demo",
+		},
+		Status: appsv1.InstanceStatus{Phase: appsv1.PhaseReconciling},
+	}
+	if err := reconciler.Sync(context.Background(), instance); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	expected := []string{
+		"Normal Applying Applying generation 2",
+		"Normal Applied Applied generation 2 with 0 objects",
+		"Normal PhaseChanged Phase changed from Reconciling to Installed",
+	}
+	events := recordedEvents(fakeRecorder)
+	if len(events) != len(expected) {
+		t.Fatalf("recorded events %q, want %q", events, expected)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d = %q, want %q", i, events[i], expected[i])
+		}
+	}
+
+	// the phase of an unchanged instance is not recorded again
+	instance.Status.ObservedGeneration = instance.Generation
+	if err := reconciler.Sync(context.Background(), instance); err != nil {
+		t.Fatalf("unchanged Sync() error = %v", err)
+	}
+	if events := recordedEvents(fakeRecorder); len(events) != 0 {
+		t.Errorf("unchanged sync recorded %q", events)
+	}
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install/helm"
+)
+
+// ExportOptions configures generating Instances from helm releases.
+type ExportOptions struct {
+	// Namespace is the namespace the releases are stored in.
+	Namespace string
+	// Repository is the chart repository URL set on the Instances, helm
+	// releases do not record where their chart was pulled from.
+	Repository string
+	// RepositoryRef is the Repository the Instances refer to instead of Repository.
+	RepositoryRef string
+}
+
+// ExportReleases reads the helm releases in the namespace of options and
+// returns an Instance adopting each of them as YAML. Releases installed by the
+// installer are skipped.
+func ExportReleases(ctx context.Context, cfg *rest.Config, options *ExportOptions) ([]byte, error) {
+	releases, err := helm.ListReleases(ctx, cfg, options.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("list releases: %w", err)
+	}
+	out := &bytes.Buffer{}
+	for _, rls := range releases {
+		if _, ok := rls.Labels[helm.DesiredStateLabel]; ok {
+			continue
+		}
+		instance := InstanceFromRelease(rls, options)
+		data, err := marshalInstance(instance)
+		if err != nil {
+			return nil, fmt.Errorf("export release %s: %w", rls.Name, err)
+		}
+		out.WriteString("---\n")
+		if instance.Spec.URL == "" && instance.Spec.RepositoryRef == nil {
+			fmt.Fprintf(out, "# the chart source of release %s is unknown, set spec.url or spec.repositoryRef\n", rls.Name)
+		}
+		out.Write(data)
+	}
+	return out.Bytes(), nil
+}
+
+// InstanceFromRelease returns an Instance adopting rls, with the chart and
+// version of the release and the values supplied by the user. The chart is
+// pulled from Repository joined with its name when Repository is an OCI
+// registry.
+func InstanceFromRelease(rls *release.Release, options *ExportOptions) *appsv1.Instance {
+	instance := &appsv1.Instance{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.GroupVersion.String(),
+			Kind:       "Instance",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: rls.Name, Namespace: rls.Namespace},
+		Spec: appsv1.InstanceSpec{
+			Kind:   appsv1.InstanceKindHelm,
+			URL:    options.Repository,
+			Values: appsv1.Values{Object: rls.Config},
+			Adopt:  &appsv1.Adopt{},
+		},
+	}
+	if options.RepositoryRef != "" {
+		instance.Spec.URL = ""
+		instance.Spec.RepositoryRef = &corev1.LocalObjectReference{Name: options.RepositoryRef}
+	}
+	if rls.Chart != nil && rls.Chart.Metadata != nil {
+		instance.Spec.Chart = rls.Chart.Metadata.Name
+		instance.Spec.Version = rls.Chart.Metadata.Version
+	}
+	if instance.Spec.URL != "" {
+		instance.Spec.URL = helm.ChartURL(instance.Spec.URL, instance.Spec.Chart)
+	}
+	return instance
+}
+
+// marshalInstance marshals instance without its status and server-set metadata.
+func marshalInstance(instance *appsv1.Instance) ([]byte, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(instance)
+	if err != nil {
+		return nil, err
+	}
+	delete(obj, "status")
+	if metadata, ok := obj["metadata"].(map[string]any); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return yaml.Marshal(obj)
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+func TestInstanceFromRelease(t *testing.T) {
+	rls := &release.Release{
+		Name:      "nginx",
+		Namespace: "web",
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "nginx", Version: "10.2.1"}},
+		Config:    map[string]any{"replicaCount": float64(2)},
+	}
+	instance := InstanceFromRelease(rls, &ExportOptions{Namespace: "web", Repository: "oci://registry.example.com/charts"})
+	data, err := marshalInstance(instance)
+	if err != nil {
+		t.Fatalf("marshalInstance() error = %v", err)
+	}
+	if strings.Contains(string(data), "status") || strings.Contains(string(data), "creationTimestamp") {
+		t.Fatalf("exported instance has server-set fields:\n%s", data)
+	}
+	got := &appsv1.Instance{}
+	if err := yaml.Unmarshal(data, got); err != nil {
+		t.Fatalf("unmarshal exported instance: %v", err)
+	}
+	if got.Kind != "Instance" || got.Name != "nginx" || got.Namespace != "web" {
+		t.Fatalf("metadata = %v %s/%s", got.TypeMeta, got.Namespace, got.Name)
+	}
+	if got.Spec.URL != "oci://registry.example.com/charts/nginx" || got.Spec.Chart != "nginx" || got.Spec.Version != "10.2.1" {
+		t.Fatalf("source = %q %q %q", got.Spec.URL, got.Spec.Chart, got.Spec.Version)
+	}
+	if got.Spec.Values.Object["replicaCount"] != float64(2) {
+		t.Fatalf("values = %#v", got.Spec.Values.Object)
+	}
+	if got.Spec.Adopt == nil {
+		t.Fatal("exported instance does not adopt the release")
+	}
+	if err := validateInstanceSource(got); err != nil {
+		t.Fatalf("exported instance is invalid: %v", err)
+	}
+
+	instance = InstanceFromRelease(rls, &ExportOptions{Repository: "https://charts.example.com"})
+	if instance.Spec.URL != "https://charts.example.com" {
+		t.Fatalf("chart repository url = %q, want the repository", instance.Spec.URL)
+	}
+
+	instance = InstanceFromRelease(rls, &ExportOptions{Repository: "https://charts.example.com", RepositoryRef: "charts"})
+	if instance.Spec.URL != "" || instance.Spec.RepositoryRef == nil || instance.Spec.RepositoryRef.Name != "charts" {
+		t.Fatalf("repositoryRef source = %q %#v", instance.Spec.URL, instance.Spec.RepositoryRef)
+	}
+}
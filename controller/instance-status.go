@@ -11,8 +11,6 @@ import (
 	"strings"
 
 	"github.com/go-logr/logr"
-	k8sappsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,29 +18,29 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"xiaoshiai.cn/installer/apis/apps"
 	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install/health"
 )
 
 const (
-	StateStatusDegraded = "Degraded"
-	StateStatusUpdating = "Updating"
-	StateStatusScaling  = "Scaling"
-
-	StateStatusPaused  = "Paused"
-	StateStatusUnknown = "Unknown"
-
-	StateStatusPending          = "Pending"
-	StateStatusCrashLoopBackOff = "CrashLoopBackOff"
-	StateStatusFailed           = "Failed"
-	StateStatusUnhealthy        = "Unhealthy"
-	StateStatusError            = "Error"
-
-	StateStatusSucceeded = "Succeeded"
-	StateStatusActive    = "Active"
-	StateStatusHealthy   = "Healthy"
-	StateCompleted       = "Completed"
-	StateStatusRunning   = "Running"
+	StateStatusDegraded = health.StatusDegraded
+	StateStatusUpdating = health.StatusUpdating
+	StateStatusScaling  = health.StatusScaling
+
+	StateStatusPaused  = health.StatusPaused
+	StateStatusUnknown = health.StatusUnknown
+
+	StateStatusPending          = health.StatusPending
+	StateStatusCrashLoopBackOff = health.StatusCrashLoopBackOff
+	StateStatusFailed           = health.StatusFailed
+	StateStatusUnhealthy        = health.StatusUnhealthy
+	StateStatusError            = health.StatusError
+
+	StateStatusSucceeded = health.StatusSucceeded
+	StateStatusActive    = health.StatusActive
+	StateStatusHealthy   = health.StatusHealthy
+	StateCompleted       = health.StatusCompleted
+	StateStatusRunning   = health.StatusRunning
 )
 
 const (
@@ -70,6 +68,7 @@ func (r *InstanceReconciler) syncStatus(ctx context.Context, instance *appsv1.In
 		logr.FromContextOrDiscard(ctx).Error(expressionErr, "check annotations failed")
 	}
 
+	previous := instance.Status.Phase
 	paused := getmap(instance.Status.Values.Object, "global", "paused")
 	if paused == true || paused == "true" {
 		instance.Status.Phase = appsv1.PhasePaused
@@ -84,7 +83,9 @@ func (r *InstanceReconciler) syncStatus(ctx context.Context, instance *appsv1.In
 			r.setCondition(instance, appsv1.ConditionReady, metav1.ConditionFalse, string(instance.Status.Phase), instance.Status.Message)
 		}
 	}
-
+	if phase := instance.Status.Phase; phase != previous {
+		r.eventf(instance, phaseEventType(phase), "PhaseChanged", "Phase changed from %s to %s", previous, phase)
+	}
 	return nil
 }
 
@@ -266,155 +267,12 @@ func getUnhealthyMessage(states []appsv1.State) string {
 }
 
 func isStateHealthy(status string) bool {
-	switch status {
-	case StateStatusRunning, StateStatusHealthy, StateStatusActive, StateStatusSucceeded, StateCompleted:
-		return true
-	}
-	return false
+	return health.IsHealthy(status)
 }
 
 // GetDefaultStates returns default workload states for resources
 func GetDefaultStates(resources []*unstructured.Unstructured) []appsv1.State {
-	var states []appsv1.State
-	for _, resource := range resources {
-		var state appsv1.State
-		switch resource.GroupVersionKind().GroupKind() {
-		case schema.GroupKind{Group: "batch", Kind: "Job"}:
-			state = getJobState(resource)
-		case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
-			state = getDeploymentState(resource)
-		case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
-			state = getStatefulSetState(resource)
-		case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
-			state = getDaemonSetState(resource)
-		case schema.GroupKind{Group: "core", Kind: "Pod"}:
-			state = getPodState(resource)
-		case schema.GroupKind{Group: apps.GroupName, Kind: "Instance"}:
-			state = getInstanceState(resource)
-		default:
-			continue
-		}
-		states = append(states, state)
-	}
-	return states
-}
-
-func getJobState(resource *unstructured.Unstructured) appsv1.State {
-	job := &batchv1.Job{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, job); err != nil {
-		return appsv1.State{}
-	}
-	state := appsv1.State{Name: job.Name, Kind: "Job"}
-	for _, c := range job.Status.Conditions {
-		if c.Type == batchv1.JobSuspended && c.Status == corev1.ConditionTrue {
-			state.Status = StateStatusPaused
-			return state
-		}
-		if c.Type == batchv1.JobComplete && c.Status == corev1.ConditionTrue {
-			state.Status = StateStatusSucceeded
-			return state
-		}
-		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
-			state.Status = StateStatusFailed
-			state.Message = c.Message
-			return state
-		}
-	}
-	state.Status = StateStatusRunning
-	return state
-}
-
-func getInstanceState(resource *unstructured.Unstructured) appsv1.State {
-	instance := &appsv1.Instance{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, instance); err != nil {
-		return appsv1.State{}
-	}
-	state := appsv1.State{Name: instance.Name, Kind: "Instance"}
-	state.Status = string(instance.Status.Phase)
-	return state
-}
-
-func getDeploymentState(resource *unstructured.Unstructured) appsv1.State {
-	deployment := &k8sappsv1.Deployment{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, deployment); err != nil {
-		return appsv1.State{}
-	}
-	state := appsv1.State{
-		Name:   deployment.Name,
-		Kind:   "Deployment",
-		Status: calcReplicasState(deployment.Status.Replicas, deployment.Status.ReadyReplicas),
-	}
-	messages := []string{}
-	for _, c := range deployment.Status.Conditions {
-		if c.Type == k8sappsv1.DeploymentAvailable && c.Status == corev1.ConditionFalse {
-			messages = append(messages, c.Message)
-		}
-		if c.Type == k8sappsv1.DeploymentReplicaFailure && c.Status == corev1.ConditionTrue {
-			messages = append(messages, c.Message)
-		}
-	}
-	if len(messages) > 0 {
-		state.Message = strings.Join(messages, "\n")
-	}
-	return state
-}
-
-func getStatefulSetState(resource *unstructured.Unstructured) appsv1.State {
-	statefulset := &k8sappsv1.StatefulSet{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, statefulset); err != nil {
-		return appsv1.State{}
-	}
-	state := appsv1.State{
-		Name:   statefulset.Name,
-		Kind:   "StatefulSet",
-		Status: calcReplicasState(statefulset.Status.Replicas, statefulset.Status.ReadyReplicas),
-	}
-	return state
-}
-
-func calcReplicasState(desired int32, ready int32) string {
-	if desired == 0 {
-		return StateStatusPaused
-	}
-	if ready == desired {
-		return StateStatusRunning
-	}
-	if ready == 0 {
-		return StateStatusUnhealthy
-	}
-	return StateStatusDegraded
-}
-
-func getDaemonSetState(resource *unstructured.Unstructured) appsv1.State {
-	daemonset := &k8sappsv1.DaemonSet{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, daemonset); err != nil {
-		return appsv1.State{}
-	}
-	return appsv1.State{
-		Name:   daemonset.Name,
-		Kind:   "DaemonSet",
-		Status: calcReplicasState(daemonset.Status.DesiredNumberScheduled, daemonset.Status.NumberReady),
-	}
-}
-
-func getPodState(resource *unstructured.Unstructured) appsv1.State {
-	pod := &corev1.Pod{}
-	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, pod); err != nil {
-		return appsv1.State{}
-	}
-	state := appsv1.State{Name: pod.Name, Kind: "Pod"}
-	switch pod.Status.Phase {
-	case corev1.PodSucceeded:
-		state.Status = StateStatusSucceeded
-	case corev1.PodFailed:
-		state.Status = StateStatusFailed
-		state.Message = pod.Status.Message
-	case corev1.PodRunning:
-		state.Status = StateStatusRunning
-	default:
-		state.Status = StateStatusPending
-	}
-	return state
+	return health.States(resources)
 }
 
 // GetDefaultEndpoints extracts endpoints from managed resources and uses the
@@ -6,10 +6,14 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"maps"
 	"net/url"
+	"path"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,8 +24,10 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -36,6 +42,9 @@ import (
 	"xiaoshiai.cn/installer/controller/postrender"
 	"xiaoshiai.cn/installer/install"
 	"xiaoshiai.cn/installer/install/delegate"
+	"xiaoshiai.cn/installer/install/download"
+	"xiaoshiai.cn/installer/install/helm"
+	"xiaoshiai.cn/installer/install/native"
 	"xiaoshiai.cn/installer/utils"
 )
 
@@ -45,6 +54,24 @@ const (
 	// AnnotationAllowClusterScoped is a namespace annotation that, when set to "true",
 	// allows instances in that namespace to create cluster-scoped resources.
 	AnnotationAllowClusterScoped = apps.GroupName + "/allow-cluster-scoped"
+
+	// AnnotationAdopt is an instance annotation enabling adoption without
+	// spec.adopt: "true" adopts with the defaults, any other non-false value
+	// is the release to adopt as "[namespace/]name".
+	AnnotationAdopt = apps.GroupName + "/adopt"
+
+	// DefaultRemovalTimeout is the default time the objects of a deleted
+	// Instance have to terminate before its removal is reported failed.
+	DefaultRemovalTimeout = 10 * time.Minute
+	// removalPollInterval is the interval of the checks of the objects of a
+	// deleted Instance still terminating.
+	removalPollInterval = 5 * time.Second
+	// waitPollInterval is the interval of the checks of the objects an
+	// apply or removal waits for.
+	waitPollInterval = 5 * time.Second
+	// maxRemovingInMessage is the number of terminating objects listed in
+	// the status message, all are listed in status.removing.
+	maxRemovingInMessage = 5
 )
 
 func Setup(ctx context.Context, mgr ctrl.Manager, options *Options) error {
@@ -58,12 +85,30 @@ func Setup(ctx context.Context, mgr ctrl.Manager, options *Options) error {
 		DynamicWatchEventHandler{Client: cli}.Handler(),
 		predicate.ResourceVersionChangedPredicate{})
 
+	cache := download.NewCacheManager(options.CacheDir)
+	cache.MaxSize, cache.MaxAge, cache.Interval = options.CacheMaxSize, options.CacheMaxAge, options.CacheGCInterval
+	cache.InUse = func(ctx context.Context) (map[string]struct{}, error) {
+		return instanceCacheKeys(ctx, cli, options.CacheDir)
+	}
+	if err := mgr.Add(cache); err != nil {
+		return err
+	}
+
 	r := &InstanceReconciler{
 		Client:                       cli,
 		Scheme:                       mgr.GetScheme(),
-		Applier:                      delegate.NewDelegate(cfg, cli, &delegate.Options{CacheDir: options.CacheDir}),
+		Applier:                      delegate.NewDelegate(cfg, cli, &delegate.Options{CacheDir: options.CacheDir, Cache: cache}),
 		DynamicSources:               dynamicSources,
+		CacheDir:                     options.CacheDir,
 		AllowClusterScopedNamespaces: allowNS,
+		RemovalTimeout:               options.RemovalTimeout,
+		Recorder:                     newDedupRecorder(mgr.GetEventRecorderFor("installer")),
+	}
+	if err := ctrl.NewControllerManagedBy(mgr).
+		For(&appsv1.Repository{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: options.Concurrency}).
+		Complete(&RepositoryReconciler{Client: cli, CacheDir: options.CacheDir}); err != nil {
+		return err
 	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&appsv1.Instance{}).
@@ -74,10 +119,46 @@ func Setup(ctx context.Context, mgr ctrl.Manager, options *Options) error {
 		WatchesRawSource(
 			source.TypedKind(mgr.GetCache(), &corev1.Secret{}, ValueFromEventHandler[*corev1.Secret](cli, "Secret")),
 		).
+		WatchesRawSource(
+			source.TypedKind(mgr.GetCache(), &appsv1.Repository{}, RepositoryEventHandler(cli), RepositoryChangedPredicate()),
+		).
 		WatchesRawSource(dynamicSources).
 		Complete(r)
 }
 
+// instanceCacheKeys returns the download cache keys of all Instances, so the
+// cache never evicts a source an Instance may need to reapply or remove, and
+// the directories of all Repositories, which hold their index and certificates.
+func instanceCacheKeys(ctx context.Context, cli client.Client, cacheDir string) (map[string]struct{}, error) {
+	instances := &appsv1.InstanceList{}
+	if err := cli.List(ctx, instances); err != nil {
+		return nil, err
+	}
+	repositories := &appsv1.RepositoryList{}
+	if err := cli.List(ctx, repositories); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]struct{}, len(instances.Items)+len(repositories.Items))
+	urls := make(map[client.ObjectKey]string, len(repositories.Items))
+	for _, repository := range repositories.Items {
+		urls[client.ObjectKeyFromObject(&repository)] = repository.Spec.URL
+		keys[repositoryCacheDir(cacheDir, repository.Namespace, repository.Name)] = struct{}{}
+	}
+	for _, instance := range instances.Items {
+		spec := installerInstanceFrom(&instance, nil, nil)
+		if ref := instance.Spec.RepositoryRef; ref != nil {
+			if url := urls[client.ObjectKey{Namespace: instance.Namespace, Name: ref.Name}]; url != "" {
+				spec.Repository = helm.ChartURL(url, repositoryChart(spec))
+			}
+		}
+		if instance.Spec.Artifact != nil || spec.Repository == "" {
+			continue
+		}
+		keys[download.CacheKey(cacheDir, spec)] = struct{}{}
+	}
+	return keys, nil
+}
+
 type DynamicWatchEventHandler struct {
 	Client client.Client
 }
@@ -91,7 +172,7 @@ func (d DynamicWatchEventHandler) Handler() handler.TypedEventHandler[client.Obj
 		if labels == nil {
 			return nil
 		}
-		instanceName, ok := labels["app.kubernetes.io/instance"]
+		instanceName, ok := labels[install.LabelInstance]
 		if !ok {
 			return nil
 		}
@@ -110,20 +191,28 @@ func (d DynamicWatchEventHandler) Handler() handler.TypedEventHandler[client.Obj
 }
 
 // ValueFromEventHandler returns an event handler that enqueues reconcile requests
-// for all Instances in the same namespace whose ValuesFrom references the changed object.
+// for all Instances in the same namespace whose ValuesFrom references the changed object,
+// or whose Repository holds its credentials or certificates in the changed Secret.
 // kind must be passed explicitly because cached objects do not carry GVK.
 func ValueFromEventHandler[T client.Object](cli client.Client, kind string) handler.TypedEventHandler[T, reconcile.Request] {
 	return handler.TypedEnqueueRequestsFromMapFunc(handler.TypedMapFunc[T, reconcile.Request](func(ctx context.Context, obj T) []reconcile.Request {
 		instances := &appsv1.InstanceList{}
 		_ = cli.List(ctx, instances, client.InNamespace(obj.GetNamespace()))
+		var repositories map[string]bool
+		if strings.EqualFold(kind, "Secret") {
+			repositories = repositoriesUsingSecret(ctx, cli, obj.GetNamespace(), obj.GetName())
+		}
 		requests := map[client.ObjectKey]struct{}{}
 		for _, b := range instances.Items {
+			if ref := b.Spec.RepositoryRef; ref != nil && repositories[ref.Name] {
+				requests[client.ObjectKeyFromObject(&b)] = struct{}{}
+			}
 			for _, ref := range b.Spec.ValuesFrom {
 				if strings.EqualFold(ref.Kind, kind) && ref.Name == obj.GetName() {
 					requests[client.ObjectKeyFromObject(&b)] = struct{}{}
 				}
 			}
-			if strings.EqualFold(kind, "Secret") && b.Spec.Artifact != nil && b.Spec.Artifact.SecretRef.Name == obj.GetName() {
+			if strings.EqualFold(kind, "Secret") && b.Spec.Artifact != nil && artifactReferences(b.Spec.Artifact, obj.GetName()) {
 				installedDigest := ""
 				if b.Status.Artifact != nil {
 					installedDigest = b.Status.Artifact.Digest
@@ -142,6 +231,14 @@ func ValueFromEventHandler[T client.Object](cli client.Client, kind string) hand
 	}))
 }
 
+// artifactReferences reports whether any chunk of artifact is stored in the Secret name.
+func artifactReferences(artifact *appsv1.Artifact, name string) bool {
+	if artifact.SecretRef.Name == name {
+		return true
+	}
+	return slices.ContainsFunc(artifact.Chunks, func(ref appsv1.ArtifactSecretRef) bool { return ref.Name == name })
+}
+
 type InstanceReconciler struct {
 	Client  client.Client
 	Scheme  *runtime.Scheme
@@ -149,8 +246,20 @@ type InstanceReconciler struct {
 
 	DynamicSources *DynamicSources
 
+	// CacheDir holds the cached indexes and certificates of Repositories.
+	CacheDir string
+
 	// AllowClusterScopedNamespaces is a static set of namespaces allowed to create cluster-scoped resources.
 	AllowClusterScopedNamespaces map[string]struct{}
+
+	// RemovalTimeout is the time the objects of a deleted Instance have to
+	// terminate before its removal is reported failed, DefaultRemovalTimeout
+	// when zero. The finalizer is kept until they are gone.
+	RemovalTimeout time.Duration
+
+	// Recorder records the events of the Instance lifecycle, no events are
+	// recorded when nil.
+	Recorder record.EventRecorder
 }
 
 func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -172,23 +281,7 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	// check the object is being deleted then remove the finalizer
 	if instance.DeletionTimestamp != nil {
-		// remove
-		if err := r.Remove(ctx, instance); err != nil {
-			instance.Status.Phase = appsv1.PhaseFailed
-			instance.Status.Message = err.Error()
-			r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, "UninstallFailed", err.Error())
-			_ = r.Client.Status().Update(ctx, instance)
-			return ctrl.Result{}, err
-		}
-
-		// Remove finalizer after successful removal
-		if controllerutil.RemoveFinalizer(instance, FinalizerName) {
-			log.Info("remove finalizer")
-			if err := r.Client.Update(ctx, instance); err != nil {
-				return ctrl.Result{}, err
-			}
-		}
-		return ctrl.Result{}, nil
+		return r.reconcileRemoval(ctx, instance)
 	}
 	if instance.DeletionTimestamp == nil && controllerutil.AddFinalizer(instance, FinalizerName) {
 		log.Info("add finalizer")
@@ -208,7 +301,15 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	// sync
 	err := r.Sync(ctx, instance)
-	if err != nil {
+	var result ctrl.Result
+	var waitErr *install.WaitingError
+	if errors.As(err, &waitErr) {
+		// the objects are checked again rather than waited for
+		log.Info("waiting", "stage", waitErr.Wait.Stage, "reason", waitErr.Message)
+		instance.Status.Phase = appsv1.PhaseReconciling
+		instance.Status.Message = err.Error()
+		result, err = ctrl.Result{RequeueAfter: waitPollInterval}, nil
+	} else if err != nil {
 		instance.Status.Phase = appsv1.PhaseFailed
 		instance.Status.Message = err.Error()
 	}
@@ -222,7 +323,7 @@ func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			return ctrl.Result{}, err
 		}
 	}
-	return ctrl.Result{}, err
+	return result, err
 }
 
 func (r *InstanceReconciler) Sync(ctx context.Context, instance *appsv1.Instance) error {
@@ -244,6 +345,7 @@ func (r *InstanceReconciler) Sync(ctx context.Context, instance *appsv1.Instance
 func (r *InstanceReconciler) syncDeps(ctx context.Context, instance *appsv1.Instance) error {
 	if err := r.checkDepenency(ctx, instance); err != nil {
 		r.setCondition(instance, appsv1.ConditionDependenciesReady, metav1.ConditionFalse, "DependencyNotReady", err.Error())
+		r.eventf(instance, corev1.EventTypeWarning, "DependencyNotReady", "Waiting for %v", err)
 		return err
 	}
 	r.setCondition(instance, appsv1.ConditionDependenciesReady, metav1.ConditionTrue, "AllDependenciesReady", "All dependencies are installed")
@@ -268,9 +370,20 @@ func (r *InstanceReconciler) syncInstall(ctx context.Context, instance *appsv1.I
 		return err
 	}
 	instanceSpec := installerInstanceFrom(instance, values, auth)
+	if instance.Spec.RepositoryRef != nil {
+		if reason, err := r.resolveInstanceRepository(ctx, instance, &instanceSpec); err != nil {
+			r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, reason, err.Error())
+			return err
+		}
+	}
 
 	// Build PostRenderer pipeline
 	instanceSpec.PostRenderer = r.buildPostRenderer(ctx, instance, values)
+	instanceSpec.Recorder = r.recorderFor(instance)
+	if instance.Status.ObservedGeneration != instance.Generation {
+		// a changed spec starts a new apply
+		instanceSpec.Waiting = nil
+	}
 
 	if executionUpToDate(instance, values) {
 		log.Info("already uptodate")
@@ -278,19 +391,55 @@ func (r *InstanceReconciler) syncInstall(ctx context.Context, instance *appsv1.I
 		return nil
 	}
 
-	log.Info("applying instance")
+	if instanceSpec.Waiting == nil {
+		log.Info("applying instance")
+		r.eventf(instance, corev1.EventTypeNormal, "Applying", "Applying generation %d", instance.Generation)
+	}
 	result, err := r.Applier.Apply(ctx, instanceSpec)
+	var waitErr *install.WaitingError
+	if errors.As(err, &waitErr) {
+		instance.Status.Waiting = &waitErr.Wait
+		var hookErr *install.HookError
+		if errors.As(err, &hookErr) {
+			instance.Status.Hooks = hookErr.Results
+		}
+		r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, "Waiting", err.Error())
+		r.eventf(instance, corev1.EventTypeNormal, "Waiting", "Apply of generation %d is waiting at %s", instance.Generation, waitErr.Wait.Stage)
+		return err
+	}
+	instance.Status.Waiting = nil
 	if err != nil {
 		log.Error(err, "apply instance")
 		reason := string(apierrors.ReasonForError(err))
 		if reason == string(metav1.StatusReasonUnknown) {
 			reason = "ApplyFailed"
 		}
+		var schemaErr *install.SchemaError
+		if errors.As(err, &schemaErr) {
+			reason = "SchemaViolation"
+			r.setCondition(instance, appsv1.ConditionValuesValid, metav1.ConditionFalse, reason, schemaErr.Error())
+		}
+		var conflictErr *install.ApplyConflictError
+		if errors.As(err, &conflictErr) {
+			reason = "ApplyConflict"
+			r.setCondition(instance, appsv1.ConditionApplyConflict, metav1.ConditionTrue, "FieldConflict", conflictMessage(conflictErr.Conflicts))
+		}
+		var rollbackErr *install.RollbackError
+		if errors.As(err, &rollbackErr) {
+			reason = "RolledBack"
+		}
+		var hookErr *install.HookError
+		if errors.As(err, &hookErr) {
+			reason = "HookFailed"
+			instance.Status.Hooks = hookErr.Results
+		}
 		r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, reason, err.Error())
+		r.eventf(instance, corev1.EventTypeWarning, reason, "Apply generation %d: %v", instance.Generation, err)
 		return err
 	}
 
 	log.Info("applied instance successfully")
+	r.eventf(instance, corev1.EventTypeNormal, "Applied", "Applied generation %d with %d objects", instance.Generation, len(result.Resources))
 	instance.Status.Note = result.Note
 	instance.Status.CreationTimestamp = convtime(result.CreationTimestamp)
 	instance.Status.UpgradeTimestamp = convtime(result.UpgradeTimestamp)
@@ -304,11 +453,36 @@ func (r *InstanceReconciler) syncInstall(ctx context.Context, instance *appsv1.I
 	}
 	instance.Status.Resources = result.Resources
 	instance.Status.Extensions = instance.Spec.Extensions
+	instance.Status.History = appendHistory(instance.Status.History, result)
+	instance.Status.Release = result.Release
+	if instance.Spec.Kind == appsv1.InstanceKindCue {
+		r.setCondition(instance, appsv1.ConditionValuesValid, metav1.ConditionTrue, "ValuesValid", "Values satisfy the schema")
+	}
+	if len(result.Hooks) > 0 {
+		instance.Status.Hooks = result.Hooks
+	}
+	if meta.FindStatusCondition(instance.Status.Conditions, appsv1.ConditionApplyConflict) != nil {
+		r.setCondition(instance, appsv1.ConditionApplyConflict, metav1.ConditionFalse, "NoConflict", "Objects applied without conflicts")
+	}
+	if len(result.Adopted) > 0 {
+		log.Info("adopted existing objects", "count", len(result.Adopted))
+	}
 
 	r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionTrue, "Installed", "Instance is installed and ready")
 	return nil
 }
 
+// conflictMessage lists the conflicting fields, one object and field manager
+// per line.
+func conflictMessage(conflicts []install.FieldConflict) string {
+	lines := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		lines = append(lines, fmt.Sprintf("%s %s: owned by %q",
+			diffObjectName(conflict.Object), conflict.Field, conflict.Manager))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // setCondition sets a condition on the instance status
 func (r *InstanceReconciler) setCondition(instance *appsv1.Instance, conditionType string, status metav1.ConditionStatus, reason, message string) {
 	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
@@ -335,8 +509,79 @@ func installerInstanceFrom(instance *appsv1.Instance, values map[string]any, aut
 		CreationTimestamp: instance.Status.CreationTimestamp.Time,
 		UpgradeTimestamp:  instance.Status.UpgradeTimestamp.Time,
 		Options:           instance.Spec.Options,
+		DeletionPolicy:    instance.Spec.DeletionPolicy,
 		Auth:              auth,
+		Adopt:             instanceAdopt(instance),
+		Release:           instance.Status.Release,
+		Waiting:           instance.Status.Waiting,
+		Hooks:             instance.Status.Hooks,
+		Kustomize:         instance.Spec.Kustomize,
+		Manifests:         instance.Spec.Manifests,
+		Jsonnet:           instance.Spec.Jsonnet,
+		Cue:               instance.Spec.Cue,
+	}
+}
+
+// instanceAdopt returns the adoption settings of instance, spec.adopt taking
+// precedence over the adopt annotation. The release adopted earlier, kept in
+// the status, is the default release.
+func instanceAdopt(instance *appsv1.Instance) *appsv1.Adopt {
+	var adopt *appsv1.Adopt
+	value := instance.Annotations[AnnotationAdopt]
+	if instance.Spec.Adopt != nil {
+		adopt = instance.Spec.Adopt.DeepCopy()
+	} else if enabled, err := strconv.ParseBool(value); err == nil || value == "" {
+		if !enabled {
+			return nil
+		}
+		adopt = &appsv1.Adopt{}
+	} else {
+		adopt = &appsv1.Adopt{ReleaseName: value}
+		if namespace, name, ok := strings.Cut(value, "/"); ok {
+			adopt.ReleaseNamespace, adopt.ReleaseName = namespace, name
+		}
+	}
+	if release := instance.Status.Release; release != nil && adopt.ReleaseName == "" && adopt.ReleaseNamespace == "" {
+		adopt.ReleaseName, adopt.ReleaseNamespace = release.Name, release.Namespace
+	}
+	return adopt
+}
+
+// appendHistory records the revision applied by result in history, keeping
+// the latest appsv1.MaxInstanceHistory revisions. Installers keeping their own
+// history replace it.
+func appendHistory(history []appsv1.InstanceRevision, result *install.InstanceStatus) []appsv1.InstanceRevision {
+	if len(result.History) > 0 {
+		history = slices.Clone(result.History)
+	} else {
+		updated := convtime(result.UpgradeTimestamp)
+		last := len(history) - 1
+		if last >= 0 && history[last].UpdatedTime.Equal(&updated) && result.Description == "" {
+			// nothing was applied
+			return history
+		}
+		revision := appsv1.InstanceRevision{
+			Revision:    1,
+			Version:     result.Version,
+			AppVersion:  result.AppVersion,
+			Description: result.Description,
+			UpdatedTime: updated,
+		}
+		if last >= 0 {
+			revision.Revision = history[last].Revision + 1
+		}
+		if revision.Description == "" {
+			revision.Description = "Install complete"
+			if revision.Revision > 1 {
+				revision.Description = "Upgrade complete"
+			}
+		}
+		history = append(history, revision)
+	}
+	if len(history) > appsv1.MaxInstanceHistory {
+		history = history[len(history)-appsv1.MaxInstanceHistory:]
 	}
+	return history
 }
 
 func executionUpToDate(instance *appsv1.Instance, values map[string]any) bool {
@@ -366,16 +611,38 @@ func executionUpToDate(instance *appsv1.Instance, values map[string]any) bool {
 }
 
 func validateInstanceSource(instance *appsv1.Instance) error {
+	if adopt := instanceAdopt(instance); adopt != nil && (adopt.ReleaseName != "" || adopt.ReleaseNamespace != "") &&
+		instance.Spec.Kind != "" && instance.Spec.Kind != appsv1.InstanceKindHelm {
+		return fmt.Errorf("adopting a release is only supported by helm instances")
+	}
+	if instance.Spec.Kustomize != nil && instance.Spec.Kind != appsv1.InstanceKindKustomize {
+		return fmt.Errorf("kustomize is only supported by kustomize instances")
+	}
+	if instance.Spec.Manifests != nil && instance.Spec.Kind != appsv1.InstanceKindManifests {
+		return fmt.Errorf("manifests is only supported by manifests instances")
+	}
+	if instance.Spec.Jsonnet != nil && instance.Spec.Kind != appsv1.InstanceKindJsonnet {
+		return fmt.Errorf("jsonnet is only supported by jsonnet instances")
+	}
+	if instance.Spec.Cue != nil && instance.Spec.Kind != appsv1.InstanceKindCue {
+		return fmt.Errorf("cue is only supported by cue instances")
+	}
+	if instance.Spec.RepositoryRef != nil {
+		if instance.Spec.Artifact != nil || instance.Spec.URL != "" || instance.Spec.Auth != nil {
+			return fmt.Errorf("repositoryRef cannot be combined with artifact, url, or auth")
+		}
+		if instance.Spec.RepositoryRef.Name == "" {
+			return fmt.Errorf("repositoryRef name must be specified")
+		}
+		return nil
+	}
 	artifact := instance.Spec.Artifact
 	if artifact == nil {
 		if instance.Spec.URL == "" {
-			return fmt.Errorf("either artifact or url must be specified")
+			return fmt.Errorf("either artifact, url or repositoryRef must be specified")
 		}
 		return nil
 	}
-	if instance.Spec.Kind != "" && instance.Spec.Kind != appsv1.InstanceKindHelm {
-		return fmt.Errorf("artifact is only supported for helm instances")
-	}
 	if instance.Spec.URL != "" || instance.Spec.Version != "" || instance.Spec.Chart != "" || instance.Spec.Path != "" || instance.Spec.Auth != nil {
 		return fmt.Errorf("artifact cannot be combined with url, version, chart, path, or auth")
 	}
@@ -413,6 +680,15 @@ func (r *InstanceReconciler) buildPostRenderer(ctx context.Context, instance *ap
 		},
 	})
 
+	// Fields owned by other controllers — left to them
+	modifiers = append(modifiers, &postrender.IgnoreDifferencesRenderer{
+		Rules:       instance.Spec.IgnoreDifferences,
+		Autoscalers: r.liveAutoscalers(ctx, instance.Namespace),
+		Live: func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+			return r.getLive(ctx, obj)
+		},
+	})
+
 	// Paused — scale down workloads when global.paused=true
 	paused := getGlobalPaused(values)
 	if paused {
@@ -425,17 +701,19 @@ func (r *InstanceReconciler) buildPostRenderer(ctx context.Context, instance *ap
 		postrender.DashboardPostRenderer{Name: instance.Name, Namespace: instance.Namespace},
 		postrender.CompositeRenderer{Modifiers: modifiers},
 	}
-	return install.WithPostRendererIdentity(chain, postRendererIdentity(instance.Spec.Extensions, allowClusterScoped))
+	return install.WithPostRendererIdentity(chain, postRendererIdentity(instance.Spec.Extensions, instance.Spec.IgnoreDifferences, allowClusterScoped))
 }
 
-func postRendererIdentity(extensions []appsv1.Extension, allowClusterScoped bool) string {
+func postRendererIdentity(extensions []appsv1.Extension, ignoreDifferences []appsv1.IgnoreDifference, allowClusterScoped bool) string {
 	state := struct {
-		Version            int                `json:"version"`
-		Extensions         []appsv1.Extension `json:"extensions,omitempty"`
-		AllowClusterScoped bool               `json:"allowClusterScoped"`
+		Version            int                       `json:"version"`
+		Extensions         []appsv1.Extension        `json:"extensions,omitempty"`
+		IgnoreDifferences  []appsv1.IgnoreDifference `json:"ignoreDifferences,omitempty"`
+		AllowClusterScoped bool                      `json:"allowClusterScoped"`
 	}{
 		Version:            3,
 		Extensions:         extensions,
+		IgnoreDifferences:  ignoreDifferences,
 		AllowClusterScoped: allowClusterScoped,
 	}
 	data, _ := json.Marshal(state)
@@ -443,6 +721,22 @@ func postRendererIdentity(extensions []appsv1.Extension, allowClusterScoped bool
 	return hex.EncodeToString(digest[:])
 }
 
+// liveAutoscalers lists the HorizontalPodAutoscalers of namespace, the
+// replicas of the workloads they target are left to them.
+func (r *InstanceReconciler) liveAutoscalers(ctx context.Context, namespace string) []*unstructured.Unstructured {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{Group: "autoscaling", Version: "v2", Kind: "HorizontalPodAutoscalerList"})
+	if err := r.Client.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		logr.FromContextOrDiscard(ctx).V(1).Info("listing autoscalers", "error", err.Error())
+		return nil
+	}
+	autoscalers := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		autoscalers = append(autoscalers, &list.Items[i])
+	}
+	return autoscalers
+}
+
 // getGlobalPaused reads global.paused from values.
 func getGlobalPaused(values map[string]any) bool {
 	global, ok := values["global"].(map[string]any)
@@ -530,8 +824,9 @@ func (r *InstanceReconciler) checkDepenency(ctx context.Context, instance *appsv
 			dep.Namespace = instance.Namespace
 		}
 		if dep.Kind == "" {
-			dep.APIVersion = instance.APIVersion
-			dep.Kind = instance.Kind
+			// TypeMeta of typed objects read by the client is empty
+			dep.APIVersion = appsv1.SchemeGroupVersion.String()
+			dep.Kind = "Instance"
 		}
 		gvk := schema.FromAPIVersionAndKind(dep.APIVersion, dep.Kind)
 		newobj, _ := r.Scheme.New(gvk)
@@ -621,10 +916,15 @@ func (r *InstanceReconciler) resolveValues(ctx context.Context, instance *appsv1
 // resolveAuth resolves repository credentials from the Instance spec.
 // It reads inline credentials and/or a referenced Secret, with inline fields taking precedence.
 func (r *InstanceReconciler) resolveAuth(ctx context.Context, instance *appsv1.Instance) (*install.ResolvedAuth, error) {
-	if instance.Spec.Auth == nil {
+	return resolveRepositoryAuth(ctx, r.Client, instance.Namespace, instance.Spec.Auth, instance.Spec.URL)
+}
+
+// resolveRepositoryAuth resolves the credentials of the repository at repoURL
+// from auth, reading a referenced Secret in namespace.
+func resolveRepositoryAuth(ctx context.Context, cli client.Client, namespace string, auth *appsv1.RepositoryAuth, repoURL string) (*install.ResolvedAuth, error) {
+	if auth == nil {
 		return nil, nil
 	}
-	auth := instance.Spec.Auth
 	resolved := &install.ResolvedAuth{
 		Username: auth.Username,
 		Password: auth.Password,
@@ -632,8 +932,8 @@ func (r *InstanceReconciler) resolveAuth(ctx context.Context, instance *appsv1.I
 
 	if auth.SecretRef != nil {
 		secret := &corev1.Secret{}
-		key := client.ObjectKey{Namespace: instance.Namespace, Name: auth.SecretRef.Name}
-		if err := r.Client.Get(ctx, key, secret); err != nil {
+		key := client.ObjectKey{Namespace: namespace, Name: auth.SecretRef.Name}
+		if err := cli.Get(ctx, key, secret); err != nil {
 			return nil, fmt.Errorf("get auth secret %q: %w", auth.SecretRef.Name, err)
 		}
 		switch secret.Type {
@@ -645,7 +945,7 @@ func (r *InstanceReconciler) resolveAuth(ctx context.Context, instance *appsv1.I
 				resolved.Password = string(secret.Data["password"])
 			}
 		case corev1.SecretTypeDockerConfigJson:
-			u, p, err := extractDockerAuth(secret.Data[corev1.DockerConfigJsonKey], instance.Spec.URL)
+			u, p, err := extractDockerAuth(secret.Data[corev1.DockerConfigJsonKey], repoURL)
 			if err != nil {
 				return nil, fmt.Errorf("parse dockerconfigjson from secret %q: %w", auth.SecretRef.Name, err)
 			}
@@ -767,6 +1067,10 @@ func cleanNilValues(m map[string]any) map[string]any {
 }
 
 func (r *InstanceReconciler) syncWatches(ctx context.Context, instance *appsv1.Instance) error {
+	// resources are only watched when running as a controller
+	if r.DynamicSources == nil {
+		return nil
+	}
 	for _, res := range instance.Status.Resources {
 		if err := r.DynamicSources.Watch(ctx, res.GroupVersionKind()); err != nil {
 			return err
@@ -775,18 +1079,179 @@ func (r *InstanceReconciler) syncWatches(ctx context.Context, instance *appsv1.I
 	return nil
 }
 
-func (r *InstanceReconciler) Remove(ctx context.Context, instance *appsv1.Instance) error {
+// reconcileRemoval removes the objects of a deleted instance, waits for them
+// to terminate and only then removes the finalizer.
+func (r *InstanceReconciler) reconcileRemoval(ctx context.Context, instance *appsv1.Instance) (ctrl.Result, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	// objects still terminating were removed by an earlier reconcile
+	var removed []appsv1.ManagedResource
+	if len(instance.Status.Removing) == 0 {
+		var err error
+		removed, err = r.Remove(ctx, instance)
+		var waitErr *install.WaitingError
+		if errors.As(err, &waitErr) {
+			instance.Status.Waiting = &waitErr.Wait
+			var hookErr *install.HookError
+			if errors.As(err, &hookErr) {
+				instance.Status.Hooks = hookErr.Results
+			}
+			instance.Status.Message = err.Error()
+			r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, "Removing", err.Error())
+			if err := r.Client.Status().Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			log.Info("waiting for removal", "stage", waitErr.Wait.Stage)
+			return ctrl.Result{RequeueAfter: waitPollInterval}, nil
+		}
+		if err != nil {
+			instance.Status.Phase = appsv1.PhaseFailed
+			instance.Status.Message = err.Error()
+			r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, "UninstallFailed", err.Error())
+			r.eventf(instance, corev1.EventTypeWarning, "UninstallFailed", "Uninstall: %v", err)
+			_ = r.Client.Status().Update(ctx, instance)
+			return ctrl.Result{}, err
+		}
+		instance.Status.Waiting = nil
+	}
+
+	removing, err := r.removingResources(ctx, instance, removed)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(removing) > 0 {
+		original := instance.DeepCopy()
+		instance.Status.Removing = removing
+		message := removingMessage(removing)
+		timeout := r.RemovalTimeout
+		if timeout <= 0 {
+			timeout = DefaultRemovalTimeout
+		}
+		if time.Since(instance.DeletionTimestamp.Time) > timeout {
+			message = fmt.Sprintf("not removed after %s: %s", timeout, message)
+			instance.Status.Phase = appsv1.PhaseFailed
+			r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, "RemovalTimeout", message)
+			r.eventf(instance, corev1.EventTypeWarning, "RemovalTimeout", "Removal failed, objects %s", message)
+		} else {
+			instance.Status.Phase = appsv1.PhaseTerminating
+			r.setCondition(instance, appsv1.ConditionInstalled, metav1.ConditionFalse, "Removing", message)
+			r.eventf(instance, corev1.EventTypeNormal, "Removing", "Removal is %s", message)
+		}
+		instance.Status.Message = message
+		if !equality.Semantic.DeepEqual(&original.Status, &instance.Status) {
+			if err := r.Client.Status().Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		log.Info("waiting for resources to terminate", "count", len(removing))
+		return ctrl.Result{RequeueAfter: removalPollInterval}, nil
+	}
+
+	// Remove finalizer after successful removal
+	if controllerutil.RemoveFinalizer(instance, FinalizerName) {
+		log.Info("remove finalizer")
+		if err := r.Client.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.eventf(instance, corev1.EventTypeNormal, "Uninstalled", "Instance is uninstalled")
+	}
+	return ctrl.Result{}, nil
+}
+
+// removingResources returns the objects of instance still terminating: those
+// of its status and those its removal deleted, which includes the objects of
+// the inventory and the discovered ones. The objects left by the deletion
+// policy or a Retain remove strategy are not being deleted and do not hold
+// the removal.
+func (r *InstanceReconciler) removingResources(ctx context.Context, instance *appsv1.Instance, removed []appsv1.ManagedResource) ([]appsv1.RemovingResource, error) {
+	refs := append(slices.Clone(instance.Status.Resources), removed...)
+	for _, res := range instance.Status.Removing {
+		refs = append(refs, res.ManagedResource)
+	}
+	native.CorrectNamespacesForRefrences(r.Client, instance.Namespace, refs)
+	var removing []appsv1.RemovingResource
+	seen := map[appsv1.ManagedResource]bool{}
+	for _, ref := range refs {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(ref.GroupVersionKind())
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, live); err != nil {
+			if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		if live.GetDeletionTimestamp() == nil {
+			continue
+		}
+		removing = append(removing, appsv1.RemovingResource{ManagedResource: ref, Finalizers: live.GetFinalizers()})
+	}
+	return removing, nil
+}
+
+// removingMessage describes the objects still terminating and the
+// finalizers they wait for.
+func removingMessage(removing []appsv1.RemovingResource) string {
+	var descriptions []string
+	for i, res := range removing {
+		if i == maxRemovingInMessage {
+			descriptions = append(descriptions, fmt.Sprintf("and %d more", len(removing)-i))
+			break
+		}
+		description := res.Kind + " " + path.Join(res.Namespace, res.Name)
+		if len(res.Finalizers) > 0 {
+			description += " (" + strings.Join(res.Finalizers, ", ") + ")"
+		}
+		descriptions = append(descriptions, description)
+	}
+	return fmt.Sprintf("waiting for %d objects to terminate: %s", len(removing), strings.Join(descriptions, ", "))
+}
+
+func (r *InstanceReconciler) Remove(ctx context.Context, instance *appsv1.Instance) ([]appsv1.ManagedResource, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
 	if instance.Status.Phase != appsv1.PhaseTerminating {
 		instance.Status.Phase = appsv1.PhaseTerminating
 		instance.Status.Message = ""
+		// the wait of an unfinished apply does not hold the removal
+		instance.Status.Waiting = nil
 		if err := r.Client.Status().Update(ctx, instance); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	log.Info("removing instance")
-	instanceSpec := installerInstanceFrom(instance, instance.Spec.Values.Object, nil)
+	// delete hooks are rendered with the values of the last apply
+	values := instance.Spec.Values.Object
+	if instance.Status.Values.Object != nil {
+		values = instance.Status.Values.Object
+	}
+	policy := instance.Spec.DeletionPolicy
+	if policy == "" {
+		policy = appsv1.DeletionPolicyDelete
+	}
+	instanceSpec := installerInstanceFrom(instance, values, nil)
+	// the source of a native kind is fetched for its delete hooks, with the
+	// credentials of an apply
+	if instance.Spec.Kind != "" && instance.Spec.Kind != appsv1.InstanceKindHelm && policy != appsv1.DeletionPolicyOrphan {
+		auth, err := r.resolveAuth(ctx, instance)
+		if err != nil {
+			return nil, fmt.Errorf("resolve auth: %w", err)
+		}
+		instanceSpec.Auth = auth
+		if instance.Spec.RepositoryRef != nil {
+			if _, err := r.resolveInstanceRepository(ctx, instance, &instanceSpec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	instanceSpec.PostRenderer = r.buildPostRenderer(ctx, instance, values)
+	instanceSpec.Recorder = r.recorderFor(instance)
+	if instanceSpec.Waiting == nil {
+		r.eventf(instance, corev1.EventTypeNormal, "Uninstalling", "Uninstalling with deletion policy %s", policy)
+	}
 	return r.Applier.Remove(ctx, instanceSpec)
 }
@@ -3,18 +3,25 @@ package controller
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 	"xiaoshiai.cn/installer/install"
 )
 
 type countingInstaller struct {
-	applyCount int
+	applyCount  int
+	removeCount int
 }
 
 func (c *countingInstaller) Apply(_ context.Context, instance install.Instance) (*install.InstanceStatus, error) {
@@ -26,8 +33,9 @@ func (c *countingInstaller) Apply(_ context.Context, instance install.Instance)
 	}, nil
 }
 
-func (c *countingInstaller) Remove(context.Context, install.Instance) error {
-	return nil
+func (c *countingInstaller) Remove(context.Context, install.Instance) ([]appsv1.ManagedResource, error) {
+	c.removeCount++
+	return nil, nil
 }
 
 func (c *countingInstaller) Template(context.Context, install.Instance) ([]byte, error) {
@@ -127,6 +135,213 @@ func TestSyncInstallAppliesOncePerDesiredState(t *testing.T) {
 	}
 }
 
+func TestReconcileWaitsForTerminatingResources(t *testing.T) {
+	ctx := context.Background()
+	deleted := metav1.NewTime(time.Now().Add(-30 * time.Minute))
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Finalizers: []string{FinalizerName}, DeletionTimestamp: &deleted},
+		Spec:       appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, URL: "oci://example.test/demo"},
+		Status: appsv1.InstanceStatus{
+			Phase: appsv1.PhaseInstalled,
+			Resources: []appsv1.ManagedResource{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "terminating"},
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "retained"},
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "gone"},
+			},
+		},
+	}
+	terminating := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name: "terminating", Namespace: "default", Finalizers: []string{"example.test/protect"}, DeletionTimestamp: &deleted,
+	}}
+	retained := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "retained", Namespace: "default"}}
+	scheme := GetScheme()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(newOfflineRESTMapper(scheme, nil)).
+		WithStatusSubresource(&appsv1.Instance{}).
+		WithObjects(instance, terminating, retained).
+		Build()
+	applier := &countingInstaller{}
+	reconciler := &InstanceReconciler{Client: cli, Applier: applier, RemovalTimeout: time.Hour}
+	request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	result, err := reconciler.Reconcile(ctx, request)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter == 0 {
+		t.Fatal("Reconcile() did not requeue while an object is terminating")
+	}
+	stored := &appsv1.Instance{}
+	if err := cli.Get(ctx, request.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	want := []appsv1.RemovingResource{{
+		ManagedResource: appsv1.ManagedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "terminating"},
+		Finalizers:      []string{"example.test/protect"},
+	}}
+	if !reflect.DeepEqual(stored.Status.Removing, want) {
+		t.Fatalf("status.removing = %#v, want %#v", stored.Status.Removing, want)
+	}
+	if stored.Status.Phase != appsv1.PhaseTerminating || !strings.Contains(stored.Status.Message, "ConfigMap default/terminating (example.test/protect)") {
+		t.Fatalf("status = %s %q", stored.Status.Phase, stored.Status.Message)
+	}
+	if len(stored.Finalizers) != 1 {
+		t.Fatal("finalizer released while an object is terminating")
+	}
+
+	// the wait outlived the timeout, the removal is failed but still held
+	reconciler.RemovalTimeout = time.Minute
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := cli.Get(ctx, request.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(stored.Status.Conditions, appsv1.ConditionInstalled); stored.Status.Phase != appsv1.PhaseFailed || cond == nil || cond.Reason != "RemovalTimeout" {
+		t.Fatalf("status after timeout = %s %#v", stored.Status.Phase, cond)
+	}
+
+	terminating.Finalizers = nil
+	if err := cli.Update(ctx, terminating); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := cli.Get(ctx, request.NamespacedName, stored); !apierrors.IsNotFound(err) {
+		t.Fatalf("instance after its objects are gone: %v", err)
+	}
+	if applier.removeCount != 1 {
+		t.Fatalf("Remove() calls = %d, want 1", applier.removeCount)
+	}
+}
+
+// removedInstaller removes the objects it was given, besides those of the
+// status.
+type removedInstaller struct {
+	countingInstaller
+	removed []appsv1.ManagedResource
+}
+
+func (c *removedInstaller) Remove(ctx context.Context, instance install.Instance) ([]appsv1.ManagedResource, error) {
+	c.removeCount++
+	return c.removed, nil
+}
+
+func TestReconcileWaitsForRemovedInventoryObjects(t *testing.T) {
+	ctx := context.Background()
+	deleted := metav1.NewTime(time.Now())
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Finalizers: []string{FinalizerName}, DeletionTimestamp: &deleted},
+		Spec:       appsv1.InstanceSpec{Kind: appsv1.InstanceKindManifests, URL: "https://example.test/demo.yaml"},
+		Status:     appsv1.InstanceStatus{Phase: appsv1.PhaseInstalled},
+	}
+	// the object is only known to the inventory, the status lost it
+	inventoried := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name: "inventoried", Namespace: "default", Finalizers: []string{"example.test/protect"}, DeletionTimestamp: &deleted,
+	}}
+	scheme := GetScheme()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(newOfflineRESTMapper(scheme, nil)).
+		WithStatusSubresource(&appsv1.Instance{}).
+		WithObjects(instance, inventoried).
+		Build()
+	applier := &removedInstaller{removed: []appsv1.ManagedResource{{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "inventoried"}}}
+	reconciler := &InstanceReconciler{Client: cli, Applier: applier, RemovalTimeout: time.Hour}
+	request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	stored := &appsv1.Instance{}
+	for range 2 {
+		if _, err := reconciler.Reconcile(ctx, request); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		if err := cli.Get(ctx, request.NamespacedName, stored); err != nil {
+			t.Fatalf("instance released while a removed object is terminating: %v", err)
+		}
+		if len(stored.Status.Removing) != 1 || stored.Status.Removing[0].Name != "inventoried" {
+			t.Fatalf("status.removing = %#v, want the inventoried object", stored.Status.Removing)
+		}
+	}
+
+	inventoried.Finalizers = nil
+	if err := cli.Update(ctx, inventoried); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := cli.Get(ctx, request.NamespacedName, stored); !apierrors.IsNotFound(err) {
+		t.Fatalf("instance after its objects are gone: %v", err)
+	}
+	if applier.removeCount != 1 {
+		t.Fatalf("Remove() calls = %d, want 1", applier.removeCount)
+	}
+}
+
+// waitingInstaller waits at a wave for the first waits applies.
+type waitingInstaller struct {
+	recordingInstaller
+	waits int
+}
+
+func (c *waitingInstaller) Apply(ctx context.Context, instance install.Instance) (*install.InstanceStatus, error) {
+	if c.waits > 0 {
+		c.waits--
+		c.last = instance
+		return nil, &install.WaitingError{
+			Wait:    appsv1.InstanceWait{Stage: "wave 0", Since: metav1.Now()},
+			Message: "waiting for Deployment default/database",
+		}
+	}
+	return c.recordingInstaller.Apply(ctx, instance)
+}
+
+func TestReconcileRequeuesWaitingApply(t *testing.T) {
+	ctx := context.Background()
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 1, Finalizers: []string{FinalizerName}},
+		Spec:       appsv1.InstanceSpec{Kind: appsv1.InstanceKindKustomize, URL: "https://example.test/demo.git"},
+	}
+	scheme := GetScheme()
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(newOfflineRESTMapper(scheme, nil)).
+		WithStatusSubresource(&appsv1.Instance{}).
+		WithObjects(instance, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}).
+		Build()
+	applier := &waitingInstaller{waits: 1}
+	reconciler := &InstanceReconciler{Client: cli, Applier: applier, AllowClusterScopedNamespaces: map[string]struct{}{}}
+	request := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	result, err := reconciler.Reconcile(ctx, request)
+	if err != nil || result.RequeueAfter == 0 {
+		t.Fatalf("Reconcile() = %#v, %v, want a requeue without error", result, err)
+	}
+	stored := &appsv1.Instance{}
+	if err := cli.Get(ctx, request.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(stored.Status.Conditions, appsv1.ConditionInstalled)
+	if stored.Status.Waiting == nil || stored.Status.Waiting.Stage != "wave 0" || stored.Status.Phase != appsv1.PhaseReconciling || cond == nil || cond.Reason != "Waiting" {
+		t.Fatalf("status = %s %#v %#v, want waiting at wave 0", stored.Status.Phase, stored.Status.Waiting, cond)
+	}
+
+	if _, err := reconciler.Reconcile(ctx, request); err != nil {
+		t.Fatalf("resumed Reconcile() error = %v", err)
+	}
+	if applier.last.Waiting == nil || applier.last.Waiting.Stage != "wave 0" {
+		t.Fatalf("resumed apply got waiting %#v, want wave 0", applier.last.Waiting)
+	}
+	if err := cli.Get(ctx, request.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Waiting != nil || !meta.IsStatusConditionTrue(stored.Status.Conditions, appsv1.ConditionInstalled) {
+		t.Fatalf("status after the wave is ready = %#v %#v", stored.Status.Waiting, stored.Status.Conditions)
+	}
+}
+
 func TestExecutionUpToDate(t *testing.T) {
 	base := func() *appsv1.Instance {
 		return &appsv1.Instance{
@@ -245,10 +460,21 @@ func TestValidateInstanceSource(t *testing.T) {
 		{name: "artifact", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, Artifact: validArtifact()}},
 		{name: "default helm artifact", spec: appsv1.InstanceSpec{Artifact: validArtifact()}},
 		{name: "missing source", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm}, wantErr: true},
-		{name: "artifact for kustomize", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindKustomize, Artifact: validArtifact()}, wantErr: true},
+		{name: "artifact for kustomize", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindKustomize, Artifact: validArtifact()}},
+		{name: "artifact for template", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindTemplate, Artifact: validArtifact()}},
+		{name: "artifact for manifests", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindManifests, Artifact: validArtifact()}},
+		{name: "manifests", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindManifests, URL: "https://example.test/bundle.yaml", Manifests: &appsv1.ManifestsSource{Templating: appsv1.ManifestsTemplatingEnvsubst}}},
+		{name: "jsonnet", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindJsonnet, URL: "https://example.test/stack.git", Jsonnet: &appsv1.JsonnetSource{Entrypoint: "example.jsonnet"}}},
+		{name: "jsonnet for kustomize", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindKustomize, URL: "https://example.test/stack.git", Jsonnet: &appsv1.JsonnetSource{}}, wantErr: true},
+		{name: "cue", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindCue, URL: "https://example.test/config.git", Cue: &appsv1.CueSource{Package: "./apps"}}},
+		{name: "cue for jsonnet", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindJsonnet, URL: "https://example.test/config.git", Cue: &appsv1.CueSource{}}, wantErr: true},
+		{name: "manifests for helm", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, URL: "oci://example.test/chart", Manifests: &appsv1.ManifestsSource{}}, wantErr: true},
 		{name: "artifact with URL", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, Artifact: validArtifact(), URL: "oci://example.test/chart"}, wantErr: true},
 		{name: "artifact with version", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, Artifact: validArtifact(), Version: "1.0.0"}, wantErr: true},
 		{name: "artifact with auth", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, Artifact: validArtifact(), Auth: &appsv1.RepositoryAuth{}}, wantErr: true},
+		{name: "repositoryRef", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, RepositoryRef: &corev1.LocalObjectReference{Name: "charts"}, Chart: "demo"}},
+		{name: "repositoryRef with URL", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, RepositoryRef: &corev1.LocalObjectReference{Name: "charts"}, URL: "https://example.test"}, wantErr: true},
+		{name: "repositoryRef with artifact", spec: appsv1.InstanceSpec{Kind: appsv1.InstanceKindHelm, RepositoryRef: &corev1.LocalObjectReference{Name: "charts"}, Artifact: validArtifact()}, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -402,3 +628,137 @@ func TestMergeInto(t *testing.T) {
 		t.Errorf("mergeInto() = %v, want %v", base, expected)
 	}
 }
+
+func TestInstanceAdopt(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       *appsv1.Adopt
+		annotation string
+		release    *appsv1.InstanceRelease
+		want       *appsv1.Adopt
+	}{
+		{name: "disabled"},
+		{name: "false", annotation: "false"},
+		{name: "true", annotation: "true", want: &appsv1.Adopt{}},
+		{name: "release name", annotation: "legacy", want: &appsv1.Adopt{ReleaseName: "legacy"}},
+		{name: "release namespace", annotation: "kube-system/legacy", want: &appsv1.Adopt{ReleaseName: "legacy", ReleaseNamespace: "kube-system"}},
+		{name: "spec wins", spec: &appsv1.Adopt{ReleaseName: "spec"}, annotation: "legacy", want: &appsv1.Adopt{ReleaseName: "spec"}},
+		{name: "adopted release", annotation: "true", release: &appsv1.InstanceRelease{Name: "legacy", Namespace: "kube-system"}, want: &appsv1.Adopt{ReleaseName: "legacy", ReleaseNamespace: "kube-system"}},
+		{name: "adopted release removed", release: &appsv1.InstanceRelease{Name: "legacy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &appsv1.Instance{Spec: appsv1.InstanceSpec{Adopt: tt.spec}, Status: appsv1.InstanceStatus{Release: tt.release}}
+			if tt.annotation != "" {
+				instance.Annotations = map[string]string{AnnotationAdopt: tt.annotation}
+			}
+			if got := instanceAdopt(instance); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("instanceAdopt() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// releaseInstaller applies the release adopted by the instance, as the helm
+// installer does.
+type releaseInstaller struct {
+	recordingInstaller
+}
+
+func (c *releaseInstaller) Apply(ctx context.Context, instance install.Instance) (*install.InstanceStatus, error) {
+	status, err := c.recordingInstaller.Apply(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	status.Release = instance.Release
+	if instance.Adopt != nil && instance.Adopt.ReleaseName != "" {
+		status.Release = &appsv1.InstanceRelease{Name: instance.Adopt.ReleaseName, Namespace: instance.Adopt.ReleaseNamespace}
+	}
+	return status, nil
+}
+
+func TestSyncInstallKeepsAdoptedRelease(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("add core scheme: %v", err)
+	}
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+	}).Build()
+	applier := &releaseInstaller{}
+	reconciler := &InstanceReconciler{
+		Client:                       cli,
+		Applier:                      applier,
+		AllowClusterScopedNamespaces: map[string]struct{}{},
+	}
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 1},
+		Spec: appsv1.InstanceSpec{
+			Kind:  appsv1.InstanceKindHelm,
+			URL:   "oci://example.test/demo",
+			Adopt: &appsv1.Adopt{ReleaseName: "legacy", ReleaseNamespace: "kube-system"},
+		},
+	}
+	if err := reconciler.syncInstall(context.Background(), instance); err != nil {
+		t.Fatalf("syncInstall() error = %v", err)
+	}
+	want := &appsv1.InstanceRelease{Name: "legacy", Namespace: "kube-system"}
+	if !reflect.DeepEqual(instance.Status.Release, want) {
+		t.Fatalf("status release = %#v, want %#v", instance.Status.Release, want)
+	}
+
+	// removing adopt keeps upgrading the adopted release
+	instance.Spec.Adopt = nil
+	instance.Generation++
+	if err := reconciler.syncInstall(context.Background(), instance); err != nil {
+		t.Fatalf("syncInstall() error = %v", err)
+	}
+	if applier.applyCount != 2 || applier.last.Adopt != nil || !reflect.DeepEqual(applier.last.Release, want) {
+		t.Fatalf("second apply got adopt %#v release %#v, want release %#v", applier.last.Adopt, applier.last.Release, want)
+	}
+	if !reflect.DeepEqual(instance.Status.Release, want) {
+		t.Fatalf("status release after adopt removed = %#v, want %#v", instance.Status.Release, want)
+	}
+}
+
+func TestAppendHistory(t *testing.T) {
+	installed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history := appendHistory(nil, &install.InstanceStatus{Version: "1.0.0", UpgradeTimestamp: installed})
+	if len(history) != 1 || history[0].Revision != 1 || history[0].Description != "Install complete" {
+		t.Fatalf("history = %#v, want the install revision", history)
+	}
+	// a sync which applies nothing keeps the history
+	history = appendHistory(history, &install.InstanceStatus{Version: "1.0.0", UpgradeTimestamp: installed})
+	if len(history) != 1 {
+		t.Fatalf("history = %#v, want one revision", history)
+	}
+	for i := range appsv1.MaxInstanceHistory + 2 {
+		history = appendHistory(history, &install.InstanceStatus{Version: "1.0.1", UpgradeTimestamp: installed.Add(time.Duration(i+1) * time.Hour)})
+	}
+	if len(history) != appsv1.MaxInstanceHistory {
+		t.Fatalf("history has %d revisions, want %d", len(history), appsv1.MaxInstanceHistory)
+	}
+	if last := history[len(history)-1]; last.Revision != appsv1.MaxInstanceHistory+3 || last.Description != "Upgrade complete" {
+		t.Fatalf("last revision = %#v", last)
+	}
+
+	// installers keeping a history replace it
+	released := []appsv1.InstanceRevision{{Revision: 4, Description: "Upgrade complete"}}
+	if got := appendHistory(history, &install.InstanceStatus{History: released}); !reflect.DeepEqual(got, released) {
+		t.Fatalf("history = %#v, want the release history", got)
+	}
+}
+
+func TestValidateInstanceSourceAdopt(t *testing.T) {
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AnnotationAdopt: "legacy"}},
+		Spec:       appsv1.InstanceSpec{Kind: appsv1.InstanceKindKustomize, URL: "https://example.test/demo.git"},
+	}
+	if err := validateInstanceSource(instance); err == nil {
+		t.Fatal("validateInstanceSource() accepted adopting a release for a kustomize instance")
+	}
+	instance.Annotations[AnnotationAdopt] = "true"
+	if err := validateInstanceSource(instance); err != nil {
+		t.Fatalf("validateInstanceSource() error = %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package postrender
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+// IgnoreDifferencesRenderer sets the fields of the rendered objects owned by
+// other controllers to their live values, so applies and upgrades leave them
+// to their owners. The replicas of a workload targeted by a
+// HorizontalPodAutoscaler, rendered or one of Autoscalers, are always left to
+// the autoscaler.
+type IgnoreDifferencesRenderer struct {
+	Rules []appsv1.IgnoreDifference
+	// Autoscalers are the HorizontalPodAutoscalers of the cluster.
+	Autoscalers []*unstructured.Unstructured
+	// Live returns the live state of obj, nil if it does not exist. The
+	// ignored fields are removed when Live is nil or the object is new, an
+	// apply without them would reset them to their defaults otherwise. A new
+	// workload keeps its rendered replicas.
+	Live func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+}
+
+func (r *IgnoreDifferencesRenderer) ModifyObjects(objects []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	rules, err := compileIgnoreRules(r.Rules)
+	if err != nil {
+		return nil, err
+	}
+	targets := scaleTargets(append(append([]*unstructured.Unstructured{}, r.Autoscalers...), objects...))
+	for _, obj := range objects {
+		var paths [][]pathElement
+		for _, rule := range rules {
+			if rule.matches(obj) {
+				paths = append(paths, rule.paths...)
+			}
+		}
+		gvk := obj.GroupVersionKind()
+		scaled := targets[scaleTarget{group: gvk.Group, kind: gvk.Kind, namespace: obj.GetNamespace(), name: obj.GetName()}]
+		if len(paths) == 0 && !scaled {
+			continue
+		}
+		live, err := r.live(obj)
+		if err != nil {
+			return nil, err
+		}
+		if scaled && (live != nil || r.Live == nil) {
+			paths = append(paths, []pathElement{{key: "spec"}, {key: "replicas"}})
+		}
+		var liveObject any
+		if live != nil {
+			liveObject = live.Object
+		}
+		for _, path := range paths {
+			obj.Object = restoreField(obj.Object, liveObject, path).(map[string]any)
+		}
+	}
+	return objects, nil
+}
+
+// live returns the live state of obj, nil without Live.
+func (r *IgnoreDifferencesRenderer) live(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if r.Live == nil {
+		return nil, nil
+	}
+	live, err := r.Live(obj)
+	if err != nil {
+		return nil, fmt.Errorf("get the ignored fields of %s %s/%s: %w", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+	}
+	return live, nil
+}
+
+type scaleTarget struct {
+	group, kind, namespace, name string
+}
+
+// scaleTargets returns the workloads scaled by the HorizontalPodAutoscalers
+// among objects.
+func scaleTargets(objects []*unstructured.Unstructured) map[scaleTarget]bool {
+	targets := map[scaleTarget]bool{}
+	for _, obj := range objects {
+		if !IsGroupKind(obj, "autoscaling", "HorizontalPodAutoscaler") {
+			continue
+		}
+		apiVersion, _, _ := unstructured.NestedString(obj.Object, "spec", "scaleTargetRef", "apiVersion")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "scaleTargetRef", "kind")
+		name, _, _ := unstructured.NestedString(obj.Object, "spec", "scaleTargetRef", "name")
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil || kind == "" || name == "" {
+			continue
+		}
+		targets[scaleTarget{group: gv.Group, kind: kind, namespace: obj.GetNamespace(), name: name}] = true
+	}
+	return targets
+}
+
+type ignoreRule struct {
+	group, kind, name string
+	paths             [][]pathElement
+}
+
+func (r ignoreRule) matches(obj *unstructured.Unstructured) bool {
+	return IsGroupKind(obj, r.group, r.kind) && (r.name == "" || r.name == obj.GetName())
+}
+
+// pathElement is a map key or list index of a field path, or every item of
+// a list.
+type pathElement struct {
+	key string
+	all bool
+}
+
+func compileIgnoreRules(rules []appsv1.IgnoreDifference) ([]ignoreRule, error) {
+	compiled := make([]ignoreRule, 0, len(rules))
+	for _, rule := range rules {
+		ignore := ignoreRule{group: rule.Group, kind: rule.Kind, name: rule.Name}
+		for _, pointer := range rule.JSONPointers {
+			path, err := parseJSONPointer(pointer)
+			if err != nil {
+				return nil, fmt.Errorf("ignore differences of %s: %w", rule.Kind, err)
+			}
+			ignore.paths = append(ignore.paths, path)
+		}
+		for _, expr := range rule.CELPaths {
+			path, err := parseCELPath(expr)
+			if err != nil {
+				return nil, fmt.Errorf("ignore differences of %s: %w", rule.Kind, err)
+			}
+			ignore.paths = append(ignore.paths, path)
+		}
+		compiled = append(compiled, ignore)
+	}
+	return compiled, nil
+}
+
+func parseJSONPointer(pointer string) ([]pathElement, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must start with /", pointer)
+	}
+	var path []pathElement
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		path = append(path, pathElement{key: token})
+	}
+	return path, nil
+}
+
+// celPathEnv parses CEL paths without macros, map stays a plain call.
+var celPathEnv, _ = cel.NewEnv(cel.ClearMacros())
+
+// parseCELPath parses a field selection on object, such as
+// object.spec.template.spec.containers[0].image. A map call selects the
+// field in every item of a list.
+func parseCELPath(expr string) ([]pathElement, error) {
+	parsed, iss := celPathEnv.Parse(expr)
+	if err := iss.Err(); err != nil {
+		return nil, fmt.Errorf("invalid CEL path %q: %w", expr, err)
+	}
+	path, err := celPathElements(parsed.NativeRep().Expr(), "object")
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL path %q: %w", expr, err)
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("invalid CEL path %q: selects the whole object", expr)
+	}
+	return path, nil
+}
+
+func celPathElements(expr celast.Expr, root string) ([]pathElement, error) {
+	switch expr.Kind() {
+	case celast.IdentKind:
+		if expr.AsIdent() == root {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unknown variable %s, want %s", expr.AsIdent(), root)
+	case celast.SelectKind:
+		sel := expr.AsSelect()
+		if sel.IsTestOnly() {
+			break
+		}
+		path, err := celPathElements(sel.Operand(), root)
+		if err != nil {
+			return nil, err
+		}
+		return append(path, pathElement{key: sel.FieldName()}), nil
+	case celast.CallKind:
+		call := expr.AsCall()
+		args := call.Args()
+		switch {
+		case call.FunctionName() == operators.Index && len(args) == 2 && args[1].Kind() == celast.LiteralKind:
+			path, err := celPathElements(args[0], root)
+			if err != nil {
+				return nil, err
+			}
+			return append(path, pathElement{key: fmt.Sprint(args[1].AsLiteral().Value())}), nil
+		case call.FunctionName() == "map" && call.IsMemberFunction() && len(args) == 2 && args[0].Kind() == celast.IdentKind:
+			path, err := celPathElements(call.Target(), root)
+			if err != nil {
+				return nil, err
+			}
+			item, err := celPathElements(args[1], args[0].AsIdent())
+			if err != nil {
+				return nil, err
+			}
+			return append(append(path, pathElement{all: true}), item...), nil
+		}
+	}
+	return nil, fmt.Errorf("only field selections, constant indexes and map are supported")
+}
+
+// restoreField sets the field at path of node to its value in live and
+// returns node. The field is removed when live has none, lists are matched by
+// index and missing fields of node are ignored.
+func restoreField(node, live any, path []pathElement) any {
+	if len(path) == 0 {
+		return node
+	}
+	elem, rest := path[0], path[1:]
+	switch typed := node.(type) {
+	case map[string]any:
+		child, ok := typed[elem.key]
+		if elem.all || !ok {
+			return node
+		}
+		liveMap, _ := live.(map[string]any)
+		liveChild, liveOK := liveMap[elem.key]
+		switch {
+		case len(rest) > 0:
+			typed[elem.key] = restoreField(child, liveChild, rest)
+		case liveOK:
+			typed[elem.key] = runtime.DeepCopyJSONValue(liveChild)
+		default:
+			delete(typed, elem.key)
+		}
+	case []any:
+		liveList, _ := live.([]any)
+		if elem.all {
+			if len(rest) == 0 {
+				if liveList != nil {
+					return runtime.DeepCopyJSONValue(liveList)
+				}
+				return []any{}
+			}
+			for i := range typed {
+				typed[i] = restoreField(typed[i], listItem(liveList, i), rest)
+			}
+			return typed
+		}
+		i, err := strconv.Atoi(elem.key)
+		if err != nil || i < 0 || i >= len(typed) {
+			return node
+		}
+		switch {
+		case len(rest) > 0:
+			typed[i] = restoreField(typed[i], listItem(liveList, i), rest)
+		case i < len(liveList):
+			typed[i] = runtime.DeepCopyJSONValue(liveList[i])
+		default:
+			return append(typed[:i:i], typed[i+1:]...)
+		}
+	}
+	return node
+}
+
+func listItem(list []any, i int) any {
+	if i < len(list) {
+		return list[i]
+	}
+	return nil
+}
+
+// IgnoreDifferences removes the fields selected by rules, and the replicas
+// of workloads targeted by autoscalers, from obj.
+func IgnoreDifferences(obj *unstructured.Unstructured, rules []appsv1.IgnoreDifference, autoscalers []*unstructured.Unstructured) error {
+	_, err := (&IgnoreDifferencesRenderer{Rules: rules, Autoscalers: autoscalers}).ModifyObjects([]*unstructured.Unstructured{obj})
+	return err
+}
@@ -0,0 +1,244 @@
+package postrender
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install/native"
+)
+
+func TestIgnoreDifferencesRendererRemovesFields(t *testing.T) {
+	objects := mustParseObjects(t, `
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata: {name: injector}
+webhooks:
+  - name: a
+    clientConfig: {caBundle: Zm9v, service: {name: injector}}
+  - name: b
+    clientConfig: {caBundle: YmFy}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: settings, annotations: {example.com/a/b: keep, example.com/rotated: x}}
+data: {token: t, greeting: hello}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: other}
+data: {token: t}
+`)
+	renderer := &IgnoreDifferencesRenderer{Rules: []appsv1.IgnoreDifference{
+		{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration", CELPaths: []string{"object.webhooks.map(w, w.clientConfig.caBundle)"}},
+		{Kind: "ConfigMap", Name: "settings", JSONPointers: []string{"/data/token", "/metadata/annotations/example.com~1a~1b"}},
+		{Kind: "ConfigMap", Name: "settings", CELPaths: []string{"object.metadata.annotations['example.com/rotated']"}},
+	}}
+	objects, err := renderer.ModifyObjects(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, _, _ := unstructured.NestedSlice(objects[0].Object, "webhooks")
+	for _, webhook := range webhooks {
+		config := webhook.(map[string]any)["clientConfig"].(map[string]any)
+		if _, ok := config["caBundle"]; ok {
+			t.Errorf("caBundle kept in %v", config)
+		}
+	}
+	if _, ok, _ := unstructured.NestedFieldNoCopy(webhooks[0].(map[string]any), "clientConfig", "service"); !ok {
+		t.Error("sibling of an ignored field removed")
+	}
+	if data := objects[1].Object["data"].(map[string]any); data["token"] != nil || data["greeting"] != "hello" {
+		t.Errorf("settings data = %v", data)
+	}
+	if annotations := objects[1].GetAnnotations(); len(annotations) != 0 {
+		t.Errorf("settings annotations = %v", annotations)
+	}
+	if token, _, _ := unstructured.NestedString(objects[2].Object, "data", "token"); token != "t" {
+		t.Error("rule applied to an object of another name")
+	}
+}
+
+func TestIgnoreDifferencesRendererSkipsAutoscaledReplicas(t *testing.T) {
+	objects := mustParseObjects(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web, namespace: demo}
+spec: {replicas: 2}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: worker, namespace: demo}
+spec: {replicas: 2}
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: database, namespace: demo}
+spec: {replicas: 3}
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata: {name: web, namespace: demo}
+spec:
+  scaleTargetRef: {apiVersion: apps/v1, kind: Deployment, name: web}
+`)
+	live := mustParseObjects(t, `
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata: {name: database, namespace: demo}
+spec:
+  scaleTargetRef: {apiVersion: apps/v1, kind: StatefulSet, name: database}
+`)
+	objects, err := (&IgnoreDifferencesRenderer{Autoscalers: live}).ModifyObjects(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{false, true, false} {
+		if _, ok, _ := unstructured.NestedInt64(objects[i].Object, "spec", "replicas"); ok != want {
+			t.Errorf("%s replicas kept = %v, want %v", objects[i].GetName(), ok, want)
+		}
+	}
+}
+
+func TestIgnoreDifferencesRendererRejectsInvalidPaths(t *testing.T) {
+	for _, rule := range []appsv1.IgnoreDifference{
+		{Kind: "ConfigMap", JSONPointers: []string{"data/token"}},
+		{Kind: "ConfigMap", CELPaths: []string{"self.data"}},
+		{Kind: "ConfigMap", CELPaths: []string{"object"}},
+		{Kind: "ConfigMap", CELPaths: []string{"object.data.filter(k, k == 'x')"}},
+		{Kind: "ConfigMap", CELPaths: []string{"object.data["}},
+	} {
+		if _, err := (&IgnoreDifferencesRenderer{Rules: []appsv1.IgnoreDifference{rule}}).ModifyObjects(nil); err == nil {
+			t.Errorf("rule %+v accepted", rule)
+		}
+	}
+}
+
+func TestIgnoreDifferencesRendererKeepsLiveReplicas(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewClientBuilder().Build()
+	renderer := &IgnoreDifferencesRenderer{
+		Live: func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(obj.GroupVersionKind())
+			if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+				return nil, client.IgnoreNotFound(err)
+			}
+			return live, nil
+		},
+	}
+	// apply returns the replicas rendered and applies them
+	apply := func() any {
+		t.Helper()
+		objects, err := renderer.ModifyObjects(mustParseObjects(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web, namespace: demo}
+spec: {replicas: 2}
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata: {name: web, namespace: demo}
+spec:
+  scaleTargetRef: {apiVersion: apps/v1, kind: Deployment, name: web}
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rendered, _, _ := unstructured.NestedFieldCopy(objects[0].Object, "spec", "replicas")
+		if err := native.ApplyResource(ctx, cli, objects[0], native.ApplyOptions{ServerSideApply: true, FieldOwner: "web"}); err != nil {
+			t.Fatal(err)
+		}
+		return rendered
+	}
+	replicas := func() int64 {
+		t.Helper()
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+		if err := cli.Get(ctx, client.ObjectKey{Namespace: "demo", Name: "web"}, live); err != nil {
+			t.Fatal(err)
+		}
+		replicas, _, _ := unstructured.NestedInt64(live.Object, "spec", "replicas")
+		return replicas
+	}
+
+	if rendered := apply(); rendered != int64(2) {
+		t.Fatalf("rendered replicas of a new workload = %v, want 2", rendered)
+	}
+	if got := replicas(); got != 2 {
+		t.Fatalf("created replicas = %d, want the rendered 2", got)
+	}
+	// the autoscaler scales the workload out between the applies
+	scale := client.RawPatch(types.MergePatchType, []byte(`{"spec":{"replicas":5}}`))
+	deployment := &unstructured.Unstructured{}
+	deployment.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+	deployment.SetNamespace("demo")
+	deployment.SetName("web")
+	if err := cli.Patch(ctx, deployment, scale, client.FieldOwner("horizontal-pod-autoscaler")); err != nil {
+		t.Fatal(err)
+	}
+	if rendered := apply(); rendered != int64(5) {
+		t.Errorf("rendered replicas = %v, want the live 5", rendered)
+	}
+	if got := replicas(); got != 5 {
+		t.Errorf("replicas after the second apply = %d, want the live 5", got)
+	}
+}
+
+func TestIgnoreDifferencesRendererRestoresLiveFields(t *testing.T) {
+	objects := mustParseObjects(t, `
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata: {name: injector}
+webhooks:
+  - name: a
+    clientConfig: {caBundle: rendered}
+  - name: b
+    clientConfig: {caBundle: rendered}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: settings}
+data: {token: rendered, greeting: hello}
+`)
+	live := mustParseObjects(t, `
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata: {name: injector}
+webhooks:
+  - name: a
+    clientConfig: {caBundle: injected}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {name: settings}
+data: {greeting: hi}
+`)
+	renderer := &IgnoreDifferencesRenderer{
+		Rules: []appsv1.IgnoreDifference{
+			{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration", CELPaths: []string{"object.webhooks.map(w, w.clientConfig.caBundle)"}},
+			{Kind: "ConfigMap", JSONPointers: []string{"/data/token"}},
+		},
+		Live: func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+			return objectByName(t, live, obj.GetName()), nil
+		},
+	}
+	objects, err := renderer.ModifyObjects(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, _, _ := unstructured.NestedSlice(objects[0].Object, "webhooks")
+	first, _, _ := unstructured.NestedString(webhooks[0].(map[string]any), "clientConfig", "caBundle")
+	_, second, _ := unstructured.NestedString(webhooks[1].(map[string]any), "clientConfig", "caBundle")
+	if first != "injected" || second {
+		t.Errorf("caBundles = %q, %v, want the live one and none for the new webhook", first, second)
+	}
+	if data := objects[1].Object["data"].(map[string]any); len(data) != 1 || data["greeting"] != "hello" {
+		t.Errorf("settings data = %v, want the token without a live value removed", data)
+	}
+}
@@ -1,8 +1,9 @@
 package postrender
 
-import "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-
-const LabelInstance = "app.kubernetes.io/instance"
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"xiaoshiai.cn/installer/install"
+)
 
 // InstanceIdentityRenderer applies the installer-controlled instance label to
 // rendered resources and Pod templates. It is a platform invariant rather
@@ -12,7 +13,7 @@ type InstanceIdentityRenderer struct {
 }
 
 func (r *InstanceIdentityRenderer) ModifyObjects(objects []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
-	labels := map[string]string{LabelInstance: r.InstanceName}
+	labels := map[string]string{install.LabelInstance: r.InstanceName}
 	for _, obj := range objects {
 		obj.SetLabels(MergeLabels(obj.GetLabels(), labels))
 		for _, path := range podTemplateMetadataPaths(obj) {
@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/registry"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/download"
+	"xiaoshiai.cn/installer/install/helm"
+)
+
+const (
+	// DefaultRepositoryInterval is used when a Repository does not set an interval.
+	DefaultRepositoryInterval = 10 * time.Minute
+
+	// maxRepositoryChartVersions limits the versions published per chart in
+	// the Repository status, the cached index keeps all of them.
+	maxRepositoryChartVersions = 10
+
+	repositoryCacheDirName = "repositories"
+)
+
+// RepositoryReconciler refreshes the index of Repositories into the cache
+// directory and publishes the available charts in their status.
+type RepositoryReconciler struct {
+	Client   client.Client
+	CacheDir string
+}
+
+func (r *RepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	repository := &appsv1.Repository{}
+	if err := r.Client.Get(ctx, req.NamespacedName, repository); err != nil {
+		if apierrors.IsNotFound(err) {
+			// the cached index and certificates are not needed anymore
+			return ctrl.Result{}, os.RemoveAll(repositoryCacheDir(r.CacheDir, req.Namespace, req.Name))
+		}
+		return ctrl.Result{}, err
+	}
+	if repository.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	interval := repositoryInterval(repository)
+	if wait := r.nextRefresh(repository, interval); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	original := repository.DeepCopy()
+	err := r.refresh(ctx, repository)
+	if err != nil {
+		log.Error(err, "refresh repository index")
+	}
+	repository.Status.ObservedGeneration = repository.Generation
+	if !equality.Semantic.DeepEqual(&original.Status, &repository.Status) {
+		if err := r.Client.Status().Update(ctx, repository); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// nextRefresh returns how long the index of repository is still fresh.
+func (r *RepositoryReconciler) nextRefresh(repository *appsv1.Repository, interval time.Duration) time.Duration {
+	if repository.Status.ObservedGeneration != repository.Generation {
+		return 0
+	}
+	if !meta.IsStatusConditionTrue(repository.Status.Conditions, appsv1.ConditionReady) {
+		return 0
+	}
+	if !registry.IsOCI(repository.Spec.URL) {
+		if _, err := os.Stat(RepositoryIndexFile(r.CacheDir, repository)); err != nil {
+			return 0
+		}
+	}
+	return time.Until(repository.Status.LastRefreshTime.Add(interval))
+}
+
+func (r *RepositoryReconciler) refresh(ctx context.Context, repository *appsv1.Repository) error {
+	// OCI registries serve charts by reference and have no index
+	if registry.IsOCI(repository.Spec.URL) {
+		repository.Status.Charts = nil
+		repository.Status.IndexDigest = ""
+		repository.Status.LastRefreshTime = metav1.Now()
+		setRepositoryCondition(repository, metav1.ConditionTrue, "NoIndex", "OCI registries do not serve an index")
+		return nil
+	}
+
+	auth, tls, err := resolveRepository(ctx, r.Client, r.CacheDir, repository)
+	if err != nil {
+		setRepositoryCondition(repository, metav1.ConditionFalse, "ResolveAuthFailed", err.Error())
+		return err
+	}
+	opts := download.RepositoryOptions(install.Instance{Auth: auth, TLS: tls})
+	data, index, err := helm.FetchIndex(ctx, repository.Spec.URL, opts)
+	if err != nil {
+		setRepositoryCondition(repository, metav1.ConditionFalse, "IndexFetchFailed", err.Error())
+		return err
+	}
+	indexFile := RepositoryIndexFile(r.CacheDir, repository)
+	if err := os.MkdirAll(filepath.Dir(indexFile), helm.DefaultDirectoryMode); err != nil {
+		return err
+	}
+	if err := helm.AtomicWriteFile(indexFile, bytes.NewReader(data), helm.DefaultFileMode); err != nil {
+		setRepositoryCondition(repository, metav1.ConditionFalse, "IndexCacheFailed", err.Error())
+		return err
+	}
+
+	charts := make([]appsv1.RepositoryChart, 0, len(index.Entries))
+	for name, versions := range index.Entries {
+		chart := appsv1.RepositoryChart{Name: name}
+		// entries are sorted newest first
+		for _, version := range versions {
+			if len(chart.Versions) == maxRepositoryChartVersions {
+				break
+			}
+			chart.Versions = append(chart.Versions, version.Version)
+		}
+		charts = append(charts, chart)
+	}
+	sort.Slice(charts, func(i, j int) bool { return charts[i].Name < charts[j].Name })
+
+	repository.Status.Charts = charts
+	repository.Status.IndexDigest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	repository.Status.LastRefreshTime = metav1.Now()
+	setRepositoryCondition(repository, metav1.ConditionTrue, "IndexReady", fmt.Sprintf("Index lists %d charts", len(charts)))
+	return nil
+}
+
+func setRepositoryCondition(repository *appsv1.Repository, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&repository.Status.Conditions, metav1.Condition{
+		Type:               appsv1.ConditionReady,
+		Status:             status,
+		ObservedGeneration: repository.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+func repositoryInterval(repository *appsv1.Repository) time.Duration {
+	if interval := repository.Spec.Interval.Duration; interval > 0 {
+		return interval
+	}
+	return DefaultRepositoryInterval
+}
+
+func repositoryCacheDir(cacheDir, namespace, name string) string {
+	return filepath.Join(cacheDir, repositoryCacheDirName, namespace, name)
+}
+
+// RepositoryIndexFile returns the path the index of repository is cached at.
+func RepositoryIndexFile(cacheDir string, repository *appsv1.Repository) string {
+	return filepath.Join(repositoryCacheDir(cacheDir, repository.Namespace, repository.Name), helm.IndexFileName)
+}
+
+// resolveRepository resolves the credentials and TLS settings of repository,
+// writing the certificates of its TLS Secret into the repository cache directory.
+func resolveRepository(ctx context.Context, cli client.Client, cacheDir string, repository *appsv1.Repository) (*install.ResolvedAuth, *install.ResolvedTLS, error) {
+	auth, err := resolveRepositoryAuth(ctx, cli, repository.Namespace, repository.Spec.Auth, repository.Spec.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	// unlike a raw Instance URL, a Repository verifies certificates by default
+	resolved := &install.ResolvedTLS{}
+	tls := repository.Spec.TLS
+	if tls == nil {
+		return auth, resolved, nil
+	}
+	resolved.InsecureSkipVerify = tls.InsecureSkipVerify
+	if tls.SecretRef == nil {
+		return auth, resolved, nil
+	}
+	secret := &corev1.Secret{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: repository.Namespace, Name: tls.SecretRef.Name}, secret); err != nil {
+		return nil, nil, fmt.Errorf("get tls secret %q: %w", tls.SecretRef.Name, err)
+	}
+	dir := repositoryCacheDir(cacheDir, repository.Namespace, repository.Name)
+	if err := os.MkdirAll(dir, helm.DefaultDirectoryMode); err != nil {
+		return nil, nil, err
+	}
+	for key, into := range map[string]*string{
+		"ca.crt":                &resolved.CAFile,
+		corev1.TLSCertKey:       &resolved.CertFile,
+		corev1.TLSPrivateKeyKey: &resolved.KeyFile,
+	} {
+		data, ok := secret.Data[key]
+		if !ok {
+			continue
+		}
+		file := filepath.Join(dir, key)
+		// every Instance reconcile resolves the repository, the files are
+		// rewritten only when the Secret changed
+		if current, err := os.ReadFile(file); err != nil || !bytes.Equal(current, data) {
+			if err := helm.AtomicWriteFile(file, bytes.NewReader(data), 0o600); err != nil {
+				return nil, nil, fmt.Errorf("write %s of tls secret %q: %w", key, tls.SecretRef.Name, err)
+			}
+		}
+		*into = file
+	}
+	if (resolved.CertFile == "") != (resolved.KeyFile == "") {
+		return nil, nil, fmt.Errorf("tls secret %q must contain both %s and %s", tls.SecretRef.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	return auth, resolved, nil
+}
+
+// RepositoryChangedPredicate passes the changes of a Repository its Instances
+// depend on, its spec and its readiness, and not the periodic refreshes of
+// its index.
+func RepositoryChangedPredicate() predicate.TypedPredicate[*appsv1.Repository] {
+	return predicate.TypedFuncs[*appsv1.Repository]{
+		UpdateFunc: func(e event.TypedUpdateEvent[*appsv1.Repository]) bool {
+			return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+				meta.IsStatusConditionTrue(e.ObjectOld.Status.Conditions, appsv1.ConditionReady) !=
+					meta.IsStatusConditionTrue(e.ObjectNew.Status.Conditions, appsv1.ConditionReady)
+		},
+	}
+}
+
+// repositoriesUsingSecret returns the names of the Repositories in namespace
+// whose credentials or certificates are held by the Secret name.
+func repositoriesUsingSecret(ctx context.Context, cli client.Client, namespace, name string) map[string]bool {
+	repositories := &appsv1.RepositoryList{}
+	_ = cli.List(ctx, repositories, client.InNamespace(namespace))
+	using := map[string]bool{}
+	for _, repository := range repositories.Items {
+		spec := repository.Spec
+		if (spec.Auth != nil && spec.Auth.SecretRef != nil && spec.Auth.SecretRef.Name == name) ||
+			(spec.TLS != nil && spec.TLS.SecretRef != nil && spec.TLS.SecretRef.Name == name) {
+			using[repository.Name] = true
+		}
+	}
+	return using
+}
+
+// RepositoryEventHandler enqueues the Instances referring to a changed Repository.
+func RepositoryEventHandler(cli client.Client) handler.TypedEventHandler[*appsv1.Repository, reconcile.Request] {
+	return handler.TypedEnqueueRequestsFromMapFunc(handler.TypedMapFunc[*appsv1.Repository, reconcile.Request](func(ctx context.Context, repository *appsv1.Repository) []reconcile.Request {
+		instances := &appsv1.InstanceList{}
+		_ = cli.List(ctx, instances, client.InNamespace(repository.Namespace))
+		var requests []reconcile.Request
+		for _, instance := range instances.Items {
+			if ref := instance.Spec.RepositoryRef; ref != nil && ref.Name == repository.Name {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&instance)})
+			}
+		}
+		return requests
+	}))
+}
+
+// resolveInstanceRepository points instanceSpec at the Repository referred by
+// instance, using its URL, credentials and cached index.
+func (r *InstanceReconciler) resolveInstanceRepository(ctx context.Context, instance *appsv1.Instance, instanceSpec *install.Instance) (string, error) {
+	repository, err := r.getInstanceRepository(ctx, instance)
+	if err != nil {
+		return "RepositoryNotFound", err
+	}
+	if !meta.IsStatusConditionTrue(repository.Status.Conditions, appsv1.ConditionReady) {
+		message := "index has not been fetched yet"
+		if cond := meta.FindStatusCondition(repository.Status.Conditions, appsv1.ConditionReady); cond != nil && cond.Message != "" {
+			message = cond.Message
+		}
+		return "RepositoryNotReady", fmt.Errorf("repository %q is not ready: %s", repository.Name, message)
+	}
+	if err := r.useRepository(ctx, repository, instanceSpec); err != nil {
+		return "ResolveAuthFailed", err
+	}
+	return "", nil
+}
+
+func (r *InstanceReconciler) getInstanceRepository(ctx context.Context, instance *appsv1.Instance) (*appsv1.Repository, error) {
+	repository := &appsv1.Repository{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: instance.Spec.RepositoryRef.Name}
+	if err := r.Client.Get(ctx, key, repository); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("repository %q not found", key.Name)
+		}
+		return nil, err
+	}
+	return repository, nil
+}
+
+// repositoryChart is the chart an Instance installs from its Repository, as
+// downloaded by the delegate.
+func repositoryChart(instanceSpec install.Instance) string {
+	if instanceSpec.Chart == "" {
+		return instanceSpec.Name
+	}
+	return instanceSpec.Chart
+}
+
+// useRepository sets the URL, credentials and, when cached, the index of
+// repository on instanceSpec. The chart is joined onto the URL of an OCI
+// registry.
+func (r *InstanceReconciler) useRepository(ctx context.Context, repository *appsv1.Repository, instanceSpec *install.Instance) error {
+	auth, tls, err := resolveRepository(ctx, r.Client, r.CacheDir, repository)
+	if err != nil {
+		return err
+	}
+	instanceSpec.Repository = helm.ChartURL(repository.Spec.URL, repositoryChart(*instanceSpec))
+	instanceSpec.Auth, instanceSpec.TLS = auth, tls
+	if !registry.IsOCI(repository.Spec.URL) && !strings.HasPrefix(repository.Spec.URL, helm.FileProtocolSchema+"://") {
+		if index := RepositoryIndexFile(r.CacheDir, repository); fileExists(index) {
+			instanceSpec.RepositoryIndex = index
+		}
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
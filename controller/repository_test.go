@@ -0,0 +1,346 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+const testRepositoryIndex = `apiVersion: v1
+entries:
+  demo:
+  - name: demo
+    version: 1.1.0
+    urls: [charts/demo-1.1.0.tgz]
+  - name: demo
+    version: 1.0.0
+    urls: [charts/demo-1.0.0.tgz]
+`
+
+func newRepositoryTestClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("add core scheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("add apps scheme: %v", err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).
+		WithStatusSubresource(&appsv1.Repository{}, &appsv1.Instance{}).
+		WithObjects(objects...).Build()
+}
+
+func TestRepositoryReconcilerCachesIndex(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(testRepositoryIndex))
+	}))
+	defer server.Close()
+
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "charts", Namespace: "default", Generation: 1},
+		Spec: appsv1.RepositorySpec{
+			URL:      server.URL,
+			Auth:     &appsv1.RepositoryAuth{Username: "admin", Password: "secret"},
+			Interval: metav1.Duration{Duration: time.Hour},
+		},
+	}
+	cli := newRepositoryTestClient(t, repository)
+	r := &RepositoryReconciler{Client: cli, CacheDir: t.TempDir()}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(repository)}
+
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter != time.Hour {
+		t.Fatalf("Reconcile() requeue after = %v, want 1h", result.RequeueAfter)
+	}
+	if err := cli.Get(context.Background(), req.NamespacedName, repository); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(repository.Status.Conditions, appsv1.ConditionReady) {
+		t.Fatalf("Ready condition = %#v, want true", repository.Status.Conditions)
+	}
+	if len(repository.Status.Charts) != 1 || repository.Status.Charts[0].Name != "demo" ||
+		len(repository.Status.Charts[0].Versions) != 2 || repository.Status.Charts[0].Versions[0] != "1.1.0" {
+		t.Fatalf("status charts = %#v, want demo 1.1.0, 1.0.0", repository.Status.Charts)
+	}
+	if _, err := os.Stat(RepositoryIndexFile(r.CacheDir, repository)); err != nil {
+		t.Fatalf("cached index: %v", err)
+	}
+
+	// the index is still fresh
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("second Reconcile() error = %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("index requests = %d, want 1", got)
+	}
+}
+
+func TestRepositoryReconcilerReportsFetchFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "charts", Namespace: "default", Generation: 1},
+		Spec:       appsv1.RepositorySpec{URL: server.URL},
+	}
+	cli := newRepositoryTestClient(t, repository)
+	r := &RepositoryReconciler{Client: cli, CacheDir: t.TempDir()}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(repository)}
+	if _, err := r.Reconcile(context.Background(), req); err == nil {
+		t.Fatal("Reconcile() error = nil, want fetch failure")
+	}
+	if err := cli.Get(context.Background(), req.NamespacedName, repository); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(repository.Status.Conditions, appsv1.ConditionReady)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "IndexFetchFailed" {
+		t.Fatalf("Ready condition = %#v, want False/IndexFetchFailed", cond)
+	}
+}
+
+type recordingInstaller struct {
+	countingInstaller
+	last install.Instance
+}
+
+func (c *recordingInstaller) Apply(ctx context.Context, instance install.Instance) (*install.InstanceStatus, error) {
+	c.last = instance
+	return c.countingInstaller.Apply(ctx, instance)
+}
+
+func (c *recordingInstaller) Remove(ctx context.Context, instance install.Instance) ([]appsv1.ManagedResource, error) {
+	c.last = instance
+	return c.countingInstaller.Remove(ctx, instance)
+}
+
+func TestSyncInstallUsesRepositoryRef(t *testing.T) {
+	cacheDir := t.TempDir()
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "charts", Namespace: "default", Generation: 1},
+		Spec: appsv1.RepositorySpec{
+			URL: "https://charts.example.test",
+			Auth: &appsv1.RepositoryAuth{
+				SecretRef: &corev1.LocalObjectReference{Name: "charts-auth"},
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "charts-auth", Namespace: "default"},
+		Type:       corev1.SecretTypeBasicAuth,
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+	}
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 1},
+		Spec: appsv1.InstanceSpec{
+			Kind:          appsv1.InstanceKindHelm,
+			RepositoryRef: &corev1.LocalObjectReference{Name: "charts"},
+			Chart:         "demo",
+			Version:       "1.0.0",
+		},
+	}
+	applier := &recordingInstaller{}
+	cli := newRepositoryTestClient(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, repository, secret)
+	reconciler := &InstanceReconciler{
+		Client:                       cli,
+		Applier:                      applier,
+		CacheDir:                     cacheDir,
+		AllowClusterScopedNamespaces: map[string]struct{}{},
+	}
+
+	if err := reconciler.syncInstall(context.Background(), instance); err == nil {
+		t.Fatal("syncInstall() error = nil, want repository not ready")
+	}
+	if cond := meta.FindStatusCondition(instance.Status.Conditions, appsv1.ConditionInstalled); cond == nil || cond.Reason != "RepositoryNotReady" {
+		t.Fatalf("Installed condition = %#v, want RepositoryNotReady", cond)
+	}
+
+	setRepositoryCondition(repository, metav1.ConditionTrue, "IndexReady", "")
+	if err := cli.Status().Update(context.Background(), repository); err != nil {
+		t.Fatal(err)
+	}
+	index := RepositoryIndexFile(cacheDir, repository)
+	if err := os.MkdirAll(repositoryCacheDir(cacheDir, "default", "charts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(index, []byte(testRepositoryIndex), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconciler.syncInstall(context.Background(), instance); err != nil {
+		t.Fatalf("syncInstall() error = %v", err)
+	}
+	got := applier.last
+	if got.Repository != repository.Spec.URL || got.RepositoryIndex != index {
+		t.Fatalf("applied repository = %q index %q, want %q index %q", got.Repository, got.RepositoryIndex, repository.Spec.URL, index)
+	}
+	if got.Auth == nil || got.Auth.Username != "admin" || got.Auth.Password != "secret" {
+		t.Fatalf("applied auth = %#v, want admin/secret", got.Auth)
+	}
+	if got.TLS == nil || got.TLS.InsecureSkipVerify {
+		t.Fatalf("applied TLS = %#v, want verified TLS", got.TLS)
+	}
+}
+
+func TestSyncInstallUsesOCIRepositoryRef(t *testing.T) {
+	cacheDir := t.TempDir()
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default", Generation: 1},
+		Spec: appsv1.RepositorySpec{
+			URL: "oci://registry.example.test/charts/",
+			TLS: &appsv1.RepositoryTLS{SecretRef: &corev1.LocalObjectReference{Name: "registry-tls"}},
+		},
+	}
+	setRepositoryCondition(repository, metav1.ConditionTrue, "NoIndex", "")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-tls", Namespace: "default"},
+		Data:       map[string][]byte{"ca.crt": []byte("ca")},
+	}
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 1},
+		Spec: appsv1.InstanceSpec{
+			Kind:          appsv1.InstanceKindHelm,
+			RepositoryRef: &corev1.LocalObjectReference{Name: "registry"},
+			Chart:         "nginx",
+			Version:       "1.0.0",
+		},
+	}
+	applier := &recordingInstaller{}
+	cli := newRepositoryTestClient(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, repository, secret)
+	reconciler := &InstanceReconciler{
+		Client:                       cli,
+		Applier:                      applier,
+		CacheDir:                     cacheDir,
+		AllowClusterScopedNamespaces: map[string]struct{}{},
+	}
+	if err := reconciler.syncInstall(context.Background(), instance); err != nil {
+		t.Fatalf("syncInstall() error = %v", err)
+	}
+	got := applier.last
+	if want := "oci://registry.example.test/charts/nginx"; got.Repository != want || got.RepositoryIndex != "" {
+		t.Fatalf("applied repository = %q index %q, want %q without index", got.Repository, got.RepositoryIndex, want)
+	}
+
+	// the certificate is written once, not on every reconcile
+	caFile := got.TLS.CAFile
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(caFile, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	instance.Generation++
+	if err := reconciler.syncInstall(context.Background(), instance); err != nil {
+		t.Fatalf("syncInstall() error = %v", err)
+	}
+	if fi, err := os.Stat(caFile); err != nil || !fi.ModTime().Equal(modified) {
+		t.Fatalf("unchanged certificate was rewritten: %v", err)
+	}
+	secret.Data["ca.crt"] = []byte("rotated")
+	if err := cli.Update(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	instance.Generation++
+	if err := reconciler.syncInstall(context.Background(), instance); err != nil {
+		t.Fatalf("syncInstall() error = %v", err)
+	}
+	if data, _ := os.ReadFile(caFile); string(data) != "rotated" {
+		t.Fatalf("certificate = %q after the Secret changed, want rotated", data)
+	}
+}
+
+func TestRemoveUsesRepositoryRef(t *testing.T) {
+	cacheDir := t.TempDir()
+	repository := &appsv1.Repository{
+		ObjectMeta: metav1.ObjectMeta{Name: "bundles", Namespace: "default", Generation: 1},
+		Spec: appsv1.RepositorySpec{
+			URL:  "https://bundles.example.test",
+			Auth: &appsv1.RepositoryAuth{SecretRef: &corev1.LocalObjectReference{Name: "bundles-auth"}},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bundles-auth", Namespace: "default"},
+		Type:       corev1.SecretTypeBasicAuth,
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+	}
+	instance := &appsv1.Instance{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 1},
+		Spec: appsv1.InstanceSpec{
+			Kind:          appsv1.InstanceKindManifests,
+			RepositoryRef: &corev1.LocalObjectReference{Name: "bundles"},
+			Path:          "demo",
+		},
+	}
+	applier := &recordingInstaller{}
+	cli := newRepositoryTestClient(t, repository, secret, instance)
+	reconciler := &InstanceReconciler{Client: cli, Applier: applier, CacheDir: cacheDir}
+
+	// the delete hooks are not skipped while the source cannot be fetched
+	if _, err := reconciler.Remove(context.Background(), instance); err == nil || applier.removeCount != 0 {
+		t.Fatalf("Remove() error = %v, calls = %d, want repository not ready", err, applier.removeCount)
+	}
+
+	setRepositoryCondition(repository, metav1.ConditionTrue, "IndexReady", "")
+	if err := cli.Status().Update(context.Background(), repository); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reconciler.Remove(context.Background(), instance); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	got := applier.last
+	if got.Repository != repository.Spec.URL {
+		t.Fatalf("removed repository = %q, want %q", got.Repository, repository.Spec.URL)
+	}
+	if got.Auth == nil || got.Auth.Username != "admin" || got.TLS == nil {
+		t.Fatalf("removed auth = %#v, TLS = %#v, want the repository credentials", got.Auth, got.TLS)
+	}
+}
+
+func TestRepositoryChangedPredicate(t *testing.T) {
+	old := &appsv1.Repository{ObjectMeta: metav1.ObjectMeta{Name: "charts", Generation: 1}}
+	setRepositoryCondition(old, metav1.ConditionTrue, "IndexReady", "")
+	refreshed := old.DeepCopy()
+	refreshed.Status.LastRefreshTime = metav1.Now()
+	refreshed.Status.IndexDigest = "sha256:new"
+	failed := old.DeepCopy()
+	setRepositoryCondition(failed, metav1.ConditionFalse, "IndexFetchFailed", "")
+	changed := old.DeepCopy()
+	changed.Generation++
+
+	predicate := RepositoryChangedPredicate()
+	for name, tc := range map[string]struct {
+		new  *appsv1.Repository
+		want bool
+	}{
+		"refresh":   {new: refreshed, want: false},
+		"readiness": {new: failed, want: true},
+		"spec":      {new: changed, want: true},
+	} {
+		if got := predicate.Update(event.TypedUpdateEvent[*appsv1.Repository]{ObjectOld: old, ObjectNew: tc.new}); got != tc.want {
+			t.Errorf("%s: Update() = %v, want %v", name, got, tc.want)
+		}
+	}
+}
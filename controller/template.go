@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install/delegate"
+	"xiaoshiai.cn/installer/utils"
+)
+
+// TemplateOptions configures rendering Instances without a cluster.
+type TemplateOptions struct {
+	// Namespace is used for objects which do not set one.
+	Namespace string
+	// CacheDir is the directory sources are downloaded into.
+	CacheDir string
+	// AllowClusterScopedNamespaces lists the namespaces whose instances may render
+	// cluster-scoped resources, in addition to annotated Namespaces in the input.
+	AllowClusterScopedNamespaces []string
+}
+
+func NewDefaultTemplateOptions() *TemplateOptions {
+	defaults := NewDefaultOptions()
+	return &TemplateOptions{
+		Namespace:                    corev1.NamespaceDefault,
+		CacheDir:                     defaults.CacheDir,
+		AllowClusterScopedNamespaces: defaults.AllowClusterScopedNamespaces,
+	}
+}
+
+// clusterScopedKinds are the built-in kinds which are not namespaced.
+var clusterScopedKinds = []schema.GroupKind{
+	{Group: "", Kind: "Namespace"},
+	{Group: "", Kind: "Node"},
+	{Group: "", Kind: "PersistentVolume"},
+	{Group: "", Kind: "ComponentStatus"},
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"},
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"},
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingAdmissionPolicy"},
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingAdmissionPolicyBinding"},
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingAdmissionPolicy"},
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingAdmissionPolicyBinding"},
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"},
+	{Group: "certificates.k8s.io", Kind: "CertificateSigningRequest"},
+	{Group: "certificates.k8s.io", Kind: "ClusterTrustBundle"},
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "FlowSchema"},
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "PriorityLevelConfiguration"},
+	{Group: "networking.k8s.io", Kind: "IngressClass"},
+	{Group: "networking.k8s.io", Kind: "IPAddress"},
+	{Group: "networking.k8s.io", Kind: "ServiceCIDR"},
+	{Group: "node.k8s.io", Kind: "RuntimeClass"},
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"},
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"},
+	{Group: "resource.k8s.io", Kind: "DeviceClass"},
+	{Group: "resource.k8s.io", Kind: "ResourceSlice"},
+	{Group: "scheduling.k8s.io", Kind: "PriorityClass"},
+	{Group: "storage.k8s.io", Kind: "CSIDriver"},
+	{Group: "storage.k8s.io", Kind: "CSINode"},
+	{Group: "storage.k8s.io", Kind: "StorageClass"},
+	{Group: "storage.k8s.io", Kind: "VolumeAttachment"},
+	{Group: "storage.k8s.io", Kind: "VolumeAttributesClass"},
+}
+
+// ReadObjects reads the objects of the YAML or JSON files, directories and
+// "-" for stdin in filenames. Objects of kinds unknown to the scheme are skipped.
+func ReadObjects(filenames []string) ([]client.Object, error) {
+	scheme := GetScheme()
+	var objects []client.Object
+	for _, filename := range filenames {
+		files, err := expandFilename(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			var data []byte
+			if file == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return nil, err
+			}
+			uns, err := utils.SplitYAML(data)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %w", file, err)
+			}
+			for _, u := range uns {
+				newobj, err := scheme.New(u.GroupVersionKind())
+				if err != nil {
+					continue
+				}
+				obj, ok := newobj.(client.Object)
+				if !ok {
+					continue
+				}
+				raw, err := json.Marshal(u.Object)
+				if err != nil {
+					return nil, err
+				}
+				if err := json.Unmarshal(raw, obj); err != nil {
+					return nil, fmt.Errorf("read %s %s %s: %w", file, u.GetKind(), u.GetName(), err)
+				}
+				if secret, ok := obj.(*corev1.Secret); ok {
+					mergeSecretStringData(secret)
+				}
+				objects = append(objects, obj)
+			}
+		}
+	}
+	return objects, nil
+}
+
+func expandFilename(filename string) ([]string, error) {
+	if filename == "-" {
+		return []string{filename}, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{filename}, nil
+	}
+	entries, err := os.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(filename, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// mergeSecretStringData moves stringData into data as the API server does.
+func mergeSecretStringData(secret *corev1.Secret) {
+	if len(secret.StringData) == 0 {
+		return
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for k, v := range secret.StringData {
+		secret.Data[k] = []byte(v)
+	}
+	secret.StringData = nil
+}
+
+// Template renders the Instances in objects the way the controller would
+// apply them. valuesFrom, artifacts, credentials and repositoryRef are resolved
+// against the other objects instead of a cluster.
+func Template(ctx context.Context, objects []client.Object, options *TemplateOptions) ([]byte, error) {
+	r, instances := newOfflineReconciler(objects, options)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance found")
+	}
+	out := &bytes.Buffer{}
+	for _, instance := range instances {
+		rendered, err := r.Template(ctx, instance)
+		if err != nil {
+			return nil, fmt.Errorf("template instance %s/%s: %w", instance.Namespace, instance.Name, err)
+		}
+		rendered = bytes.TrimSpace(rendered)
+		if !bytes.HasPrefix(rendered, []byte("---")) {
+			out.WriteString("---\n")
+		}
+		out.Write(rendered)
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+// Template renders instance with the resolved values and the post-render
+// pipeline used when it is applied.
+func (r *InstanceReconciler) Template(ctx context.Context, instance *appsv1.Instance) ([]byte, error) {
+	if err := validateInstanceSource(instance); err != nil {
+		return nil, err
+	}
+	values, err := r.resolveValues(ctx, instance)
+	if err != nil {
+		return nil, fmt.Errorf("resolve values: %w", err)
+	}
+	auth, err := r.resolveAuth(ctx, instance)
+	if err != nil {
+		return nil, fmt.Errorf("resolve auth: %w", err)
+	}
+	instanceSpec := installerInstanceFrom(instance, values, auth)
+	if instance.Spec.RepositoryRef != nil {
+		// rendering only needs the repository URL and credentials, the index
+		// is used when it has been cached
+		repository, err := r.getInstanceRepository(ctx, instance)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.useRepository(ctx, repository, &instanceSpec); err != nil {
+			return nil, fmt.Errorf("resolve repository: %w", err)
+		}
+	}
+	instanceSpec.PostRenderer = r.buildPostRenderer(ctx, instance, values)
+	return r.Applier.Template(ctx, instanceSpec)
+}
+
+// newOfflineReconciler returns an InstanceReconciler reading from a client
+// holding objects, with the REST mapping of built-in kinds and of the
+// CustomResourceDefinitions in objects.
+func newOfflineReconciler(objects []client.Object, options *TemplateOptions) (*InstanceReconciler, []*appsv1.Instance) {
+	scheme := GetScheme()
+	mapper := newOfflineRESTMapper(scheme, objects)
+
+	var instances []*appsv1.Instance
+	for _, obj := range objects {
+		if obj.GetNamespace() == "" && isNamespaced(mapper, obj, scheme) {
+			obj.SetNamespace(options.Namespace)
+		}
+		if instance, ok := obj.(*appsv1.Instance); ok {
+			instances = append(instances, instance)
+		}
+	}
+	cli := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(mapper).
+		WithObjects(objects...).
+		Build()
+
+	allowNS := make(map[string]struct{}, len(options.AllowClusterScopedNamespaces))
+	for _, ns := range options.AllowClusterScopedNamespaces {
+		allowNS[ns] = struct{}{}
+	}
+	r := &InstanceReconciler{
+		Client:                       cli,
+		Scheme:                       scheme,
+		Applier:                      delegate.NewDelegate(nil, cli, &delegate.Options{CacheDir: options.CacheDir}),
+		CacheDir:                     options.CacheDir,
+		AllowClusterScopedNamespaces: allowNS,
+	}
+	return r, instances
+}
+
+func newOfflineRESTMapper(scheme *runtime.Scheme, objects []client.Object) meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(scheme.PrioritizedVersionsAllGroups())
+	for gvk := range scheme.AllKnownTypes() {
+		if gvk.Version == runtime.APIVersionInternal || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		scope := meta.RESTScopeNamespace
+		if slices.Contains(clusterScopedKinds, gvk.GroupKind()) {
+			scope = meta.RESTScopeRoot
+		}
+		mapper.Add(gvk, scope)
+	}
+	for _, obj := range objects {
+		crd, ok := obj.(*apiextensionsv1.CustomResourceDefinition)
+		if !ok {
+			continue
+		}
+		scope := meta.RESTScopeNamespace
+		if crd.Spec.Scope == apiextensionsv1.ClusterScoped {
+			scope = meta.RESTScopeRoot
+		}
+		for _, version := range crd.Spec.Versions {
+			gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: version.Name, Kind: crd.Spec.Names.Kind}
+			mapper.AddSpecific(gvk,
+				gvk.GroupVersion().WithResource(crd.Spec.Names.Plural),
+				gvk.GroupVersion().WithResource(crd.Spec.Names.Singular), scope)
+		}
+	}
+	return mapper
+}
+
+func isNamespaced(mapper meta.RESTMapper, obj client.Object, scheme *runtime.Scheme) bool {
+	gvks, _, err := scheme.ObjectKinds(obj)
+	if err != nil || len(gvks) == 0 {
+		return false
+	}
+	mapping, err := mapper.RESTMapping(gvks[0].GroupKind(), gvks[0].Version)
+	if err != nil {
+		return false
+	}
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const testTemplateChart = `apiVersion: v2
+name: demo
+version: 0.1.0
+`
+
+const testTemplateManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  greeting: {{ .Values.greeting | quote }}
+  token: {{ .Values.token | quote }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ .Release.Name }}
+{{- if .Values.widget }}
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: {{ .Release.Name }}
+{{- end }}
+`
+
+func writeTemplateChart(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(testTemplateChart), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "templates", "manifests.yaml"), []byte(testTemplateManifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestTemplate(t *testing.T) {
+	chartDir := writeTemplateChart(t)
+	input := `apiVersion: apps.xiaoshiai.cn/v1
+kind: Instance
+metadata:
+  name: demo
+  namespace: platform
+spec:
+  kind: helm
+  url: file://` + chartDir + `
+  valuesFrom:
+  - kind: ConfigMap
+    name: demo-values
+  - kind: Secret
+    name: demo-secret
+  values:
+    widget: true
+    global:
+      commonLabels:
+        team: infra
+  extensions:
+  - name: metadata
+    kind: CommonMetadata
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: demo-values
+  namespace: platform
+data:
+  greeting: hello
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: demo-secret
+  namespace: platform
+stringData:
+  token: s3cr3t
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: platform
+  annotations:
+    apps.xiaoshiai.cn/allow-cluster-scoped: "true"
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  scope: Namespaced
+  names:
+    kind: Widget
+    plural: widgets
+    singular: widget
+  versions:
+  - name: v1
+    served: true
+    storage: true
+---
+apiVersion: example.com/v1
+kind: Unknown
+metadata:
+  name: skipped
+`
+	file := filepath.Join(t.TempDir(), "instance.yaml")
+	if err := os.WriteFile(file, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := ReadObjects([]string{file})
+	if err != nil {
+		t.Fatalf("read objects: %v", err)
+	}
+	if len(objects) != 5 {
+		t.Fatalf("expected unknown kinds to be skipped, got %d objects", len(objects))
+	}
+	if secret := objects[2].(*corev1.Secret); string(secret.Data["token"]) != "s3cr3t" || secret.StringData != nil {
+		t.Fatalf("expected stringData merged into data, got %#v", secret)
+	}
+
+	options := &TemplateOptions{Namespace: "default", CacheDir: t.TempDir()}
+	out, err := Template(context.Background(), objects, options)
+	if err != nil {
+		t.Fatalf("template: %v", err)
+	}
+	for _, expected := range []string{
+		"greeting: hello",
+		"token: s3cr3t",
+		"namespace: platform",
+		"team: infra",
+		"app.kubernetes.io/instance: demo",
+		"kind: ClusterRole",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out)
+		}
+	}
+	// the Widget kind is namespaced by the CustomResourceDefinition in the input
+	if strings.Count(string(out), "namespace: platform") != 2 {
+		t.Errorf("expected the ConfigMap and Widget in namespace platform:\n%s", out)
+	}
+}
+
+func TestTemplateRejectsClusterScoped(t *testing.T) {
+	chartDir := writeTemplateChart(t)
+	input := `apiVersion: apps.xiaoshiai.cn/v1
+kind: Instance
+metadata:
+  name: demo
+spec:
+  kind: helm
+  url: file://` + chartDir + `
+`
+	file := filepath.Join(t.TempDir(), "instance.yaml")
+	if err := os.WriteFile(file, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := ReadObjects([]string{file})
+	if err != nil {
+		t.Fatalf("read objects: %v", err)
+	}
+	options := &TemplateOptions{Namespace: "apps", CacheDir: t.TempDir()}
+	_, err = Template(context.Background(), objects, options)
+	if err == nil || !strings.Contains(err.Error(), "cluster-scoped resource ClusterRole demo is not allowed") {
+		t.Fatalf("expected cluster-scoped rejection, got %v", err)
+	}
+}
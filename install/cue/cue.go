@@ -0,0 +1,103 @@
+package cue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	cueerrors "cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/utils"
+)
+
+const (
+	defaultPackage     = "."
+	defaultValuesPath  = "values"
+	defaultObjectsPath = "objects"
+)
+
+// CueRenderFunc evaluates the CUE module at the instance location with the
+// values of the instance and renders the exported Kubernetes objects.
+func CueRenderFunc(ctx context.Context, instance install.Instance) ([]byte, error) {
+	return Evaluate(instance)
+}
+
+// Evaluate unifies the values of the instance with the package of the CUE
+// module at the instance location and returns the objects at the objects
+// path as YAML documents. Values violating the schema of the module and
+// incomplete objects are reported as an *install.SchemaError.
+func Evaluate(instance install.Instance) ([]byte, error) {
+	root, err := filepath.Abs(instance.Location)
+	if err != nil {
+		return nil, err
+	}
+	pkg, valuesPath, objectsPath := defaultPackage, defaultValuesPath, defaultObjectsPath
+	if options := instance.Cue; options != nil {
+		pkg = stringOr(options.Package, pkg)
+		valuesPath = stringOr(options.ValuesPath, valuesPath)
+		objectsPath = stringOr(options.ObjectsPath, objectsPath)
+	}
+	if !filepath.IsLocal(pkg) {
+		return nil, fmt.Errorf("package %s is outside of the source", pkg)
+	}
+	if pkg = filepath.ToSlash(filepath.Clean(pkg)); pkg != "." {
+		pkg = "./" + pkg
+	}
+
+	// the module root is pinned to the source, a cue.mod above it is ignored
+	instances := load.Instances([]string{pkg}, &load.Config{Dir: root, ModuleRoot: root})
+	if len(instances) != 1 {
+		return nil, fmt.Errorf("package %s: expected a single instance, found %d", pkg, len(instances))
+	}
+	if err := instances[0].Err; err != nil {
+		return nil, fmt.Errorf("load package %s: %s", pkg, cueerrors.Details(err, nil))
+	}
+	cctx := cuecontext.New()
+	value := cctx.BuildInstance(instances[0])
+	if err := value.Err(); err != nil {
+		return nil, fmt.Errorf("build package %s: %s", pkg, cueerrors.Details(err, nil))
+	}
+
+	if len(instance.Values) > 0 {
+		// through JSON, whole numbers decoded as float64 unify with int
+		data, err := json.Marshal(instance.Values)
+		if err != nil {
+			return nil, err
+		}
+		value = value.FillPath(cue.ParsePath(valuesPath), cctx.CompileBytes(data))
+	}
+	if err := value.Validate(); err != nil {
+		return nil, schemaError(err)
+	}
+	objects := value.LookupPath(cue.ParsePath(objectsPath))
+	if !objects.Exists() {
+		return nil, fmt.Errorf("package %s has no %s", pkg, objectsPath)
+	}
+	if err := objects.Validate(cue.Concrete(true)); err != nil {
+		return nil, schemaError(err)
+	}
+	var result any
+	if err := objects.Decode(&result); err != nil {
+		return nil, err
+	}
+	return utils.ObjectsYAML(result)
+}
+
+func schemaError(err error) *install.SchemaError {
+	serr := &install.SchemaError{}
+	for _, e := range cueerrors.Errors(err) {
+		serr.Violations = append(serr.Violations, e.Error())
+	}
+	return serr
+}
+
+func stringOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,132 @@
+package cue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/utils"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const testModule = `package app
+
+values: {
+	name:     string | *"web"
+	replicas: int & >=1 & <=5 | *1
+	image:    string
+}
+
+objects: {
+	deployment: {
+		apiVersion: "apps/v1"
+		kind:       "Deployment"
+		metadata: name: values.name
+		spec: {
+			replicas: values.replicas
+			template: spec: containers: [{name: values.name, image: values.image}]
+		}
+	}
+	service: {
+		apiVersion: "v1"
+		kind:       "Service"
+		metadata: name: values.name
+	}
+}
+`
+
+func TestCueEvaluate(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.cue": testModule})
+	out, err := Evaluate(install.Instance{
+		Location: dir,
+		Values:   map[string]any{"replicas": float64(3), "image": "nginx:1.27"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].GetKind() != "Deployment" || objects[1].GetKind() != "Service" {
+		t.Fatalf("unexpected objects:\n%s", out)
+	}
+	if objects[0].GetName() != "web" {
+		t.Errorf("deployment name = %s, want web", objects[0].GetName())
+	}
+	if replicas := objects[0].Object["spec"].(map[string]any)["replicas"]; replicas != int64(3) {
+		t.Errorf("replicas = %#v, want 3", replicas)
+	}
+}
+
+func TestCueEvaluateSchemaViolations(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.cue": testModule})
+	tests := []struct {
+		name   string
+		values map[string]any
+		want   string
+	}{
+		{name: "out of bounds", values: map[string]any{"replicas": float64(10), "image": "nginx"}, want: "replicas"},
+		{name: "wrong type", values: map[string]any{"name": float64(1), "image": "nginx"}, want: "name"},
+		{name: "missing required", values: map[string]any{"replicas": float64(2)}, want: "image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Evaluate(install.Instance{Location: dir, Values: tt.values})
+			var schemaErr *install.SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("Evaluate() error = %v, want a schema error", err)
+			}
+			if !strings.Contains(schemaErr.Error(), tt.want) {
+				t.Errorf("violations %v do not mention %s", schemaErr.Violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestCueEvaluatePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cue.mod/module.cue": "module: \"example.test/config@v0\"\nlanguage: version: \"v0.14.0\"\n",
+		"schema/schema.cue":  "package schema\n\n#Config: {namespace: string}\n",
+		"apps/apps.cue": `package apps
+
+import "example.test/config/schema"
+
+config: schema.#Config
+manifests: [{apiVersion: "v1", kind: "Namespace", metadata: name: config.namespace}]
+`,
+	})
+	out, err := Evaluate(install.Instance{
+		Location: dir,
+		Values:   map[string]any{"namespace": "team-a"},
+		Cue:      &appsv1.CueSource{Package: "apps", ValuesPath: "config", ObjectsPath: "manifests"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if !strings.Contains(string(out), "name: team-a") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if _, err := Evaluate(install.Instance{Location: dir, Cue: &appsv1.CueSource{Package: "../apps"}}); err == nil {
+		t.Error("Evaluate() accepted a package outside of the source")
+	}
+}
@@ -4,19 +4,25 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/go-logr/logr"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/cue"
 	"xiaoshiai.cn/installer/install/download"
 	"xiaoshiai.cn/installer/install/helm"
+	"xiaoshiai.cn/installer/install/jsonnet"
 	"xiaoshiai.cn/installer/install/kustomize"
+	"xiaoshiai.cn/installer/install/manifests"
 	"xiaoshiai.cn/installer/install/native"
 	"xiaoshiai.cn/installer/install/template"
 )
 
 type Options struct {
 	CacheDir string
+	// Cache manages the entries under CacheDir, a default manager is used if nil.
+	Cache *download.CacheManager
 }
 
 func NewDefaultOptions() *Options {
@@ -24,13 +30,20 @@ func NewDefaultOptions() *Options {
 }
 
 func NewDelegate(cfg *rest.Config, cli client.Client, options *Options) *BundleApplier {
+	downloader := download.NewDownloader(options.CacheDir)
+	if options.Cache != nil {
+		downloader.Cache = options.Cache
+	}
 	return &BundleApplier{
 		appliers: map[appsv1.InstanceKind]install.Installer{
 			appsv1.InstanceKindHelm:      helm.New(cfg),
-			appsv1.InstanceKindKustomize: native.New(cli, kustomize.KustomizeBuildFunc),
+			appsv1.InstanceKindKustomize: native.New(cli, kustomize.NewKustomizeBuildFunc(downloader)),
 			appsv1.InstanceKindTemplate:  native.New(cli, template.NewTemplaterFunc(cfg)),
+			appsv1.InstanceKindManifests: native.New(cli, manifests.ManifestsRenderFunc),
+			appsv1.InstanceKindJsonnet:   native.New(cli, jsonnet.JsonnetRenderFunc),
+			appsv1.InstanceKindCue:       native.New(cli, cue.CueRenderFunc),
 		},
-		downloader:     download.NewDownloader(options.CacheDir),
+		downloader:     downloader,
 		artifactLoader: download.NewArtifactLoader(cli, options.CacheDir),
 	}
 }
@@ -65,7 +78,10 @@ func (b *BundleApplier) Download(ctx context.Context, instance install.Instance)
 
 func (b *BundleApplier) resolveLocation(ctx context.Context, instance install.Instance) (string, string, func(), error) {
 	if instance.Artifact != nil {
-		return b.artifactLoader.Load(ctx, instance.Namespace, instance.Artifact)
+		if instance.Kind == "" || instance.Kind == appsv1.InstanceKindHelm {
+			return b.artifactLoader.Load(ctx, instance.Namespace, instance.Artifact)
+		}
+		return b.artifactLoader.LoadBundle(ctx, instance.Namespace, instance.Artifact)
 	}
 	path, err := b.Download(ctx, instance)
 	return path, "", func() {}, err
@@ -74,10 +90,18 @@ func (b *BundleApplier) resolveLocation(ctx context.Context, instance install.In
 func (b *BundleApplier) Apply(ctx context.Context, instance install.Instance) (*install.InstanceStatus, error) {
 	into, artifactDigest, cleanup, err := b.resolveLocation(ctx, instance)
 	if err != nil {
+		instance.Eventf(install.EventTypeWarning, "SourceFetchFailed", "Fetch %s: %v", sourceOf(instance), err)
 		return nil, fmt.Errorf("resolve source: %w", err)
 	}
 	defer cleanup()
 	instance.Location = into
+	digest := artifactDigest
+	if digest == "" {
+		if digest, err = download.Digest(into); err != nil {
+			logr.FromContextOrDiscard(ctx).Error(err, "digest source", "path", into)
+		}
+	}
+	instance.Eventf(install.EventTypeNormal, "SourceFetched", "Fetched %s with digest %s", sourceOf(instance), digest)
 	if apply, ok := b.appliers[instance.Kind]; ok {
 		status, err := apply.Apply(ctx, instance)
 		if err == nil && status != nil {
@@ -88,9 +112,31 @@ func (b *BundleApplier) Apply(ctx context.Context, instance install.Instance) (*
 	return nil, fmt.Errorf("unknown bundle kind: %s", instance.Kind)
 }
 
-func (b *BundleApplier) Remove(ctx context.Context, instance install.Instance) error {
+func (b *BundleApplier) Remove(ctx context.Context, instance install.Instance) ([]appsv1.ManagedResource, error) {
+	if instance.Kind != "" && instance.Kind != appsv1.InstanceKindHelm && instance.DeletionPolicy != appsv1.DeletionPolicyOrphan {
+		// the source renders the delete hooks, the objects are not deleted without them
+		into, _, cleanup, err := b.resolveLocation(ctx, instance)
+		if err != nil {
+			instance.Eventf(install.EventTypeWarning, "SourceFetchFailed", "Fetch %s: %v", sourceOf(instance), err)
+			return nil, fmt.Errorf("resolve source for delete hooks: %w", err)
+		}
+		defer cleanup()
+		instance.Location = into
+	}
 	if apply, ok := b.appliers[instance.Kind]; ok {
 		return apply.Remove(ctx, instance)
 	}
-	return fmt.Errorf("unknown bundle kind: %s", instance.Kind)
+	return nil, fmt.Errorf("unknown bundle kind: %s", instance.Kind)
+}
+
+// sourceOf describes where the source of instance is fetched from.
+func sourceOf(instance install.Instance) string {
+	switch {
+	case instance.Artifact != nil:
+		return fmt.Sprintf("artifact Secret %s", instance.Artifact.SecretRef.Name)
+	case (instance.Kind == "" || instance.Kind == appsv1.InstanceKindHelm) && instance.Chart != "":
+		return fmt.Sprintf("chart %s %s from %s", instance.Chart, instance.Version, instance.Repository)
+	default:
+		return instance.Repository
+	}
 }
@@ -1,12 +1,20 @@
 package download
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"helm.sh/helm/v3/pkg/chart/loader"
 	corev1 "k8s.io/api/core/v1"
@@ -18,16 +26,28 @@ import (
 
 const (
 	ChartSecretType         corev1.SecretType = "apps.xiaoshiai.cn/helm-chart.v1"
+	BundleSecretType        corev1.SecretType = "apps.xiaoshiai.cn/bundle.v1"
 	ContentDigestAnnotation                   = "apps.xiaoshiai.cn/content-digest"
 	ChartSecretKey                            = "chart.tgz"
+	BundleSecretKey                           = "bundle.tgz"
 
-	ReasonArtifactSecretNotFound = "ArtifactSecretNotFound"
-	ReasonArtifactSecretInvalid  = "ArtifactSecretInvalid"
-	ReasonArtifactDigestMismatch = "ArtifactDigestMismatch"
-	ReasonArtifactLoadFailed     = "ArtifactLoadFailed"
+	// ChunkIndexAnnotation is the zero-based position of a Secret within an
+	// artifact spanning several Secrets.
+	ChunkIndexAnnotation = "apps.xiaoshiai.cn/chunk-index"
+	// ChunkCountAnnotation is the total number of chunks of an artifact, the
+	// number of Secrets or, for a single Secret, the number of numbered keys.
+	ChunkCountAnnotation = "apps.xiaoshiai.cn/chunk-count"
+
+	ReasonArtifactSecretNotFound  = "ArtifactSecretNotFound"
+	ReasonArtifactSecretInvalid   = "ArtifactSecretInvalid"
+	ReasonArtifactDigestMismatch  = "ArtifactDigestMismatch"
+	ReasonArtifactLoadFailed      = "ArtifactLoadFailed"
+	ReasonArtifactChunkMissing    = "ArtifactChunkMissing"
+	ReasonArtifactChunkOutOfOrder = "ArtifactChunkOutOfOrder"
 )
 
-// ArtifactLoader verifies a chart Secret and exposes it as a temporary archive.
+// ArtifactLoader verifies a chart or bundle Secret and exposes it as a
+// temporary archive or directory.
 type ArtifactLoader struct {
 	Client   client.Client
 	CacheDir string
@@ -40,71 +60,323 @@ func NewArtifactLoader(cli client.Client, cacheDir string) *ArtifactLoader {
 // Load reads and verifies an artifact from the Instance namespace. The caller
 // must invoke the returned cleanup function after the chart consumer finishes.
 func (l *ArtifactLoader) Load(ctx context.Context, namespace string, artifact *appsv1.Artifact) (string, string, func(), error) {
-	if err := validateArtifact(artifact); err != nil {
+	archive, actualDigest, err := l.read(ctx, namespace, artifact, ChartSecretType)
+	if err != nil {
+		return "", "", func() {}, err
+	}
+	if _, err := loader.LoadArchive(bytes.NewReader(archive)); err != nil {
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "load chart from Secret %s/%s: %v", namespace, artifact.SecretRef.Name, err)
+	}
+
+	dir, err := l.tempDir()
+	if err != nil {
+		return "", "", func() {}, err
+	}
+	f, err := os.CreateTemp(dir, "chart-*.tgz")
+	if err != nil {
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "create artifact temporary file: %v", err)
+	}
+	path := f.Name()
+	cleanup := func() { _ = os.Remove(path) }
+	if err := f.Chmod(0o600); err != nil {
+		_ = f.Close()
+		cleanup()
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "secure artifact temporary file: %v", err)
+	}
+	if _, err := f.Write(archive); err != nil {
+		_ = f.Close()
+		cleanup()
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "write artifact temporary file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "close artifact temporary file: %v", err)
+	}
+	return path, actualDigest, cleanup, nil
+}
+
+// LoadBundle reads and verifies a tar.gz or zip bundle used by kustomize and
+// template instances and extracts it into a temporary directory only the
+// installer can read. Chart Secrets are accepted as bundles as well. When the
+// bundle holds a single top level directory, that directory is returned. The
+// caller must invoke the returned cleanup function once the bundle is applied.
+func (l *ArtifactLoader) LoadBundle(ctx context.Context, namespace string, artifact *appsv1.Artifact) (string, string, func(), error) {
+	archive, actualDigest, err := l.read(ctx, namespace, artifact, BundleSecretType, ChartSecretType)
+	if err != nil {
+		return "", "", func() {}, err
+	}
+	dir, err := l.tempDir()
+	if err != nil {
 		return "", "", func() {}, err
 	}
+	into, err := os.MkdirTemp(dir, "bundle-*")
+	if err != nil {
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "create artifact temporary directory: %v", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(into) }
+	if err := os.Chmod(into, 0o700); err != nil {
+		cleanup()
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "secure artifact temporary directory: %v", err)
+	}
+	if err := extractBundle(archive, into); err != nil {
+		cleanup()
+		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "extract bundle from Secret %s/%s: %v", namespace, artifact.SecretRef.Name, err)
+	}
+	return bundleRoot(into), actualDigest, cleanup, nil
+}
 
+// read fetches the artifact Secrets, checks their type and immutability,
+// reassembles chunked archives and verifies the result against the requested
+// and annotated digests.
+func (l *ArtifactLoader) read(ctx context.Context, namespace string, artifact *appsv1.Artifact, types ...corev1.SecretType) ([]byte, string, error) {
+	if err := validateArtifact(artifact); err != nil {
+		return nil, "", err
+	}
+
+	refs := append([]appsv1.ArtifactSecretRef{artifact.SecretRef}, artifact.Chunks...)
+	secrets := make([]*corev1.Secret, 0, len(refs))
+	var archive []byte
+	for i, ref := range refs {
+		secret, err := l.getSecret(ctx, namespace, ref.Name, types)
+		if err != nil {
+			return nil, "", err
+		}
+		// a single Secret annotated as chunk must not lose its siblings
+		_, isChunk := secret.Annotations[ChunkIndexAnnotation]
+		if isChunk || len(refs) > 1 {
+			if err := checkChunkPosition(secret, i, len(refs)); err != nil {
+				return nil, "", err
+			}
+		}
+		data, err := secretData(secret, ref.Key, !isChunk && len(refs) == 1)
+		if err != nil {
+			return nil, "", err
+		}
+		archive = append(archive, data...)
+		secrets = append(secrets, secret)
+	}
+
+	actualDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(archive))
+	head := secrets[0]
+	if artifact.Digest != "" && actualDigest != artifact.Digest {
+		return nil, "", artifactError(ReasonArtifactDigestMismatch, "artifact Secret %s/%s digest mismatch: expected %s, actual %s", namespace, head.Name, artifact.Digest, actualDigest)
+	}
+	for _, secret := range secrets {
+		annotationDigest := secret.Annotations[ContentDigestAnnotation]
+		if annotationDigest != "" && actualDigest != annotationDigest {
+			return nil, "", artifactError(ReasonArtifactDigestMismatch, "artifact Secret %s/%s annotation digest mismatch: expected %s, actual %s", namespace, secret.Name, annotationDigest, actualDigest)
+		}
+	}
+	return archive, actualDigest, nil
+}
+
+func (l *ArtifactLoader) getSecret(ctx context.Context, namespace, name string, types []corev1.SecretType) (*corev1.Secret, error) {
 	secret := &corev1.Secret{}
-	key := client.ObjectKey{Namespace: namespace, Name: artifact.SecretRef.Name}
-	if err := l.Client.Get(ctx, key, secret); err != nil {
+	if err := l.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
 		reason := ReasonArtifactSecretInvalid
 		if apierrors.IsNotFound(err) {
 			reason = ReasonArtifactSecretNotFound
 		}
-		return "", "", func() {}, artifactError(reason, "get chart Secret %s/%s: %v", namespace, artifact.SecretRef.Name, err)
+		return nil, artifactError(reason, "get artifact Secret %s/%s: %v", namespace, name, err)
 	}
-	if secret.Type != ChartSecretType {
-		return "", "", func() {}, artifactError(ReasonArtifactSecretInvalid, "chart Secret %s/%s has type %q, expected %q", namespace, secret.Name, secret.Type, ChartSecretType)
+	if !slices.Contains(types, secret.Type) {
+		return nil, artifactError(ReasonArtifactSecretInvalid, "artifact Secret %s/%s has type %q, expected %q", namespace, secret.Name, secret.Type, types[0])
 	}
 	if secret.Immutable == nil || !*secret.Immutable {
-		return "", "", func() {}, artifactError(ReasonArtifactSecretInvalid, "chart Secret %s/%s must be immutable", namespace, secret.Name)
+		return nil, artifactError(ReasonArtifactSecretInvalid, "artifact Secret %s/%s must be immutable", namespace, secret.Name)
 	}
+	return secret, nil
+}
 
-	archive, ok := secret.Data[artifact.SecretRef.Key]
-	if !ok || len(archive) == 0 {
-		return "", "", func() {}, artifactError(ReasonArtifactSecretInvalid, "chart Secret %s/%s does not contain non-empty data key %q", namespace, secret.Name, artifact.SecretRef.Key)
+// checkChunkPosition verifies the chunk annotations of the Secret listed at
+// index of an artifact spanning count Secrets.
+func checkChunkPosition(secret *corev1.Secret, index, count int) error {
+	value, ok := secret.Annotations[ChunkIndexAnnotation]
+	if !ok {
+		return artifactError(ReasonArtifactChunkOutOfOrder, "artifact Secret %s/%s is listed as chunk %d but has no %s annotation", secret.Namespace, secret.Name, index, ChunkIndexAnnotation)
 	}
-	annotationDigest := secret.Annotations[ContentDigestAnnotation]
-	actualDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(archive))
-	if artifact.Digest != "" && actualDigest != artifact.Digest {
-		return "", "", func() {}, artifactError(ReasonArtifactDigestMismatch, "chart Secret %s/%s digest mismatch: expected %s, actual %s", namespace, secret.Name, artifact.Digest, actualDigest)
+	if got, err := strconv.Atoi(value); err != nil || got != index {
+		return artifactError(ReasonArtifactChunkOutOfOrder, "artifact Secret %s/%s is listed as chunk %d but annotated as chunk %q", secret.Namespace, secret.Name, index, value)
 	}
-	if annotationDigest != "" && actualDigest != annotationDigest {
-		return "", "", func() {}, artifactError(ReasonArtifactDigestMismatch, "chart Secret %s/%s annotation digest mismatch: expected %s, actual %s", namespace, secret.Name, annotationDigest, actualDigest)
+	if value, ok := secret.Annotations[ChunkCountAnnotation]; ok {
+		if got, err := strconv.Atoi(value); err != nil || got != count {
+			return artifactError(ReasonArtifactChunkMissing, "artifact Secret %s/%s belongs to an artifact of %q chunks, but %d are listed", secret.Namespace, secret.Name, value, count)
+		}
 	}
-	if _, err := loader.LoadArchive(bytes.NewReader(archive)); err != nil {
-		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "load chart from Secret %s/%s: %v", namespace, secret.Name, err)
+	return nil
+}
+
+// secretData returns the data under key, or the concatenation of the numbered
+// keys "<key>.0", "<key>.1", ... when key itself is absent. When countable,
+// the number of numbered keys is checked against the chunk count annotation.
+func secretData(secret *corev1.Secret, key string, countable bool) ([]byte, error) {
+	if data, ok := secret.Data[key]; ok {
+		if len(data) == 0 {
+			return nil, artifactError(ReasonArtifactSecretInvalid, "artifact Secret %s/%s does not contain non-empty data key %q", secret.Namespace, secret.Name, key)
+		}
+		return data, nil
 	}
+	var indexes []int
+	for name := range secret.Data {
+		suffix, ok := strings.CutPrefix(name, key+".")
+		if !ok {
+			continue
+		}
+		if index, err := strconv.Atoi(suffix); err == nil && index >= 0 {
+			indexes = append(indexes, index)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, artifactError(ReasonArtifactSecretInvalid, "artifact Secret %s/%s does not contain non-empty data key %q", secret.Namespace, secret.Name, key)
+	}
+	slices.Sort(indexes)
+	var data []byte
+	for want, index := range indexes {
+		if index != want {
+			return nil, artifactError(ReasonArtifactChunkMissing, "artifact Secret %s/%s is missing chunk key %s.%d", secret.Namespace, secret.Name, key, want)
+		}
+		chunk := secret.Data[fmt.Sprintf("%s.%d", key, index)]
+		if len(chunk) == 0 {
+			// the key is named with leading zeros or has no data
+			return nil, artifactError(ReasonArtifactChunkMissing, "artifact Secret %s/%s has an empty or misnamed chunk key %s.%d", secret.Namespace, secret.Name, key, index)
+		}
+		data = append(data, chunk...)
+	}
+	if value, ok := secret.Annotations[ChunkCountAnnotation]; ok && countable {
+		if count, err := strconv.Atoi(value); err != nil || count != len(indexes) {
+			return nil, artifactError(ReasonArtifactChunkMissing, "artifact Secret %s/%s is annotated with %q chunks but contains %d chunk keys", secret.Namespace, secret.Name, value, len(indexes))
+		}
+	}
+	return data, nil
+}
 
+func (l *ArtifactLoader) tempDir() (string, error) {
 	dir := l.CacheDir
 	if dir == "" {
 		dir = os.TempDir()
 	}
 	dir = filepath.Join(dir, "artifacts")
 	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "create artifact temporary directory: %v", err)
+		return "", artifactError(ReasonArtifactLoadFailed, "create artifact temporary directory: %v", err)
 	}
-	f, err := os.CreateTemp(dir, "chart-*.tgz")
+	return dir, nil
+}
+
+// extractBundle extracts a tar.gz or zip archive, detected by its magic
+// bytes, into dir. Only regular files and directories are extracted and no
+// entry may escape dir.
+func extractBundle(archive []byte, dir string) error {
+	switch {
+	case bytes.HasPrefix(archive, []byte{0x1f, 0x8b}):
+		return extractTarGz(archive, dir)
+	case bytes.HasPrefix(archive, []byte("PK\x03\x04")):
+		return extractZip(archive, dir)
+	default:
+		return errors.New("unsupported archive format, expected tar.gz or zip")
+	}
+}
+
+func extractTarGz(archive []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
 	if err != nil {
-		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "create artifact temporary file: %v", err)
+		return err
 	}
-	path := f.Name()
-	cleanup := func() { _ = os.Remove(path) }
-	if err := f.Chmod(0o600); err != nil {
-		_ = f.Close()
-		cleanup()
-		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "secure artifact temporary file: %v", err)
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirInside(dir, hdr.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeInside(dir, hdr.Name, tr); err != nil {
+				return err
+			}
+		default:
+			// links and devices are never needed by manifests
+		}
 	}
-	if _, err := f.Write(archive); err != nil {
+}
+
+func extractZip(archive []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			if err := mkdirInside(dir, file.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeInside(dir, file.Name, src)
+		_ = src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insidePath(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q escapes the bundle directory", name)
+	}
+	return path, nil
+}
+
+func mkdirInside(dir, name string) error {
+	path, err := insidePath(dir, name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(path, 0o700)
+}
+
+func writeInside(dir, name string, r io.Reader) error {
+	path, err := insidePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
 		_ = f.Close()
-		cleanup()
-		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "write artifact temporary file: %v", err)
+		return err
 	}
-	if err := f.Close(); err != nil {
-		cleanup()
-		return "", "", func() {}, artifactError(ReasonArtifactLoadFailed, "close artifact temporary file: %v", err)
+	return f.Close()
+}
+
+// bundleRoot descends into the only top level directory of an extracted
+// bundle, as archives are commonly created from their parent directory.
+func bundleRoot(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
 	}
-	return path, actualDigest, cleanup, nil
+	return filepath.Join(dir, entries[0].Name())
 }
 
 func validateArtifact(artifact *appsv1.Artifact) error {
@@ -117,6 +389,11 @@ func validateArtifact(artifact *appsv1.Artifact) error {
 	if artifact.SecretRef.Key == "" {
 		return artifactError(ReasonArtifactSecretInvalid, "artifact Secret key is required")
 	}
+	for i, chunk := range artifact.Chunks {
+		if chunk.Name == "" || chunk.Key == "" {
+			return artifactError(ReasonArtifactSecretInvalid, "artifact chunk %d requires a Secret name and key", i+1)
+		}
+	}
 	return nil
 }
 
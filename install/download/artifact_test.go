@@ -2,12 +2,14 @@ package download
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -227,3 +229,219 @@ func testChartArchive(t *testing.T, version, values string) []byte {
 	}
 	return out.Bytes()
 }
+
+func TestArtifactLoaderLoadBundle(t *testing.T) {
+	files := map[string]string{
+		"app/kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"app/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: demo\n",
+	}
+	for name, archive := range map[string][]byte{
+		"tar.gz": testTarGz(t, files),
+		"zip":    testZip(t, files),
+	} {
+		t.Run(name, func(t *testing.T) {
+			immutable := true
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "bundle", Namespace: "default"},
+				Immutable:  &immutable,
+				Type:       BundleSecretType,
+				Data:       map[string][]byte{BundleSecretKey: archive},
+			}
+			artifact := &appsv1.Artifact{
+				SecretRef: appsv1.ArtifactSecretRef{Name: secret.Name, Key: BundleSecretKey},
+				Digest:    digestOf(archive),
+			}
+			dir, actualDigest, cleanup, err := newTestArtifactLoader(t, secret).LoadBundle(context.Background(), "default", artifact)
+			if err != nil {
+				t.Fatalf("LoadBundle() error = %v", err)
+			}
+			if actualDigest != digestOf(archive) {
+				t.Fatalf("LoadBundle() digest = %s, want %s", actualDigest, digestOf(archive))
+			}
+			if filepath.Base(dir) != "app" {
+				t.Fatalf("LoadBundle() = %s, want the single top level directory", dir)
+			}
+			info, err := os.Stat(filepath.Join(dir, "kustomization.yaml"))
+			if err != nil {
+				t.Fatalf("stat extracted file: %v", err)
+			}
+			if got := info.Mode().Perm(); got != 0o600 {
+				t.Fatalf("extracted file mode = %o, want 600", got)
+			}
+			cleanup()
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Fatalf("bundle still exists after cleanup: %v", err)
+			}
+		})
+	}
+}
+
+func TestArtifactLoaderLoadBundleRejectsInvalidArchives(t *testing.T) {
+	for name, archive := range map[string][]byte{
+		"not an archive": []byte("plain text"),
+		"path traversal": testTarGz(t, map[string]string{"../escape.yaml": "kind: ConfigMap\n"}),
+		"zip traversal":  testZip(t, map[string]string{"a/../../escape.yaml": "kind: ConfigMap\n"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			immutable := true
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "bundle", Namespace: "default"},
+				Immutable:  &immutable,
+				Type:       BundleSecretType,
+				Data:       map[string][]byte{BundleSecretKey: archive},
+			}
+			artifact := &appsv1.Artifact{SecretRef: appsv1.ArtifactSecretRef{Name: secret.Name, Key: BundleSecretKey}}
+			_, _, _, err := newTestArtifactLoader(t, secret).LoadBundle(context.Background(), "default", artifact)
+			assertArtifactReason(t, err, ReasonArtifactLoadFailed)
+		})
+	}
+}
+
+func testTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return out.Bytes()
+}
+
+func testZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("write zip content: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestArtifactLoaderLoadChunks(t *testing.T) {
+	archive := testChartArchive(t, "0.1.0", "value: one")
+	digest := digestOf(archive)
+	half := len(archive) / 2
+	immutable := true
+	chunkSecret := func(name string, index int, data []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Annotations: map[string]string{
+					ChunkIndexAnnotation:    fmt.Sprint(index),
+					ChunkCountAnnotation:    "2",
+					ContentDigestAnnotation: digest,
+				},
+			},
+			Immutable: &immutable,
+			Type:      ChartSecretType,
+			Data:      map[string][]byte{ChartSecretKey: data},
+		}
+	}
+
+	t.Run("Secrets", func(t *testing.T) {
+		artifact := &appsv1.Artifact{
+			SecretRef: appsv1.ArtifactSecretRef{Name: "demo-0", Key: ChartSecretKey},
+			Chunks:    []appsv1.ArtifactSecretRef{{Name: "demo-1", Key: ChartSecretKey}},
+			Digest:    digest,
+		}
+		loader := newTestArtifactLoader(t, chunkSecret("demo-0", 0, archive[:half]), chunkSecret("demo-1", 1, archive[half:]))
+		_, actualDigest, cleanup, err := loader.Load(context.Background(), "default", artifact)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		defer cleanup()
+		if actualDigest != digest {
+			t.Fatalf("Load() digest = %s, want %s", actualDigest, digest)
+		}
+	})
+
+	t.Run("numbered keys", func(t *testing.T) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Annotations: map[string]string{ChunkCountAnnotation: "2"}},
+			Immutable:  &immutable,
+			Type:       ChartSecretType,
+			Data: map[string][]byte{
+				ChartSecretKey + ".0": archive[:half],
+				ChartSecretKey + ".1": archive[half:],
+			},
+		}
+		artifact := &appsv1.Artifact{SecretRef: appsv1.ArtifactSecretRef{Name: "demo", Key: ChartSecretKey}, Digest: digest}
+		_, actualDigest, cleanup, err := newTestArtifactLoader(t, secret).Load(context.Background(), "default", artifact)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		defer cleanup()
+		if actualDigest != digest {
+			t.Fatalf("Load() digest = %s, want %s", actualDigest, digest)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		objects    []runtime.Object
+		chunks     []appsv1.ArtifactSecretRef
+		wantReason metav1.StatusReason
+	}{
+		{
+			name:       "missing chunk Secret",
+			objects:    []runtime.Object{chunkSecret("demo-0", 0, archive[:half])},
+			chunks:     []appsv1.ArtifactSecretRef{{Name: "demo-1", Key: ChartSecretKey}},
+			wantReason: ReasonArtifactSecretNotFound,
+		},
+		{
+			name:       "unlisted chunk",
+			objects:    []runtime.Object{chunkSecret("demo-0", 0, archive[:half]), chunkSecret("demo-1", 1, archive[half:])},
+			chunks:     []appsv1.ArtifactSecretRef{},
+			wantReason: ReasonArtifactChunkMissing,
+		},
+		{
+			name:       "out of order",
+			objects:    []runtime.Object{chunkSecret("demo-0", 1, archive[half:]), chunkSecret("demo-1", 0, archive[:half])},
+			chunks:     []appsv1.ArtifactSecretRef{{Name: "demo-1", Key: ChartSecretKey}},
+			wantReason: ReasonArtifactChunkOutOfOrder,
+		},
+		{
+			name: "gap in numbered keys",
+			objects: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo-0", Namespace: "default"},
+				Immutable:  &immutable,
+				Type:       ChartSecretType,
+				Data:       map[string][]byte{ChartSecretKey + ".0": archive[:half], ChartSecretKey + ".2": archive[half:]},
+			}},
+			wantReason: ReasonArtifactChunkMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact := &appsv1.Artifact{
+				SecretRef: appsv1.ArtifactSecretRef{Name: "demo-0", Key: ChartSecretKey},
+				Chunks:    tt.chunks,
+				Digest:    digest,
+			}
+			_, _, _, err := newTestArtifactLoader(t, tt.objects...).Load(context.Background(), "default", artifact)
+			assertArtifactReason(t, err, tt.wantReason)
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"xiaoshiai.cn/installer/install"
+)
+
+// CompleteMarkerSuffix is appended to a cache entry path to name the marker
+// file written once the entry has been completely downloaded. Entries without
+// a marker are treated as partial and never served from the cache.
+const CompleteMarkerSuffix = ".complete"
+
+// PartialSuffix marks temporary siblings of cache entries a download is
+// extracted into before it is promoted to the entry path.
+const PartialSuffix = ".partial-"
+
+// stalePartialAge is the age after which a partial download left behind by a
+// crashed process is removed.
+const stalePartialAge = time.Hour
+
+const (
+	DefaultCacheMaxSize    int64 = 10 << 30 // 10Gi
+	DefaultCacheMaxAge           = 30 * 24 * time.Hour
+	DefaultCacheGCInterval       = 10 * time.Minute
+)
+
+// CacheManager keeps the download cache within a size and age budget.
+// The modification time of an entry's marker records its last use, so the
+// least recently used entries are evicted first. Entries referenced by an
+// Instance are never evicted.
+type CacheManager struct {
+	Dir string
+	// MaxSize is the total size budget in bytes, zero means unlimited.
+	MaxSize int64
+	// MaxAge evicts entries not used for longer than MaxAge, zero means never.
+	MaxAge time.Duration
+	// Interval is the period between two garbage collections.
+	Interval time.Duration
+	// InUse returns the cache keys (see CacheKey) referenced by existing Instances.
+	InUse func(ctx context.Context) (map[string]struct{}, error)
+
+	// mu guards entries against eviction while they are looked up or promoted.
+	mu        sync.RWMutex
+	downloads coalescer
+}
+
+func NewCacheManager(dir string) *CacheManager {
+	return &CacheManager{
+		Dir:      dir,
+		MaxSize:  DefaultCacheMaxSize,
+		MaxAge:   DefaultCacheMaxAge,
+		Interval: DefaultCacheGCInterval,
+	}
+}
+
+// CacheKey returns the cache path of the source of instance without the
+// ".tgz" suffix helm charts are stored with.
+func CacheKey(cacheDir string, instance install.Instance) string {
+	basename := instance.Chart
+	if basename == "" {
+		basename = instance.Name
+	}
+	if instance.Version != "" {
+		basename = basename + "-" + instance.Version
+	}
+	return filepath.Join(PerRepoCacheDir(instance.Repository, cacheDir), basename)
+}
+
+// Fetch returns the completed cache entry for key. When cached is false or
+// the entry is missing, download is called to produce it; concurrent callers
+// for the same key share a single download. A caller returns early when its
+// ctx is done, the download itself is only cancelled once every caller is gone.
+func (c *CacheManager) Fetch(ctx context.Context, key string, cached bool, download func(ctx context.Context) (string, error)) (string, error) {
+	path, shared, err := c.downloads.Do(ctx, key, func(ctx context.Context) (string, error) {
+		if cached {
+			if path := c.Lookup(ctx, key); path != "" {
+				return path, nil
+			}
+		}
+		path, err := download(ctx)
+		if err != nil {
+			return "", err
+		}
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		if err := c.MarkComplete(path); err != nil {
+			return "", fmt.Errorf("mark cache entry %s complete: %w", path, err)
+		}
+		return path, nil
+	})
+	if shared {
+		downloadsCoalesced.Inc()
+		logr.FromContextOrDiscard(ctx).Info("shared a concurrent download", "path", path)
+	}
+	return path, err
+}
+
+// Lookup returns the completed cache entry for key, either the extracted
+// directory or the chart archive, and records the access for LRU eviction.
+func (c *CacheManager) Lookup(ctx context.Context, key string) string {
+	log := logr.FromContextOrDiscard(ctx)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, candidate := range []string{key + ".tgz", key} {
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+		if !c.IsComplete(candidate) {
+			log.Info("ignoring incomplete cache entry", "path", candidate)
+			continue
+		}
+		now := time.Now()
+		_ = os.Chtimes(candidate+CompleteMarkerSuffix, now, now)
+		log.Info("found in cache", "path", candidate)
+		cacheHits.Inc()
+		return candidate
+	}
+	cacheMisses.Inc()
+	return ""
+}
+
+// IsComplete reports whether path has been marked as completely downloaded.
+func (c *CacheManager) IsComplete(path string) bool {
+	_, err := os.Stat(path + CompleteMarkerSuffix)
+	return err == nil
+}
+
+// MarkComplete records path as a complete cache entry. It must only be called
+// after everything has been written to path.
+func (c *CacheManager) MarkComplete(path string) error {
+	return os.WriteFile(path+CompleteMarkerSuffix, []byte(time.Now().UTC().Format(time.RFC3339)), defaultFileMode)
+}
+
+// Invalidate removes the cache entry at path together with its marker.
+func (c *CacheManager) Invalidate(path string) error {
+	if err := os.Remove(path + CompleteMarkerSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+type cacheEntry struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+	// complete is false for the files without a marker, such as entries left
+	// by a crashed or an older installer and the indexes of repositories.
+	complete bool
+}
+
+// GC evicts entries that exceed the age budget and then the least recently
+// used entries until the cache fits into the size budget. Files without a
+// marker count towards the budget and are evicted too, once older than
+// stalePartialAge so that a file still being written is kept.
+func (c *CacheManager) GC(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inuse := map[string]struct{}{}
+	if c.InUse != nil {
+		keys, err := c.InUse(ctx)
+		if err != nil {
+			return err
+		}
+		inuse = keys
+	}
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	// least recently used first
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		key := strings.TrimSuffix(entry.path, ".tgz")
+		if _, ok := inuse[key]; ok || c.downloads.InFlight(key) {
+			continue
+		}
+		if !entry.complete && now.Sub(entry.lastUsed) < stalePartialAge {
+			continue
+		}
+		expired := c.MaxAge > 0 && now.Sub(entry.lastUsed) > c.MaxAge
+		oversize := c.MaxSize > 0 && total > c.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+		log.Info("evicting cache entry", "path", entry.path, "size", entry.size, "lastUsed", entry.lastUsed, "expired", expired)
+		if err := c.Invalidate(entry.path); err != nil {
+			log.Error(err, "evict cache entry", "path", entry.path)
+			continue
+		}
+		total -= entry.size
+		cacheEvictions.Inc()
+	}
+	cacheSize.Set(float64(total))
+	return nil
+}
+
+// entries lists the entries under the cache directory. A completed entry is
+// the path of a marker. Of the files without a marker, the directories which
+// directly hold files and the files next to completed entries are entries.
+func (c *CacheManager) entries() ([]cacheEntry, error) {
+	// the directories holding completed entries are not entries themselves
+	containers := map[string]bool{c.Dir: true}
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, CompleteMarkerSuffix) {
+			for dir := filepath.Dir(path); !containers[dir]; dir = filepath.Dir(dir) {
+				containers[dir] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []cacheEntry
+	err = filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path == c.Dir {
+			return nil
+		}
+		if strings.Contains(d.Name(), PartialSuffix) {
+			if fi, err := d.Info(); err == nil && time.Since(fi.ModTime()) > stalePartialAge {
+				_ = os.RemoveAll(path)
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, CompleteMarkerSuffix) {
+			if d.IsDir() {
+				return nil
+			}
+			marker, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			entrypath := strings.TrimSuffix(path, CompleteMarkerSuffix)
+			size, _, err := diskUsage(entrypath)
+			if err != nil {
+				// the entry has gone, drop the dangling marker
+				if os.IsNotExist(err) {
+					_ = os.Remove(path)
+				}
+				return nil
+			}
+			entries = append(entries, cacheEntry{path: entrypath, size: size, lastUsed: marker.ModTime(), complete: true})
+			return nil
+		}
+		if c.IsComplete(path) {
+			// listed with its marker
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && (containers[path] || !holdsFiles(path)) {
+			return nil
+		}
+		size, modified, err := diskUsage(path)
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, cacheEntry{path: path, size: size, lastUsed: modified})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// holdsFiles reports whether the directory dir directly holds a file.
+func holdsFiles(dir string) bool {
+	children, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, child := range children {
+		if !child.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// diskUsage returns the size of the files at path and the time the latest
+// of them was modified.
+func diskUsage(path string) (int64, time.Time, error) {
+	var size int64
+	var modified time.Time
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(modified) {
+			modified = fi.ModTime()
+		}
+		size += fi.Size()
+		return nil
+	})
+	return size, modified, err
+}
+
+// NeedLeaderElection returns false as every replica owns its local cache.
+func (c *CacheManager) NeedLeaderElection() bool {
+	return false
+}
+
+// Start runs the garbage collection periodically until ctx is done.
+// It implements controller-runtime's manager.Runnable.
+func (c *CacheManager) Start(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("cache")
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultCacheGCInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.GC(ctx); err != nil {
+			log.Error(err, "cache garbage collection")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCacheEntry(t *testing.T, path string, size int, lastUsed time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(path, defaultDirMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "Chart.yaml"), make([]byte, size), defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	c := &CacheManager{}
+	if err := c.MarkComplete(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path+CompleteMarkerSuffix, lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCacheManagerLookupIgnoresIncompleteEntries(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCacheManager(dir)
+	key := filepath.Join(dir, "example.com", "demo-0.1.0")
+
+	if err := os.MkdirAll(key, defaultDirMode); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Lookup(context.Background(), key); got != "" {
+		t.Fatalf("Lookup() = %q for an entry without marker, want miss", got)
+	}
+	if err := c.MarkComplete(key); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Lookup(context.Background(), key); got != key {
+		t.Fatalf("Lookup() = %q, want %q", got, key)
+	}
+
+	// a completed chart archive takes precedence over the directory
+	if err := os.WriteFile(key+".tgz", []byte("chart"), defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MarkComplete(key + ".tgz"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Lookup(context.Background(), key); got != key+".tgz" {
+		t.Fatalf("Lookup() = %q, want %q", got, key+".tgz")
+	}
+}
+
+func TestCacheManagerGC(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	expired := filepath.Join(dir, "example.com", "expired-0.1.0")
+	oldest := filepath.Join(dir, "example.com", "oldest-0.1.0")
+	inuse := filepath.Join(dir, "example.com", "inuse-0.1.0")
+	newest := filepath.Join(dir, "example.com", "newest-0.1.0")
+	writeCacheEntry(t, expired, 100, now.Add(-48*time.Hour))
+	writeCacheEntry(t, inuse, 100, now.Add(-47*time.Hour))
+	writeCacheEntry(t, oldest, 100, now.Add(-2*time.Hour))
+	writeCacheEntry(t, newest, 100, now.Add(-time.Hour))
+
+	c := &CacheManager{
+		Dir:     dir,
+		MaxSize: 200,
+		MaxAge:  24 * time.Hour,
+		InUse: func(context.Context) (map[string]struct{}, error) {
+			return map[string]struct{}{inuse: {}}, nil
+		},
+	}
+	if err := c.GC(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		expired: false, // older than MaxAge
+		inuse:   true,  // referenced by an Instance
+		oldest:  false, // least recently used entry over the size budget
+		newest:  true,
+	} {
+		if got := exists(path); got != want {
+			t.Errorf("entry %s exists = %v, want %v", filepath.Base(path), got, want)
+		}
+		if got := exists(path + CompleteMarkerSuffix); got != want {
+			t.Errorf("marker of %s exists = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
+
+func TestCacheManagerGCUnmarkedEntries(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(path string, size int, modified time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), defaultDirMode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), defaultFileMode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	marked := filepath.Join(dir, "example.com", "marked-0.1.0")
+	writeCacheEntry(t, marked, 100, now.Add(-time.Hour))
+	// an entry of an installer without markers, next to a completed one
+	unmarked := filepath.Join(dir, "example.com", "unmarked-0.1.0")
+	write(filepath.Join(unmarked, "Chart.yaml"), 100, now.Add(-3*time.Hour))
+	archive := filepath.Join(dir, "example.com", "unmarked-0.2.0.tgz")
+	write(archive, 100, now.Add(-2*time.Hour))
+	// a half-written entry may still be in progress
+	writing := filepath.Join(dir, "other.com", "writing-0.1.0")
+	write(filepath.Join(writing, "Chart.yaml"), 100, now)
+	// the index of a Repository
+	index := filepath.Join(dir, "repositories", "default", "charts")
+	write(filepath.Join(index, "index.yaml"), 100, now.Add(-2*time.Hour))
+	orphanIndex := filepath.Join(dir, "repositories", "default", "deleted")
+	write(filepath.Join(orphanIndex, "index.yaml"), 100, now.Add(-4*time.Hour))
+
+	c := &CacheManager{
+		Dir:     dir,
+		MaxSize: 300,
+		InUse: func(context.Context) (map[string]struct{}, error) {
+			return map[string]struct{}{index: {}}, nil
+		},
+	}
+	entries, err := c.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	if len(entries) != 6 || total != 600 {
+		t.Fatalf("entries() = %d entries of %d bytes, want 6 of 600", len(entries), total)
+	}
+	if err := c.GC(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		orphanIndex: false, // least recently used
+		unmarked:    false,
+		archive:     false,
+		index:       true, // referenced by a Repository
+		marked:      true,
+		writing:     true, // younger than stalePartialAge
+	} {
+		if got := exists(path); got != want {
+			t.Errorf("entry %s exists = %v, want %v", filepath.Base(path), got, want)
+		}
+	}
+}
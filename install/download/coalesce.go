@@ -0,0 +1,89 @@
+package download
+
+import (
+	"context"
+	"sync"
+)
+
+// coalescer deduplicates concurrent calls for the same key, singleflight
+// style. Unlike golang.org/x/sync/singleflight every caller may give up when
+// its own context is done, and the shared call runs with a context that is
+// only cancelled once all of its callers have given up.
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	waiters int
+	cancel  context.CancelFunc
+	// cancelled is set once every caller has given up, the call is kept until
+	// fn returns so that it never runs twice at once.
+	cancelled bool
+
+	val string
+	err error
+}
+
+// Do runs fn once for all concurrent callers of key and returns its result.
+// shared reports whether the result was produced by another caller's call.
+// A call every caller has given up on is waited for before fn runs again.
+func (g *coalescer) Do(ctx context.Context, key string, fn func(ctx context.Context) (string, error)) (val string, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*coalescedCall{}
+	}
+	c, shared := g.calls[key]
+	if shared && c.cancelled {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return g.Do(ctx, key, fn)
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+	if !shared {
+		// keep the values (logger) of the first caller but not its cancellation
+		callctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.val, c.err = fn(callctx)
+			cancel()
+
+			g.mu.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, shared, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody is interested anymore, later callers start over once fn returns
+			c.cancel()
+			c.cancelled = true
+		}
+		g.mu.Unlock()
+		return "", shared, ctx.Err()
+	}
+}
+
+// InFlight reports whether a call for key is running.
+func (g *coalescer) InFlight(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.calls[key]
+	return ok
+}
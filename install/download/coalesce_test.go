@@ -0,0 +1,185 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescerSharesOneCall(t *testing.T) {
+	g := &coalescer{}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "done", nil
+	}
+
+	const waiters = 5
+	var wg sync.WaitGroup
+	results := make([]string, waiters)
+	for i := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _, _ = g.Do(context.Background(), "key", fn)
+		}()
+	}
+	// wait until every caller has joined the call
+	for !g.InFlight("key") || waitersOf(g, "key") != waiters {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("fn called %d times, want 1", got)
+	}
+	for i, result := range results {
+		if result != "done" {
+			t.Errorf("caller %d got %q, want %q", i, result, "done")
+		}
+	}
+	if g.InFlight("key") {
+		t.Error("call still in flight after completion")
+	}
+}
+
+func TestCoalescerCancellation(t *testing.T) {
+	g := &coalescer{}
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return "", ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { _, _, err := g.Do(first, "key", fn); errs <- err }()
+	<-started
+	go func() { _, _, err := g.Do(second, "key", fn); errs <- err }()
+	for waitersOf(g, "key") != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelFirst()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("shared call cancelled while another caller is waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelSecond()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("second caller err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("shared call not cancelled after every caller has gone")
+	}
+}
+
+func waitersOf(g *coalescer, key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.waiters
+	}
+	return 0
+}
+
+func TestExtractAtomic(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "example.com", "demo-0.1.0")
+	write := func(name string) func(into string) error {
+		return func(into string) error {
+			return os.WriteFile(filepath.Join(into, name), []byte(name), defaultFileMode)
+		}
+	}
+
+	if err := ExtractAtomic(dest, write("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExtractAtomic(dest, func(into string) error {
+		if err := write("partial")(into); err != nil {
+			return err
+		}
+		return errors.New("interrupted")
+	}); err == nil {
+		t.Fatal("ExtractAtomic() error = nil, want interrupted")
+	}
+	if !exists(filepath.Join(dest, "v1")) || exists(filepath.Join(dest, "partial")) {
+		t.Fatal("failed extraction must leave the previous entry untouched")
+	}
+
+	if err := ExtractAtomic(dest, write("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(dest, "v1")) || !exists(filepath.Join(dest, "v2")) {
+		t.Fatal("extraction must replace the previous entry")
+	}
+	siblings, _ := os.ReadDir(filepath.Dir(dest))
+	if len(siblings) != 1 {
+		t.Fatalf("temporary directories left behind: %v", siblings)
+	}
+}
+
+func TestCoalescerWaitsForCancelledCall(t *testing.T) {
+	g := &coalescer{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	abandoned := func(ctx context.Context) (string, error) {
+		close(started)
+		<-ctx.Done()
+		// the download still writes to the cache entry
+		<-release
+		return "", ctx.Err()
+	}
+	first, cancelFirst := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { _, _, err := g.Do(first, "key", abandoned); errs <- err }()
+	<-started
+	cancelFirst()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller err = %v, want context.Canceled", err)
+	}
+	if !g.InFlight("key") {
+		t.Fatal("abandoned call is not in flight while it runs")
+	}
+
+	var running atomic.Bool
+	results := make(chan string, 1)
+	go func() {
+		val, _, _ := g.Do(context.Background(), "key", func(context.Context) (string, error) {
+			running.Store(true)
+			return "path", nil
+		})
+		results <- val
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if running.Load() {
+		t.Fatal("second call started while the abandoned one runs")
+	}
+	close(release)
+	select {
+	case val := <-results:
+		if val != "path" {
+			t.Fatalf("second caller got %q, want path", val)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second caller did not start over after the abandoned call returned")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -40,16 +42,17 @@ type DownloadMeta struct {
 
 type Downloader struct {
 	CacheDir string
+	Cache    *CacheManager
 }
 
 func NewDownloader(cacheDir string) *Downloader {
-	return &Downloader{CacheDir: cacheDir}
+	return &Downloader{CacheDir: cacheDir, Cache: NewCacheManager(cacheDir)}
 }
 
 // we cache "bundle" in a directory with name
 // "{repo host}/{name}-{version} or {repo host}/{name}-{version}.tgz" under cache directory
 func (d *Downloader) Download(ctx context.Context, instance install.Instance) (string, error) {
-	chart, repo, version, path := instance.Chart, instance.Repository, instance.Version, instance.Path
+	chart, repo, version := instance.Chart, instance.Repository, instance.Version
 
 	log := logr.FromContextOrDiscard(ctx)
 	if chart == "" {
@@ -58,11 +61,6 @@ func (d *Downloader) Download(ctx context.Context, instance install.Instance) (s
 	if repo == "" {
 		return "", fmt.Errorf("no url specified for %s", chart)
 	}
-	basename := chart
-	if version != "" {
-		basename = chart + "-" + version
-	}
-
 	// is file://
 	if path, ok := strings.CutPrefix(repo, "file://"); ok {
 		// check exist
@@ -72,54 +70,113 @@ func (d *Downloader) Download(ctx context.Context, instance install.Instance) (s
 		}
 	}
 
-	// from cache (skip cache when version is empty to always fetch latest)
-	perRepoCacheDir := PerRepoCacheDir(repo, d.CacheDir)
-	if version != "" {
-		if cachepath := foundInCache(ctx, perRepoCacheDir, basename); cachepath != "" {
-			log.Info("found in cache", "path", cachepath)
-			return cachepath, nil
-		}
-	}
-
-	cacheIn := filepath.Join(perRepoCacheDir, basename)
-
-	log.Info("downloading...", "cache", cacheIn)
+	cacheIn := CacheKey(d.CacheDir, instance)
+	// skip cache when version is empty to always fetch latest
+	return d.Cache.Fetch(ctx, cacheIn, version != "", func(ctx context.Context) (string, error) {
+		log.Info("downloading...", "cache", cacheIn)
+		return d.download(ctx, instance, cacheIn)
+	})
+}
 
+func (d *Downloader) download(ctx context.Context, instance install.Instance, cacheIn string) (string, error) {
+	chart, repo, version, path := instance.Chart, instance.Repository, instance.Version, instance.Path
 	// is git ?
 	if strings.HasSuffix(repo, ".git") {
-		return cacheIn, DownloadGit(ctx, repo, version, path, cacheIn)
+		return cacheIn, ExtractAtomic(cacheIn, func(into string) error {
+			return DownloadGit(ctx, repo, version, path, into)
+		})
 	}
 	// is zip ?
 	if strings.HasSuffix(repo, ".zip") {
-		return cacheIn, DownloadZip(ctx, repo, path, cacheIn)
+		return cacheIn, ExtractAtomic(cacheIn, func(into string) error {
+			return DownloadZip(ctx, repo, path, into)
+		})
 	}
 	// is tar.gz ?
 	if strings.HasSuffix(repo, ".tar.gz") || strings.HasSuffix(repo, ".tgz") {
-		return cacheIn, DownloadTgz(ctx, repo, path, cacheIn)
+		return cacheIn, ExtractAtomic(cacheIn, func(into string) error {
+			return DownloadTgz(ctx, repo, path, into)
+		})
+	}
+	// is a single manifest file ?
+	if instance.Kind == install.InstanceKindManifests && IsManifestFile(repo) {
+		opts := RepositoryOptions(instance)
+		// unlike chart repositories, the certificate is verified without TLS settings
+		opts.InsecureSkipTLSVerify = instance.TLS != nil && instance.TLS.InsecureSkipVerify
+		return cacheIn, ExtractAtomic(cacheIn, func(into string) error {
+			return DownloadManifest(ctx, repo, into, opts)
+		})
 	}
 	// is helm ? default helm
-	var username, password string
-	if instance.Auth != nil {
-		username = instance.Auth.Username
-		password = instance.Auth.Password
+	// helm.Download reuses an existing archive, which is unsafe unless it was
+	// marked complete; the archive itself is written atomically.
+	if !d.Cache.IsComplete(cacheIn + ".tgz") {
+		if err := os.RemoveAll(cacheIn + ".tgz"); err != nil {
+			return "", err
+		}
 	}
-	chartpath, _, err := helm.Download(ctx, repo, chart, version, filepath.Dir(cacheIn), username, password)
+	chartpath, _, err := helm.Download(ctx, repo, chart, version, filepath.Dir(cacheIn), RepositoryOptions(instance))
 	if err != nil {
-		return chartpath, err
+		return "", err
+	}
+	return chartpath, nil
+}
+
+// RepositoryOptions returns the options to access the chart repository of
+// instance. Without TLS settings the repository certificate is not verified.
+func RepositoryOptions(instance install.Instance) helm.RepositoryOptions {
+	opts := helm.RepositoryOptions{InsecureSkipTLSVerify: true, IndexFile: instance.RepositoryIndex}
+	if instance.Auth != nil {
+		opts.Username, opts.Password = instance.Auth.Username, instance.Auth.Password
+	}
+	if instance.TLS != nil {
+		opts.CAFile, opts.CertFile, opts.KeyFile = instance.TLS.CAFile, instance.TLS.CertFile, instance.TLS.KeyFile
+		opts.InsecureSkipTLSVerify = instance.TLS.InsecureSkipVerify
 	}
-	return chartpath, err
+	return opts
 }
 
-func foundInCache(_ context.Context, cachedir, basename string) string {
-	cacheInFile := filepath.Join(cachedir, basename+".tgz")
-	if _, err := os.Stat(cacheInFile); err == nil {
-		return cacheInFile
+// ExtractAtomic runs extract into a temporary sibling directory of dest and
+// renames it to dest once complete, so dest never holds a partial download.
+// A previous dest is replaced.
+func ExtractAtomic(dest string, extract func(into string) error) error {
+	parent, base := filepath.Split(dest)
+	if err := os.MkdirAll(parent, defaultDirMode); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, base+PartialSuffix+"*")
+	if err != nil {
+		return err
+	}
+	if err := extract(tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, defaultDirMode); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	// rename can not replace a non-empty directory, move the previous one aside
+	if _, err := os.Stat(dest); err == nil {
+		// reserve a unique name only, not every filesystem renames onto an empty directory
+		old, err := os.MkdirTemp(parent, base+PartialSuffix+"*")
+		if err != nil {
+			_ = os.RemoveAll(tmp)
+			return err
+		}
+		_ = os.Remove(old)
+		if err := os.Rename(dest, old); err != nil {
+			_ = os.RemoveAll(tmp)
+			_ = os.RemoveAll(old)
+			return err
+		}
+		defer os.RemoveAll(old)
 	}
-	cacheInDir := filepath.Join(cachedir, basename)
-	if entries, err := os.ReadDir(cacheInDir); err == nil && len(entries) >= 0 {
-		return cacheInDir
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
 	}
-	return ""
+	return nil
 }
 
 func PerRepoCacheDir(repo string, basedir string) string {
@@ -209,6 +266,55 @@ func DownloadTgz(ctx context.Context, uri, subpath, into string) error {
 	return UnTarGz(resp.Body, subpath, into)
 }
 
+// IsManifestFile reports whether uri refers to a single YAML or JSON file.
+func IsManifestFile(uri string) bool {
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	switch strings.ToLower(filepath.Ext(uri)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// DownloadManifest downloads the file at uri into the directory into, with
+// the credentials and TLS settings of opts.
+func DownloadManifest(ctx context.Context, uri, into string, opts helm.RepositoryOptions) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	if opts.Username != "" || opts.Password != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+	tlsConfig, err := opts.TLSConfig()
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", uri, resp.Status)
+	}
+	dest, err := os.OpenFile(filepath.Join(into, path.Base(u.Path)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	_, err = io.Copy(dest, resp.Body)
+	return err
+}
+
 func DownloadFile(ctx context.Context, src string, subpath, into string) error {
 	u, err := url.ParseRequestURI(src)
 	if err != nil {
@@ -366,3 +472,41 @@ func UnTarGz(r io.Reader, subpath, into string) error {
 	}
 	return nil
 }
+
+// Digest returns the sha256 digest of the file or the directory tree at path,
+// over the relative paths and contents of its regular files. Git metadata is
+// left out.
+func Digest(path string) (string, error) {
+	hash := sha256.New()
+	err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, name)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(hash, "%s\x00%d\x00", filepath.ToSlash(rel), info.Size())
+		_, err = io.Copy(hash, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
@@ -1,6 +1,16 @@
 package download
 
-import "testing"
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xiaoshiai.cn/installer/install"
+)
 
 func TestPerRepoCacheDir(t *testing.T) {
 	tests := []struct {
@@ -27,3 +37,114 @@ func TestPerRepoCacheDir(t *testing.T) {
 		})
 	}
 }
+
+func TestDownloadManifestFile(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: operator\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/releases/v1.0.0/install.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(manifest))
+	}))
+	defer server.Close()
+
+	d := NewDownloader(t.TempDir())
+	dir, err := d.Download(context.Background(), install.Instance{
+		Kind:       install.InstanceKindManifests,
+		Repository: server.URL + "/releases/v1.0.0/install.yaml",
+		Chart:      "operator",
+		Version:    "v1.0.0",
+	})
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "install.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != manifest {
+		t.Errorf("downloaded %q, want %q", data, manifest)
+	}
+	if _, err := d.Download(context.Background(), install.Instance{
+		Kind:       install.InstanceKindManifests,
+		Repository: server.URL + "/missing.yaml",
+		Chart:      "missing",
+	}); err == nil {
+		t.Error("Download() of a missing file succeeded")
+	}
+}
+
+func TestDownloadManifestAuthAndTLS(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: operator\n"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(manifest))
+	}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := install.Instance{
+		Kind:       install.InstanceKindManifests,
+		Repository: server.URL + "/install.yaml",
+		Chart:      "operator",
+		Version:    "v1.0.0",
+	}
+	if _, err := NewDownloader(t.TempDir()).Download(context.Background(), instance); err == nil {
+		t.Fatal("Download() without the repository CA succeeded")
+	}
+	instance.TLS = &install.ResolvedTLS{CAFile: caFile}
+	if _, err := NewDownloader(t.TempDir()).Download(context.Background(), instance); err == nil {
+		t.Fatal("Download() without credentials succeeded")
+	}
+	instance.Auth = &install.ResolvedAuth{Username: "admin", Password: "secret"}
+	dir, err := NewDownloader(t.TempDir()).Download(context.Background(), instance)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "install.yaml")); err != nil || string(data) != manifest {
+		t.Errorf("downloaded %q, want %q: %v", data, manifest, err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	write := func(dir, name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := t.TempDir(), t.TempDir()
+	for _, dir := range []string{a, b} {
+		write(dir, "Chart.yaml", "name: demo\n")
+		write(dir, "templates/cm.yaml", "kind: ConfigMap\n")
+	}
+	// git metadata does not change the digest of a checkout
+	write(b, ".git/HEAD", "ref: refs/heads/main\n")
+
+	da, err := Digest(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Digest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if da != db {
+		t.Errorf("digest of identical trees differ: %s != %s", da, db)
+	}
+	write(b, "templates/cm.yaml", "kind: Secret\n")
+	if db, _ = Digest(b); da == db {
+		t.Errorf("digest did not change with the content: %s", db)
+	}
+}
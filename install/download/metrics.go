@@ -0,0 +1,33 @@
+package download
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "installer_download_cache_hits_total",
+		Help: "Number of downloads served from the cache.",
+	})
+	cacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "installer_download_cache_misses_total",
+		Help: "Number of downloads not found in the cache.",
+	})
+	downloadsCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "installer_download_coalesced_total",
+		Help: "Number of downloads served by a concurrent download of the same source.",
+	})
+	cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "installer_download_cache_evictions_total",
+		Help: "Number of cache entries evicted by garbage collection.",
+	})
+	cacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "installer_download_cache_size_bytes",
+		Help: "Total size of completed cache entries after the last garbage collection.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(cacheHits, cacheMisses, downloadsCoalesced, cacheEvictions, cacheSize)
+}
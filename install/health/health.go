@@ -0,0 +1,189 @@
+package health
+
+import (
+	"strings"
+
+	k8sappsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"xiaoshiai.cn/installer/apis/apps"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+const (
+	StatusDegraded = "Degraded"
+	StatusUpdating = "Updating"
+	StatusScaling  = "Scaling"
+
+	StatusPaused  = "Paused"
+	StatusUnknown = "Unknown"
+
+	StatusPending          = "Pending"
+	StatusCrashLoopBackOff = "CrashLoopBackOff"
+	StatusFailed           = "Failed"
+	StatusUnhealthy        = "Unhealthy"
+	StatusError            = "Error"
+
+	StatusSucceeded = "Succeeded"
+	StatusActive    = "Active"
+	StatusHealthy   = "Healthy"
+	StatusCompleted = "Completed"
+	StatusRunning   = "Running"
+)
+
+// IsHealthy reports whether status is a healthy state status.
+func IsHealthy(status string) bool {
+	switch status {
+	case StatusRunning, StatusHealthy, StatusActive, StatusSucceeded, StatusCompleted:
+		return true
+	}
+	return false
+}
+
+// States returns the default workload states of resources, resources of
+// other kinds have no state.
+func States(resources []*unstructured.Unstructured) []appsv1.State {
+	var states []appsv1.State
+	for _, resource := range resources {
+		var state appsv1.State
+		switch resource.GroupVersionKind().GroupKind() {
+		case schema.GroupKind{Group: "batch", Kind: "Job"}:
+			state = getJobState(resource)
+		case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+			state = getDeploymentState(resource)
+		case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+			state = getStatefulSetState(resource)
+		case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+			state = getDaemonSetState(resource)
+		case schema.GroupKind{Group: "core", Kind: "Pod"}:
+			state = getPodState(resource)
+		case schema.GroupKind{Group: apps.GroupName, Kind: "Instance"}:
+			state = getInstanceState(resource)
+		default:
+			continue
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func getJobState(resource *unstructured.Unstructured) appsv1.State {
+	job := &batchv1.Job{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, job); err != nil {
+		return appsv1.State{}
+	}
+	state := appsv1.State{Name: job.Name, Kind: "Job"}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobSuspended && c.Status == corev1.ConditionTrue {
+			state.Status = StatusPaused
+			return state
+		}
+		if c.Type == batchv1.JobComplete && c.Status == corev1.ConditionTrue {
+			state.Status = StatusSucceeded
+			return state
+		}
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			state.Status = StatusFailed
+			state.Message = c.Message
+			return state
+		}
+	}
+	state.Status = StatusRunning
+	return state
+}
+
+func getInstanceState(resource *unstructured.Unstructured) appsv1.State {
+	instance := &appsv1.Instance{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, instance); err != nil {
+		return appsv1.State{}
+	}
+	state := appsv1.State{Name: instance.Name, Kind: "Instance"}
+	state.Status = string(instance.Status.Phase)
+	return state
+}
+
+func getDeploymentState(resource *unstructured.Unstructured) appsv1.State {
+	deployment := &k8sappsv1.Deployment{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, deployment); err != nil {
+		return appsv1.State{}
+	}
+	state := appsv1.State{
+		Name:   deployment.Name,
+		Kind:   "Deployment",
+		Status: calcReplicasState(deployment.Status.Replicas, deployment.Status.ReadyReplicas),
+	}
+	messages := []string{}
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == k8sappsv1.DeploymentAvailable && c.Status == corev1.ConditionFalse {
+			messages = append(messages, c.Message)
+		}
+		if c.Type == k8sappsv1.DeploymentReplicaFailure && c.Status == corev1.ConditionTrue {
+			messages = append(messages, c.Message)
+		}
+	}
+	if len(messages) > 0 {
+		state.Message = strings.Join(messages, "\n")
+	}
+	return state
+}
+
+func getStatefulSetState(resource *unstructured.Unstructured) appsv1.State {
+	statefulset := &k8sappsv1.StatefulSet{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, statefulset); err != nil {
+		return appsv1.State{}
+	}
+	state := appsv1.State{
+		Name:   statefulset.Name,
+		Kind:   "StatefulSet",
+		Status: calcReplicasState(statefulset.Status.Replicas, statefulset.Status.ReadyReplicas),
+	}
+	return state
+}
+
+func calcReplicasState(desired int32, ready int32) string {
+	if desired == 0 {
+		return StatusPaused
+	}
+	if ready == desired {
+		return StatusRunning
+	}
+	if ready == 0 {
+		return StatusUnhealthy
+	}
+	return StatusDegraded
+}
+
+func getDaemonSetState(resource *unstructured.Unstructured) appsv1.State {
+	daemonset := &k8sappsv1.DaemonSet{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, daemonset); err != nil {
+		return appsv1.State{}
+	}
+	return appsv1.State{
+		Name:   daemonset.Name,
+		Kind:   "DaemonSet",
+		Status: calcReplicasState(daemonset.Status.DesiredNumberScheduled, daemonset.Status.NumberReady),
+	}
+}
+
+func getPodState(resource *unstructured.Unstructured) appsv1.State {
+	pod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, pod); err != nil {
+		return appsv1.State{}
+	}
+	state := appsv1.State{Name: pod.Name, Kind: "Pod"}
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		state.Status = StatusSucceeded
+	case corev1.PodFailed:
+		state.Status = StatusFailed
+		state.Message = pod.Status.Message
+	case corev1.PodRunning:
+		state.Status = StatusRunning
+	default:
+		state.Status = StatusPending
+	}
+	return state
+}
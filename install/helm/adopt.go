@@ -0,0 +1,137 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/postrender"
+	"helm.sh/helm/v3/pkg/release"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// the metadata helm checks before installing a release over existing objects
+const (
+	helmManagedByLabel             = "app.kubernetes.io/managed-by"
+	helmManagedByValue             = "Helm"
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// releaseOf returns the release name and namespace of instance, which differ
+// from the instance when an existing release is or was adopted.
+func releaseOf(instance install.Instance) (string, string) {
+	name, namespace := instance.Name, instance.Namespace
+	if instance.Release != nil {
+		name = Or(instance.Release.Name, name)
+		namespace = Or(instance.Release.Namespace, namespace)
+	}
+	if instance.Adopt != nil {
+		name = Or(instance.Adopt.ReleaseName, name)
+		namespace = Or(instance.Adopt.ReleaseNamespace, namespace)
+	}
+	return name, namespace
+}
+
+// adoptResources marks the existing objects of the rendered chart as owned by
+// the release, so helm installs over them instead of failing. Objects owned
+// by another release are refused.
+func adoptResources(ctx context.Context, helmcfg *action.Configuration, loadedChart *chart.Chart, rlsname, namespace string, values map[string]any, pr postrender.PostRenderer) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("name", rlsname, "namespace", namespace)
+	manifest, err := TemplateChart(ctx, rlsname, namespace, loadedChart, values, pr)
+	if err != nil {
+		return fmt.Errorf("render chart: %w", err)
+	}
+	resources, err := helmcfg.KubeClient.Build(bytes.NewBuffer(manifest), false)
+	if err != nil {
+		return fmt.Errorf("build resources: %w", err)
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]string{helmManagedByLabel: helmManagedByValue},
+			"annotations": map[string]string{
+				helmReleaseNameAnnotation:      rlsname,
+				helmReleaseNamespaceAnnotation: namespace,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	adopted := 0
+	err = resources.Visit(func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+		helper := resource.NewHelper(info.Client, info.Mapping)
+		existing, err := helper.Get(info.Namespace, info.Name)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("get %s %s: %w", info.Mapping.GroupVersionKind.Kind, info.Name, err)
+		}
+		accessor, err := meta.Accessor(existing)
+		if err != nil {
+			return err
+		}
+		annotations := accessor.GetAnnotations()
+		if owner, ok := annotations[helmReleaseNameAnnotation]; ok &&
+			(owner != rlsname || annotations[helmReleaseNamespaceAnnotation] != namespace) {
+			return fmt.Errorf("%s %s is owned by release %s/%s",
+				info.Mapping.GroupVersionKind.Kind, info.Name, annotations[helmReleaseNamespaceAnnotation], owner)
+		}
+		if _, err := helper.Patch(info.Namespace, info.Name, types.MergePatchType, patch, nil); err != nil {
+			return fmt.Errorf("adopt %s %s: %w", info.Mapping.GroupVersionKind.Kind, info.Name, err)
+		}
+		adopted++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("adopted existing objects", "count", adopted)
+	return nil
+}
+
+// releaseHistory returns the latest revisions of the release, oldest first.
+func releaseHistory(helmcfg *action.Configuration, rlsname string) ([]appsv1.InstanceRevision, error) {
+	history := action.NewHistory(helmcfg)
+	history.Max = appsv1.MaxInstanceHistory
+	releases, err := history.Run(rlsname)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Version < releases[j].Version })
+	if len(releases) > appsv1.MaxInstanceHistory {
+		releases = releases[len(releases)-appsv1.MaxInstanceHistory:]
+	}
+	revisions := make([]appsv1.InstanceRevision, 0, len(releases))
+	for _, rls := range releases {
+		revisions = append(revisions, releaseRevision(rls))
+	}
+	return revisions, nil
+}
+
+func releaseRevision(rls *release.Release) appsv1.InstanceRevision {
+	revision := appsv1.InstanceRevision{Revision: rls.Version}
+	if rls.Chart != nil && rls.Chart.Metadata != nil {
+		revision.Version = rls.Chart.Metadata.Version
+		revision.AppVersion = rls.Chart.Metadata.AppVersion
+	}
+	if rls.Info != nil {
+		revision.Description = rls.Info.Description
+		revision.UpdatedTime.Time = rls.Info.LastDeployed.Time
+	}
+	return revision
+}
@@ -32,7 +32,13 @@ func New(config *rest.Config) *Apply {
 }
 
 func (r *Apply) Template(ctx context.Context, instance install.Instance) ([]byte, error) {
-	return TemplateChart(ctx, instance.Name, instance.Namespace, instance.Location, nil)
+	loadedChart, err := loader.Load(instance.Location)
+	if err != nil {
+		return nil, fmt.Errorf("load chart: %w", err)
+	}
+	helmPR := NewHelmPostRenderer(instance.PostRenderer, loadedChart)
+	rlsname, rlsnamespace := releaseOf(instance)
+	return TemplateChart(ctx, rlsname, rlsnamespace, loadedChart, instance.Values, helmPR)
 }
 
 func (r *Apply) Apply(ctx context.Context, instance install.Instance) (*install.InstanceStatus, error) {
@@ -44,6 +50,7 @@ func (r *Apply) Apply(ctx context.Context, instance install.Instance) (*install.
 	if err != nil {
 		return nil, fmt.Errorf("parse options: %w", err)
 	}
+	options.Adopt = instance.Adopt != nil
 
 	// Load chart once for both ApplyChart and dashboard injection
 	loadedChart, err := loader.Load(instance.Location)
@@ -54,13 +61,20 @@ func (r *Apply) Apply(ctx context.Context, instance install.Instance) (*install.
 	helmPR := NewHelmPostRenderer(instance.PostRenderer, loadedChart)
 	desiredState := desiredReleaseState(loadedChart, install.PostRendererIdentity(instance.PostRenderer))
 
-	applyedRelease, err := ApplyChart(ctx, r.Config, instance.Name, instance.Namespace, loadedChart, instance.Values, options, helmPR, desiredState)
+	rlsname, rlsnamespace := releaseOf(instance)
+	applyedRelease, err := ApplyChart(ctx, r.Config, rlsname, rlsnamespace, loadedChart, instance.Values, options, helmPR, desiredState)
 	if err != nil {
 		return nil, err
 	}
 	if applyedRelease.Info.Status != release.StatusDeployed {
 		return nil, fmt.Errorf("apply not finished:%s", applyedRelease.Info.Description)
 	}
+	history, err := ReleaseHistory(ctx, r.Config, rlsname, rlsnamespace)
+	if err != nil {
+		// the history is informational, the release itself is deployed
+		log.Error(err, "get release history")
+		history = []appsv1.InstanceRevision{releaseRevision(applyedRelease)}
+	}
 	return &install.InstanceStatus{
 		Note:              applyedRelease.Info.Notes,
 		Namespace:         applyedRelease.Namespace,
@@ -70,9 +84,21 @@ func (r *Apply) Apply(ctx context.Context, instance install.Instance) (*install.
 		Version:           applyedRelease.Chart.Metadata.Version,
 		AppVersion:        applyedRelease.Chart.Metadata.AppVersion,
 		Resources:         ParseResourceReferences([]byte(applyedRelease.Manifest)),
+		Description:       applyedRelease.Info.Description,
+		History:           history,
+		Release:           releaseStatus(instance, rlsname, rlsnamespace),
 	}, nil
 }
 
+// releaseStatus returns the release of instance to keep in its status, nil
+// when the release is named after the instance.
+func releaseStatus(instance install.Instance, rlsname, rlsnamespace string) *appsv1.InstanceRelease {
+	if rlsname == instance.Name && rlsnamespace == instance.Namespace {
+		return nil
+	}
+	return &appsv1.InstanceRelease{Name: rlsname, Namespace: rlsnamespace}
+}
+
 func desiredReleaseState(ch *chart.Chart, postRendererIdentity string) string {
 	h := sha256.New224()
 	writeChartState(h, ch)
@@ -178,19 +204,22 @@ type RemoveOptions struct {
 	DryRun bool
 }
 
-func (r *Apply) Remove(ctx context.Context, instance install.Instance) error {
+func (r *Apply) Remove(ctx context.Context, instance install.Instance) ([]appsv1.ManagedResource, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
 	options, err := ParseOptions(instance.Options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// uninstall
-	removedRelease, err := RemoveChart(ctx, r.Config, instance.Name, instance.Namespace, options)
+	rlsname, rlsnamespace := releaseOf(instance)
+	removedRelease, err := RemoveChart(ctx, r.Config, rlsname, rlsnamespace, options, instance.DeletionPolicy)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Info("removed")
-	_ = removedRelease
-	return nil
+	if removedRelease == nil {
+		return nil, nil
+	}
+	return ParseResourceReferences([]byte(removedRelease.Manifest)), nil
 }
@@ -3,6 +3,7 @@ package helm
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
@@ -29,8 +30,65 @@ type ApplyOptions struct {
 	Version string
 }
 
+// RepositoryOptions configures the access to a chart repository.
+type RepositoryOptions struct {
+	Username string
+	Password string
+
+	// CAFile, CertFile and KeyFile are PEM files used to verify the
+	// repository and to authenticate against it.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// InsecureSkipTLSVerify skips the verification of the repository certificate.
+	InsecureSkipTLSVerify bool
+
+	// IndexFile is a local copy of the repository index, charts are looked
+	// up in it instead of fetching the index from the repository.
+	IndexFile string
+}
+
+// getterOptions returns the helm getter options for the repository.
+func (o RepositoryOptions) getterOptions() []getter.Option {
+	options := []getter.Option{
+		getter.WithUserAgent(InstallerUserAgent()),
+		getter.WithInsecureSkipVerifyTLS(o.InsecureSkipTLSVerify),
+	}
+	if o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" {
+		options = append(options, getter.WithTLSClientConfig(o.CertFile, o.KeyFile, o.CAFile))
+	}
+	if o.Username != "" || o.Password != "" {
+		options = append(options, getter.WithBasicAuth(o.Username, o.Password))
+	}
+	return options
+}
+
+// TLSConfig returns the TLS client configuration for the repository.
+func (o RepositoryOptions) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.InsecureSkipTLSVerify} // nolint: gosec
+	if o.CertFile != "" && o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load repository client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		ca, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read repository CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
 // Download helm chart into cachedir saved as {name}-{version}.tgz file.
-func Download(ctx context.Context, repo, name, version, cachedir, username, password string) (string, *chart.Chart, error) {
+func Download(ctx context.Context, repo, name, version, cachedir string, opts RepositoryOptions) (string, *chart.Chart, error) {
 	// check exists
 	filename := filepath.Join(cachedir, name+"-"+version+".tgz")
 	if _, err := os.Stat(filename); err == nil {
@@ -40,7 +98,7 @@ func Download(ctx context.Context, repo, name, version, cachedir, username, pass
 		}
 		return filename, chart, nil
 	}
-	chartPath, chart, err := LoadAndUpdateChart(ctx, repo, name, version, username, password)
+	chartPath, chart, err := LoadAndUpdateChart(ctx, repo, name, version, opts)
 	if err != nil {
 		return "", nil, err
 	}
@@ -59,8 +117,8 @@ func Download(ctx context.Context, repo, name, version, cachedir, username, pass
 // repo is the url of the chart repository,eg: http://charts.example.com
 // if repopath is not empty,download it from repo and set chartNameOrPath to repo/repopath.
 // LoadChart loads the chart from the repository
-func LoadAndUpdateChart(ctx context.Context, repo, nameOrPath, version, username, password string) (string, *chart.Chart, error) {
-	chartPath, err := LocateChartSuper(ctx, repo, nameOrPath, version, username, password)
+func LoadAndUpdateChart(ctx context.Context, repo, nameOrPath, version string, opts RepositoryOptions) (string, *chart.Chart, error) {
+	chartPath, err := LocateChartSuper(ctx, repo, nameOrPath, version, opts)
 	if err != nil {
 		return "", nil, err
 	}
@@ -91,13 +149,13 @@ func LoadAndUpdateChart(ctx context.Context, repo, nameOrPath, version, username
 	return chartPath, chart, nil
 }
 
-func LocateChartSuper(ctx context.Context, repoURL, name, version, username, password string) (string, error) {
+func LocateChartSuper(ctx context.Context, repoURL, name, version string, opts RepositoryOptions) (string, error) {
 	repou, err := url.Parse(repoURL)
 	if err != nil {
 		return "", err
 	}
 	if repou.Scheme != FileProtocolSchema {
-		return downloadChart(ctx, repoURL, name, version, username, password)
+		return downloadChart(ctx, repoURL, name, version, opts)
 	}
 	// handle file:// schema
 	index, err := LoadIndex(ctx, repoURL)
@@ -123,36 +181,31 @@ func LocateChartSuper(ctx context.Context, repoURL, name, version, username, pas
 	return repou.ResolveReference(downloadu).Path, nil
 }
 
-func downloadChart(_ context.Context, repourl, name, version, username, password string) (string, error) {
+func downloadChart(_ context.Context, repourl, name, version string, opts RepositoryOptions) (string, error) {
+	username, password := opts.Username, opts.Password
 	settings := cli.New()
 	dl := downloader.ChartDownloader{
 		Out:              os.Stdout,
 		Getters:          getter.All(settings),
 		RepositoryConfig: settings.RepositoryConfig,
 		RepositoryCache:  settings.RepositoryCache,
-		Options: []getter.Option{
-			getter.WithUserAgent(InstallerUserAgent()),
-			getter.WithInsecureSkipVerifyTLS(true),
-		},
-	}
-	if username != "" || password != "" {
-		dl.Options = append(dl.Options,
-			getter.WithBasicAuth(username, password),
-		)
+		Options:          opts.getterOptions(),
 	}
 	// nolint nestif
 	if repourl != "" {
 		if registry.IsOCI(repourl) {
-			insecureHTTPClient := &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-				},
+			tlsConfig, err := opts.TLSConfig()
+			if err != nil {
+				return "", err
+			}
+			httpClient := &http.Client{
+				Transport: &http.Transport{TLSClientConfig: tlsConfig},
 			}
 			registryOpts := []registry.ClientOption{
 				registry.ClientOptDebug(settings.Debug),
 				registry.ClientOptWriter(os.Stderr),
 				registry.ClientOptCredentialsFile(settings.RegistryConfig),
-				registry.ClientOptHTTPClient(insecureHTTPClient),
+				registry.ClientOptHTTPClient(httpClient),
 			}
 			if username != "" {
 				registryOpts = append(registryOpts, registry.ClientOptBasicAuth(username, password))
@@ -164,13 +217,20 @@ func downloadChart(_ context.Context, repourl, name, version, username, password
 			dl.RegistryClient = registryClient
 			dl.Options = append(dl.Options, getter.WithRegistryClient(registryClient))
 			name = repourl
+		} else if opts.IndexFile != "" {
+			chartURL, err := findChartInIndexFile(opts.IndexFile, repourl, name, version)
+			if err != nil {
+				return "", err
+			}
+			dl.Options = append(dl.Options, getter.WithPassCredentialsAll(username != ""))
+			name = chartURL
 		} else {
 			chartURL, err := repo.FindChartInAuthAndTLSAndPassRepoURL(
 				repourl,
 				username, password,
 				name, version,
-				"", "", "", // cert key ca
-				true, username != "", // insecureTLS passCredentialsAll
+				opts.CertFile, opts.KeyFile, opts.CAFile,
+				opts.InsecureSkipTLSVerify, username != "", // passCredentialsAll
 				dl.Getters)
 			if err != nil {
 				return "", err
@@ -188,6 +248,23 @@ func downloadChart(_ context.Context, repourl, name, version, username, password
 	return filename, nil
 }
 
+// findChartInIndexFile returns the absolute download URL of a chart version
+// listed in a local copy of the index of the repository at repourl.
+func findChartInIndexFile(indexFile, repourl, name, version string) (string, error) {
+	index, err := LoadLocalIndex(indexFile)
+	if err != nil {
+		return "", fmt.Errorf("load cached index of %s: %w", repourl, err)
+	}
+	cv, err := index.Get(name, version)
+	if err != nil {
+		return "", fmt.Errorf("%s-%s not found in repository %s", name, version, repourl)
+	}
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("%s-%s has no downloadable URLs", name, version)
+	}
+	return repo.ResolveReferenceURL(repourl, cv.URLs[0])
+}
+
 func InstallerUserAgent() string {
 	return "installer/" + version.Get().GitVersion
 }
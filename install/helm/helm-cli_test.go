@@ -0,0 +1,43 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindChartInIndexFile(t *testing.T) {
+	index := filepath.Join(t.TempDir(), IndexFileName)
+	content := `apiVersion: v1
+entries:
+  demo:
+  - name: demo
+    version: 1.0.0
+    urls: [charts/demo-1.0.0.tgz]
+  remote:
+  - name: remote
+    version: 2.0.0
+    urls: [https://cdn.example.test/remote-2.0.0.tgz]
+`
+	if err := os.WriteFile(index, []byte(content), DefaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		chart, version, want string
+		wantErr              bool
+	}{
+		{chart: "demo", version: "1.0.0", want: "https://charts.example.test/stable/charts/demo-1.0.0.tgz"},
+		{chart: "demo", want: "https://charts.example.test/stable/charts/demo-1.0.0.tgz"},
+		{chart: "remote", version: "2.0.0", want: "https://cdn.example.test/remote-2.0.0.tgz"},
+		{chart: "demo", version: "9.9.9", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := findChartInIndexFile(index, "https://charts.example.test/stable", tt.chart, tt.version)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("findChartInIndexFile(%s, %s) error = %v, wantErr %v", tt.chart, tt.version, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("findChartInIndexFile(%s, %s) = %q, want %q", tt.chart, tt.version, got, tt.want)
+		}
+	}
+}
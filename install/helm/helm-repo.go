@@ -8,7 +8,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/repo"
 	"sigs.k8s.io/yaml"
 )
@@ -17,6 +20,17 @@ const IndexFileName = "index.yaml"
 
 const FileProtocolSchema = "file"
 
+// ChartURL returns the URL chart is downloaded from in the repository at
+// repoURL. An OCI registry has no index to look the chart up in, the chart
+// is pulled by its reference under the registry URL; other repositories are
+// returned as is.
+func ChartURL(repoURL, chart string) string {
+	if !registry.IsOCI(repoURL) || chart == "" {
+		return repoURL
+	}
+	return strings.TrimSuffix(repoURL, "/") + "/" + chart
+}
+
 func LoadIndex(ctx context.Context, uri string) (*repo.IndexFile, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -65,6 +79,42 @@ func LoadRemoteIndex(ctx context.Context, repo string) (*repo.IndexFile, error)
 	return indexFile, nil
 }
 
+// FetchIndex downloads the index of the chart repository at uri with opts and
+// returns its raw content along with the parsed index.
+func FetchIndex(_ context.Context, uri string, opts RepositoryOptions) ([]byte, *repo.IndexFile, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	var data []byte
+	switch u.Scheme {
+	case "http", "https":
+		indexu := *u
+		indexu.Path = path.Join(indexu.Path, IndexFileName)
+		indexu.RawPath = ""
+		g, err := getter.NewHTTPGetter(append(opts.getterOptions(), getter.WithURL(uri))...)
+		if err != nil {
+			return nil, nil, err
+		}
+		buf, err := g.Get(indexu.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		data = buf.Bytes()
+	case FileProtocolSchema, "":
+		if data, err = os.ReadFile(filepath.Join(u.Path, IndexFileName)); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported schema of uri %s", uri)
+	}
+	index, err := LoadIndexData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, index, nil
+}
+
 // The source parameter is only used for logging.
 // This will fail if API Version is not set (ErrNoAPIVersion) or if the unmarshal fails.
 func LoadIndexData(data []byte) (*repo.IndexFile, error) {
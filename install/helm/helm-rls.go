@@ -11,13 +11,13 @@ import (
 	"github.com/go-logr/logr"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
-	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/kube"
 	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/rest"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 	"xiaoshiai.cn/installer/install"
 )
 
@@ -30,6 +30,8 @@ type Options struct {
 	Wait            bool
 	WaitForJobs     bool
 	SubNotes        bool
+	// Adopt installs a missing release over the existing objects of the chart.
+	Adopt bool
 }
 
 const DesiredStateLabel = "apps.xiaoshiai.cn/desired-state"
@@ -63,15 +65,14 @@ func NewHelmConfig(ctx context.Context, namespace string, cfg *rest.Config) (*ac
 	return config, nil
 }
 
-func TemplateChart(ctx context.Context, rlsname, namespace string, chartPath string, values map[string]any) ([]byte, error) {
-	chart, err := loader.Load(chartPath)
-	if err != nil {
-		return nil, fmt.Errorf("load chart: %w", err)
-	}
+// TemplateChart renders loadedChart client side with values and the post
+// renderer the release would be installed with.
+func TemplateChart(ctx context.Context, rlsname, namespace string, loadedChart *chart.Chart, values map[string]any, pr postrender.PostRenderer) ([]byte, error) {
 	install := action.NewInstall(&action.Configuration{})
 	install.ReleaseName, install.Namespace = rlsname, namespace
 	install.DryRun, install.DisableHooks, install.ClientOnly = true, true, true
-	rls, err := install.RunWithContext(ctx, chart, values)
+	install.PostRenderer = newLifecyclePostRenderer(pr)
+	rls, err := install.RunWithContext(ctx, loadedChart, values)
 	if err != nil {
 		return nil, err
 	}
@@ -90,12 +91,18 @@ func ApplyChart(ctx context.Context, cfg *rest.Config, rlsname, namespace string
 	if client, ok := helmcfg.KubeClient.(*lifecycleKubeClient); ok {
 		client.timeout = Or(options.Timeout, DefaultTimeout)
 	}
+	renderer := pr
 	pr = newLifecyclePostRenderer(pr)
 	existRelease, err := action.NewGet(helmcfg).Run(rlsname)
 	if err != nil {
 		if !errors.Is(err, driver.ErrReleaseNotFound) {
 			return nil, err
 		}
+		if options.Adopt {
+			if err := adoptResources(ctx, helmcfg, loadedChart, rlsname, namespace, values, renderer); err != nil {
+				return nil, err
+			}
+		}
 		// not install, install it now
 		return installChart(ctx, helmcfg, loadedChart, rlsname, namespace, values, options, pr, desiredState)
 	}
@@ -190,7 +197,28 @@ func equalMapValues(a, b map[string]any) bool {
 	return (len(a) == 0 && len(b) == 0) || reflect.DeepEqual(a, b)
 }
 
-func RemoveChart(ctx context.Context, cfg *rest.Config, rlsname, namespace string, options Options) (*release.Release, error) {
+// ListReleases returns the latest revision of the deployed and failed
+// releases stored in namespace, sorted by name.
+func ListReleases(ctx context.Context, cfg *rest.Config, namespace string) ([]*release.Release, error) {
+	helmcfg, err := NewHelmConfig(ctx, namespace, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return action.NewList(helmcfg).Run()
+}
+
+// ReleaseHistory returns the latest revisions of a release, oldest first.
+func ReleaseHistory(ctx context.Context, cfg *rest.Config, rlsname, namespace string) ([]appsv1.InstanceRevision, error) {
+	helmcfg, err := NewHelmConfig(ctx, namespace, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return releaseHistory(helmcfg, rlsname)
+}
+
+// RemoveChart uninstalls the release, leaving the objects the deletion policy
+// orphans in the cluster.
+func RemoveChart(ctx context.Context, cfg *rest.Config, rlsname, namespace string, options Options, policy appsv1.DeletionPolicy) (*release.Release, error) {
 	log := logr.FromContextOrDiscard(ctx).WithValues("name", rlsname, "namespace", namespace)
 	helmcfg, err := NewHelmConfig(ctx, namespace, cfg)
 	if err != nil {
@@ -204,9 +232,15 @@ func RemoveChart(ctx context.Context, cfg *rest.Config, rlsname, namespace strin
 		return nil, nil
 	}
 
+	if kc, ok := helmcfg.KubeClient.(*lifecycleKubeClient); ok {
+		kc.deletionPolicy = policy
+	}
 	uninstall := action.NewUninstall(helmcfg)
-	uninstall.DisableHooks = options.DisableHooks
+	// nothing is removed when every object is orphaned, neither are hooks run
+	uninstall.DisableHooks = options.DisableHooks || policy == appsv1.DeletionPolicyOrphan
 	uninstall.Wait = options.Wait
+	// workloads terminate once their pods are gone, the removal waits for them
+	uninstall.DeletionPropagation = "foreground"
 	uninstall.Timeout = Or(options.Timeout, DefaultTimeout)
 
 	// For pending states, disable hooks to force cleanup
@@ -5,6 +5,8 @@ import (
 
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/release"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
 )
 
 func TestDesiredReleaseState(t *testing.T) {
@@ -129,3 +131,25 @@ func TestReleaseStatusIsPending(t *testing.T) {
 		}
 	}
 }
+
+func TestReleaseOfKeepsAdoptedRelease(t *testing.T) {
+	instance := install.Instance{
+		Name:      "demo",
+		Namespace: "default",
+		Adopt:     &appsv1.Adopt{ReleaseName: "legacy", ReleaseNamespace: "kube-system"},
+	}
+	name, namespace := releaseOf(instance)
+	if name != "legacy" || namespace != "kube-system" {
+		t.Fatalf("adopted release = %s/%s, want kube-system/legacy", namespace, name)
+	}
+	adopted := releaseStatus(instance, name, namespace)
+
+	// adopt removed after the first sync, the status keeps the release
+	instance.Adopt, instance.Release = nil, adopted
+	if name, namespace := releaseOf(instance); name != "legacy" || namespace != "kube-system" {
+		t.Fatalf("release after adopt removed = %s/%s, want kube-system/legacy", namespace, name)
+	}
+	if got := releaseStatus(instance, "demo", "default"); got != nil {
+		t.Fatalf("release named after the instance recorded as %#v", got)
+	}
+}
@@ -9,14 +9,17 @@ import (
 
 	"helm.sh/helm/v3/pkg/kube"
 	"helm.sh/helm/v3/pkg/postrender"
+	"helm.sh/helm/v3/pkg/release"
 	corev1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/resource"
 	"sigs.k8s.io/yaml"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 	"xiaoshiai.cn/installer/install"
 	"xiaoshiai.cn/installer/utils"
 )
@@ -81,8 +84,12 @@ func (r *lifecyclePostRenderer) Run(in *bytes.Buffer) (*bytes.Buffer, error) {
 // Helm upgrades. Helm's global Force/Recreate option is deliberately not used.
 type lifecycleKubeClient struct {
 	kube.Interface
-	timeout             time.Duration
+	timeout time.Duration
+	// deletionPolicy is the policy of the Instance uninstalled, the objects
+	// it orphans are stripped of their identity instead of deleted.
+	deletionPolicy      appsv1.DeletionPolicy
 	getLiveResourceInfo func(*resource.Info) (*resource.Info, error)
+	patchResource       func(*resource.Info, []byte) error
 }
 
 func newLifecycleKubeClient(delegate kube.Interface) *lifecycleKubeClient {
@@ -90,6 +97,7 @@ func newLifecycleKubeClient(delegate kube.Interface) *lifecycleKubeClient {
 		Interface:           delegate,
 		timeout:             DefaultTimeout,
 		getLiveResourceInfo: liveResourceInfo,
+		patchResource:       patchResource,
 	}
 }
 
@@ -228,6 +236,11 @@ func (c *lifecycleKubeClient) update(original, target kube.ResourceList, force,
 	return result, nil
 }
 
+func patchResource(info *resource.Info, patch []byte) error {
+	_, err := resource.NewHelper(info.Client, info.Mapping).Patch(info.Namespace, info.Name, types.MergePatchType, patch, nil)
+	return err
+}
+
 func liveResourceInfo(info *resource.Info) (*resource.Info, error) {
 	live := *info
 	if err := live.Get(); err != nil {
@@ -269,6 +282,7 @@ func (c *lifecycleKubeClient) WaitForDelete(resources kube.ResourceList, timeout
 	if err != nil {
 		return err
 	}
+	filtered = filtered.Filter(func(info *resource.Info) bool { return !c.orphans(info) })
 	return c.waitForDelete(filtered, timeout)
 }
 
@@ -284,6 +298,9 @@ func (c *lifecycleKubeClient) Delete(resources kube.ResourceList) (*kube.Result,
 	if err != nil {
 		return nil, []error{err}
 	}
+	if filtered, err = c.orphan(filtered); err != nil {
+		return nil, []error{err}
+	}
 	return c.Interface.Delete(filtered)
 }
 
@@ -292,6 +309,9 @@ func (c *lifecycleKubeClient) DeleteWithPropagationPolicy(resources kube.Resourc
 	if err != nil {
 		return nil, []error{err}
 	}
+	if filtered, err = c.orphan(filtered); err != nil {
+		return nil, []error{err}
+	}
 	if delegate, ok := c.Interface.(kube.InterfaceDeletionPropagation); ok {
 		return delegate.DeleteWithPropagationPolicy(filtered, policy)
 	}
@@ -309,6 +329,35 @@ func filterRemoveRetained(resources kube.ResourceList) (kube.ResourceList, error
 	}), nil
 }
 
+// orphanPatch removes the identity of the Instance and of the release from an
+// orphaned object, so neither the label discovery nor a later release of the
+// same name takes it over.
+var orphanPatch = []byte(`{"metadata":{"labels":{"` + install.LabelInstance + `":null,"` + helmManagedByLabel + `":null},` +
+	`"annotations":{"` + helmReleaseNameAnnotation + `":null,"` + helmReleaseNamespaceAnnotation + `":null}}}`)
+
+// orphan strips the resources the deletion policy leaves in the cluster of
+// their identity and returns the others.
+func (c *lifecycleKubeClient) orphan(resources kube.ResourceList) (kube.ResourceList, error) {
+	for _, info := range resources.Filter(c.orphans) {
+		if err := c.patchResource(info, orphanPatch); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("orphan %s: %w", info.String(), err)
+		}
+	}
+	return resources.Filter(func(info *resource.Info) bool { return !c.orphans(info) }), nil
+}
+
+func (c *lifecycleKubeClient) orphans(info *resource.Info) bool {
+	object, err := meta.Accessor(info.Object)
+	if err != nil {
+		return false
+	}
+	// hooks are deleted by their own delete policy
+	if _, ok := object.GetAnnotations()[release.HookAnnotation]; ok {
+		return false
+	}
+	return install.Orphans(c.deletionPolicy, info.Object.GetObjectKind().GroupVersionKind().GroupKind())
+}
+
 // Keep optional kube interfaces available after wrapping the Helm client.
 func (c *lifecycleKubeClient) Get(resources kube.ResourceList, related bool) (map[string][]runtime.Object, error) {
 	delegate, ok := c.Interface.(kube.InterfaceResources)
@@ -13,6 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/cli-runtime/pkg/resource"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 	"xiaoshiai.cn/installer/install"
 	"xiaoshiai.cn/installer/utils"
 )
@@ -312,6 +313,39 @@ func TestLifecycleKubeClientRetainsRemovedResources(t *testing.T) {
 	}
 }
 
+func TestLifecycleKubeClientOrphansData(t *testing.T) {
+	delegate := &recordingHelmClient{}
+	client := newLifecycleKubeClient(delegate)
+	client.deletionPolicy = appsv1.DeletionPolicyOrphanData
+	var patched []string
+	client.patchResource = func(info *resource.Info, patch []byte) error {
+		patched = append(patched, info.Name)
+		if !bytes.Contains(patch, []byte(`"app.kubernetes.io/instance":null`)) {
+			t.Errorf("orphan patch %s keeps the instance label", patch)
+		}
+		return nil
+	}
+	data := helmResource("data", "old", "")
+	data.Object.(*unstructured.Unstructured).SetKind("Secret")
+	ordinary := helmResource("ordinary", "old", "")
+
+	if _, errs := client.DeleteWithPropagationPolicy(kube.ResourceList{data, ordinary}, metav1.DeletePropagationBackground); len(errs) != 0 {
+		t.Fatalf("DeleteWithPropagationPolicy() errors = %v", errs)
+	}
+	if got := resourceNames(delegate.deletes); len(got) != 1 || got[0] != "ordinary" {
+		t.Fatalf("uninstall deletes = %v, want [ordinary]", got)
+	}
+	if len(patched) != 1 || patched[0] != "data" {
+		t.Fatalf("orphaned = %v, want [data]", patched)
+	}
+	if err := client.WaitForDelete(kube.ResourceList{data, ordinary}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := resourceNames(delegate.waitsForDeletes); len(got) != 1 || got[0] != "ordinary" {
+		t.Fatalf("uninstall waits for %v, want [ordinary]", got)
+	}
+}
+
 func resourceNames(resources kube.ResourceList) []string {
 	names := make([]string, 0, len(resources))
 	for _, info := range resources {
@@ -3,6 +3,7 @@ package install
 import (
 	"bytes"
 	"context"
+	"strings"
 	"time"
 
 	"helm.sh/helm/v3/pkg/chart"
@@ -42,6 +43,19 @@ func PostRendererIdentity(renderer PostRenderer) string {
 	return ""
 }
 
+// PostRender runs renderer over the rendered manifests, they are returned
+// as is when renderer is nil.
+func PostRender(renderer PostRenderer, rendered []byte) ([]byte, error) {
+	if renderer == nil {
+		return rendered, nil
+	}
+	out, err := renderer.Run(bytes.NewBuffer(rendered), nil)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
 // PostRendererChain chains multiple PostRenderers sequentially.
 type PostRendererChain []PostRenderer
 
@@ -71,6 +85,25 @@ type Instance struct {
 	Chart      string
 	Path       string
 	Artifact   *appsv1.Artifact
+	// Adopt takes over an existing helm release or existing objects.
+	Adopt *appsv1.Adopt
+	// Release is the helm release adopted by an earlier apply, used when
+	// Adopt does not name one.
+	Release *appsv1.InstanceRelease
+	// Waiting is the stage an earlier apply or removal stopped to wait at,
+	// it is resumed from there.
+	Waiting *appsv1.InstanceWait
+	// Hooks are the hook results of the earlier apply or removal, a resumed
+	// one keeps the results of the hooks it does not run again.
+	Hooks []appsv1.HookResult
+	// Kustomize is an overlay built over the kustomization at Location.
+	Kustomize *appsv1.KustomizeOverlay
+	// Manifests configures the templating of a manifests instance.
+	Manifests *appsv1.ManifestsSource
+	// Jsonnet configures the evaluation of a jsonnet instance.
+	Jsonnet *appsv1.JsonnetSource
+	// Cue configures the evaluation of a cue instance.
+	Cue *appsv1.CueSource
 
 	// Location is the local path where the bundle is located
 	// installer should use this path to apply the bundle if exists
@@ -82,13 +115,44 @@ type Instance struct {
 	UpgradeTimestamp  time.Time
 
 	Options []Option
+	// DeletionPolicy is what Remove does with the objects of the Instance.
+	DeletionPolicy appsv1.DeletionPolicy
 
 	// Auth holds resolved credentials for the chart repository.
 	Auth *ResolvedAuth
+	// TLS holds the TLS settings of the chart repository, the repository
+	// certificate is not verified when nil.
+	TLS *ResolvedTLS
+	// RepositoryIndex is the path of a cached index of the chart repository,
+	// used instead of fetching the index on every download.
+	RepositoryIndex string
 
 	// PostRenderer is an optional post-render pipeline applied to rendered manifests
 	// before they are submitted to Kubernetes.
 	PostRenderer PostRenderer
+
+	// Recorder records the steps of an apply or a removal, nothing is
+	// recorded when nil.
+	Recorder Recorder
+}
+
+// Event types of a Recorder, the types of Kubernetes events.
+const (
+	EventTypeNormal  = "Normal"
+	EventTypeWarning = "Warning"
+)
+
+// Recorder records the steps of an apply or a removal, such as Kubernetes
+// events on the Instance.
+type Recorder interface {
+	Eventf(eventtype, reason, messageFmt string, args ...any)
+}
+
+// Eventf records an event of the apply or removal of the instance.
+func (i Instance) Eventf(eventtype, reason, messageFmt string, args ...any) {
+	if i.Recorder != nil {
+		i.Recorder.Eventf(eventtype, reason, messageFmt, args...)
+	}
 }
 
 // ResolvedAuth contains plain-text repository credentials resolved from the Instance spec.
@@ -97,6 +161,15 @@ type ResolvedAuth struct {
 	Password string
 }
 
+// ResolvedTLS contains the TLS settings of the chart repository with the
+// certificates written to files.
+type ResolvedTLS struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
 type Option = appsv1.Option
 
 type InstanceStatus struct {
@@ -109,6 +182,17 @@ type InstanceStatus struct {
 	CreationTimestamp time.Time
 	UpgradeTimestamp  time.Time
 	Resources         []ManagedResource
+
+	// Description describes the applied revision.
+	Description string
+	// History is the revision history kept by the installer, if any.
+	History []appsv1.InstanceRevision
+	// Adopted lists the existing objects taken over by the apply.
+	Adopted []ManagedResource
+	// Release is the helm release applied, when it differs from the instance.
+	Release *appsv1.InstanceRelease
+	// Hooks lists the lifecycle hooks run by the apply.
+	Hooks []appsv1.HookResult
 }
 
 type ManagedResource = appsv1.ManagedResource
@@ -119,11 +203,91 @@ const (
 	InstanceKindHelm      InstanceKind = "helm"
 	InstanceKindKustomize InstanceKind = "kustomize"
 	InstanceKindTemplate  InstanceKind = "template"
+	InstanceKindManifests InstanceKind = "manifests"
+	InstanceKindJsonnet   InstanceKind = "jsonnet"
+	InstanceKindCue       InstanceKind = "cue"
 )
 
+// LabelInstance is the identity label set on the objects of an Instance, the
+// value is the name of the Instance.
+const LabelInstance = "app.kubernetes.io/instance"
+
+// SchemaError reports values violating the schema of the source.
+type SchemaError struct {
+	Violations []string
+}
+
+func (e *SchemaError) Error() string {
+	return "values violate the schema: " + strings.Join(e.Violations, "; ")
+}
+
+// HookError reports a failed lifecycle hook along with the results of the
+// hooks run up to it.
+type HookError struct {
+	Results []appsv1.HookResult
+	Err     error
+}
+
+func (e *HookError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// WaitingError reports an apply or removal which stopped to wait for its
+// objects instead of blocking, it goes on when run again with Wait.
+type WaitingError struct {
+	Wait    appsv1.InstanceWait
+	Message string
+}
+
+func (e *WaitingError) Error() string {
+	return e.Wait.Stage + ": " + e.Message
+}
+
+// RollbackError reports a failed sync whose changes were rolled back.
+type RollbackError struct {
+	Err error
+}
+
+func (e *RollbackError) Error() string {
+	return "rolled back: " + e.Err.Error()
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// FieldConflict is a field of an object owned with another value by another
+// field manager.
+type FieldConflict struct {
+	Object  appsv1.ManagedResource
+	Manager string
+	Field   string
+}
+
+// ApplyConflictError reports the fields a server-side apply without force
+// could not take over from other field managers.
+type ApplyConflictError struct {
+	Conflicts []FieldConflict
+	Err       error
+}
+
+func (e *ApplyConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ApplyConflictError) Unwrap() error {
+	return e.Err
+}
+
 type Installer interface {
 	Apply(ctx context.Context, bundle Instance) (*InstanceStatus, error)
-	Remove(ctx context.Context, bundle Instance) error
+	// Remove removes the objects of bundle and returns those it deleted,
+	// which may still be terminating.
+	Remove(ctx context.Context, bundle Instance) ([]appsv1.ManagedResource, error)
 
 	Template(ctx context.Context, bundle Instance) ([]byte, error)
 }
@@ -0,0 +1,113 @@
+package jsonnet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/utils"
+)
+
+const defaultEntrypoint = "main.jsonnet"
+
+// defaultLibPaths are the library directories of jsonnet-bundler and of
+// hand vendored libraries, relative to the source directory.
+var defaultLibPaths = []string{"lib", "vendor"}
+
+// JsonnetRenderFunc evaluates the jsonnet program at the instance location
+// and renders the Kubernetes objects of its output.
+func JsonnetRenderFunc(ctx context.Context, instance install.Instance) ([]byte, error) {
+	return Evaluate(instance)
+}
+
+// Evaluate evaluates the jsonnet program at the instance location with the
+// top level values as external variables, and as top level arguments when
+// enabled, and returns the objects of its output as YAML documents.
+func Evaluate(instance install.Instance) ([]byte, error) {
+	root, err := filepath.Abs(instance.Location)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	entrypoint, libPaths, tla := defaultEntrypoint, []string{}, false
+	if options := instance.Jsonnet; options != nil {
+		if options.Entrypoint != "" {
+			entrypoint = options.Entrypoint
+		}
+		libPaths, tla = options.LibPaths, options.TopLevelArguments
+	}
+	// the importer searches the last path first
+	var jpaths []string
+	for _, libPath := range slices.Concat(defaultLibPaths, libPaths) {
+		jpath, err := resolve(root, libPath)
+		if err != nil {
+			return nil, err
+		}
+		jpaths = append(jpaths, jpath)
+	}
+	file, err := resolve(root, entrypoint)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.Importer(&rootedImporter{root: root, importer: &jsonnet.FileImporter{JPaths: jpaths}})
+	for key, value := range instance.Values {
+		code, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("value %s: %w", key, err)
+		}
+		vm.ExtCode(key, string(code))
+		if tla {
+			vm.TLACode(key, string(code))
+		}
+	}
+	output, err := vm.EvaluateFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate %s: %w", entrypoint, err)
+	}
+	var result any
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return nil, err
+	}
+	return utils.ObjectsYAML(result)
+}
+
+// resolve resolves name relative to root, refusing names outside of root.
+func resolve(root, name string) (string, error) {
+	resolved := filepath.Join(root, name)
+	if !within(root, resolved) {
+		return "", fmt.Errorf("path %s is outside of the source", name)
+	}
+	return resolved, nil
+}
+
+func within(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rootedImporter refuses imports resolving outside of the source, a program
+// must not read files of the controller.
+type rootedImporter struct {
+	root     string
+	importer jsonnet.Importer
+}
+
+func (i *rootedImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+	if real, err := filepath.EvalSymlinks(foundAt); err != nil || !within(i.root, real) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %s: outside of the source", importedPath)
+	}
+	return contents, foundAt, nil
+}
@@ -0,0 +1,106 @@
+package jsonnet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/utils"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJsonnetRender(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"vendor/k.libsonnet": `{
+  configMap(name, data): { apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: name }, data: data },
+}`,
+		"main.jsonnet": `local k = import 'k.libsonnet';
+local replicas = std.extVar('replicas');
+{
+  setup: { namespace: { apiVersion: 'v1', kind: 'Namespace', metadata: { name: std.extVar('namespace') } } },
+  apps: [
+    k.configMap('settings', { replicas: std.toString(replicas), domain: std.extVar('ingress').domain }),
+    { apiVersion: 'v1', kind: 'List', items: [k.configMap('listed', {})] },
+  ],
+}`,
+	})
+	out, err := JsonnetRenderFunc(context.Background(), install.Instance{
+		Location: dir,
+		Values:   map[string]any{"namespace": "monitoring", "replicas": float64(2), "ingress": map[string]any{"domain": "example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("render error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, obj := range objects {
+		names = append(names, obj.GetKind()+"/"+obj.GetName())
+	}
+	want := "ConfigMap/settings,ConfigMap/listed,Namespace/monitoring"
+	if got := strings.Join(names, ","); got != want {
+		t.Fatalf("objects = %s, want %s", got, want)
+	}
+	if data := objects[0].Object["data"].(map[string]any); data["replicas"] != "2" || data["domain"] != "example.com" {
+		t.Errorf("settings data = %v", data)
+	}
+}
+
+func TestJsonnetRenderTopLevelArguments(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"stack/example.jsonnet": `function(name, port=80) [
+  { apiVersion: 'v1', kind: 'Service', metadata: { name: name }, spec: { ports: [{ port: port }] } },
+]`,
+	})
+	out, err := Evaluate(install.Instance{
+		Location: dir,
+		Values:   map[string]any{"name": "web", "port": float64(8080)},
+		Jsonnet:  &appsv1.JsonnetSource{Entrypoint: "stack/example.jsonnet", TopLevelArguments: true},
+	})
+	if err != nil {
+		t.Fatalf("evaluate error = %v", err)
+	}
+	if !strings.Contains(string(out), "name: web") || !strings.Contains(string(out), "port: 8080") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestJsonnetRenderRefusesOutsideImports(t *testing.T) {
+	outside := t.TempDir()
+	writeFiles(t, outside, map[string]string{"secret.libsonnet": "{ token: 'secret' }"})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.jsonnet": "local s = import '" + filepath.Join(outside, "secret.libsonnet") + "';\n" +
+			"{ apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: 'leak' }, data: s }",
+	})
+	if _, err := Evaluate(install.Instance{Location: dir}); err == nil {
+		t.Fatal("expected an import outside of the source to fail")
+	}
+	if _, err := Evaluate(install.Instance{Location: dir, Jsonnet: &appsv1.JsonnetSource{LibPaths: []string{"../"}}}); err == nil {
+		t.Fatal("expected a library path outside of the source to fail")
+	}
+	writeFiles(t, dir, map[string]string{"main.jsonnet": "{ value: 'not an object' }"})
+	if _, err := Evaluate(install.Instance{Location: dir}); err == nil {
+		t.Fatal("expected a non-object output to fail")
+	}
+}
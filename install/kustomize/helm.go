@@ -0,0 +1,294 @@
+package kustomize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/download"
+	"xiaoshiai.cn/installer/install/helm"
+)
+
+const (
+	defaultChartHome   = "charts"
+	defaultReleaseName = "release-name"
+)
+
+// chartInflater renders the helmCharts of kustomizations with the helm
+// library and the installer's chart cache instead of the helm binary.
+type chartInflater struct {
+	downloader *download.Downloader
+}
+
+// hasHelmCharts reports whether a kustomization under dir uses helmCharts.
+func hasHelmCharts(dir string) (bool, error) {
+	found := false
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || found {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !slices.Contains(konfig.RecognizedKustomizationFileNames(), entry.Name()) {
+			return nil
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		kustomization := map[string]any{}
+		if err := yaml.Unmarshal(data, &kustomization); err != nil {
+			return fmt.Errorf("parse %s: %w", name, err)
+		}
+		_, found = kustomization["helmCharts"]
+		return nil
+	})
+	return found, err
+}
+
+// inflate replaces the helmCharts of every kustomization under root in memfs
+// with the rendered charts, added as resources.
+func (c *chartInflater) inflate(ctx context.Context, memfs filesys.FileSystem, root string) error {
+	var files []string
+	err := memfs.Walk(root, func(name string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && slices.Contains(konfig.RecognizedKustomizationFileNames(), info.Name()) {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := c.inflateKustomization(ctx, memfs, file); err != nil {
+			return fmt.Errorf("inflate helm charts of %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (c *chartInflater) inflateKustomization(ctx context.Context, memfs filesys.FileSystem, file string) error {
+	data, err := memfs.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	kustomization := map[string]any{}
+	if err := yaml.Unmarshal(data, &kustomization); err != nil {
+		return err
+	}
+	if _, ok := kustomization["helmCharts"]; !ok {
+		return nil
+	}
+	var charts []types.HelmChart
+	if err := convertField(kustomization["helmCharts"], &charts); err != nil {
+		return fmt.Errorf("parse helmCharts: %w", err)
+	}
+	globals := types.HelmGlobals{}
+	if err := convertField(kustomization["helmGlobals"], &globals); err != nil {
+		return fmt.Errorf("parse helmGlobals: %w", err)
+	}
+	dir := path.Dir(file)
+	chartHome := path.Join(dir, defaultChartHome)
+	if globals.ChartHome != "" {
+		chartHome = resolvePath(dir, globals.ChartHome)
+	}
+
+	resources, _ := kustomization["resources"].([]any)
+	for i, helmChart := range charts {
+		manifest, err := c.renderChart(ctx, memfs, dir, chartHome, helmChart)
+		if err != nil {
+			return fmt.Errorf("chart %s: %w", helmChart.Name, err)
+		}
+		name := fmt.Sprintf("helmchart-%d-%s.yaml", i, helmChart.Name)
+		if err := memfs.WriteFile(path.Join(dir, name), manifest); err != nil {
+			return err
+		}
+		resources = append(resources, name)
+	}
+	kustomization["resources"] = resources
+	delete(kustomization, "helmCharts")
+	delete(kustomization, "helmGlobals")
+	out, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+	return memfs.WriteFile(file, out)
+}
+
+func (c *chartInflater) renderChart(ctx context.Context, memfs filesys.FileSystem, dir, chartHome string, helmChart types.HelmChart) ([]byte, error) {
+	loadedChart, err := c.loadChart(ctx, memfs, chartHome, helmChart)
+	if err != nil {
+		return nil, err
+	}
+	values, err := chartValues(memfs, dir, helmChart)
+	if err != nil {
+		return nil, err
+	}
+	client := action.NewInstall(&action.Configuration{})
+	client.DryRun, client.ClientOnly, client.Replace = true, true, true
+	client.ReleaseName = helmChart.ReleaseName
+	if client.ReleaseName == "" {
+		client.ReleaseName = defaultReleaseName
+	}
+	client.Namespace = helmChart.Namespace
+	client.IncludeCRDs = helmChart.IncludeCRDs
+	client.DisableHooks = helmChart.SkipHooks
+	client.APIVersions = helmChart.ApiVersions
+	if helmChart.KubeVersion != "" {
+		kubeVersion, err := chartutil.ParseKubeVersion(helmChart.KubeVersion)
+		if err != nil {
+			return nil, err
+		}
+		client.KubeVersion = kubeVersion
+	}
+	rls, err := client.RunWithContext(ctx, loadedChart, values)
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBufferString(rls.Manifest)
+	if !helmChart.SkipHooks {
+		for _, hook := range rls.Hooks {
+			if helmChart.SkipTests && slices.Contains(hook.Events, release.HookTest) {
+				continue
+			}
+			fmt.Fprintf(out, "\n---\n# Source: %s\n%s", hook.Path, hook.Manifest)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// loadChart loads the chart from chartHome, as kustomize does, or pulls it
+// through the download cache.
+func (c *chartInflater) loadChart(ctx context.Context, memfs filesys.FileSystem, chartHome string, helmChart types.HelmChart) (*chart.Chart, error) {
+	candidates := []string{path.Join(chartHome, helmChart.Name)}
+	if helmChart.Version != "" {
+		candidates = append([]string{path.Join(chartHome, helmChart.Name+"-"+helmChart.Version, helmChart.Name)}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if memfs.IsDir(candidate) {
+			return loadChartFromFs(memfs, candidate)
+		}
+	}
+	if helmChart.Repo == "" {
+		return nil, fmt.Errorf("no repo specified and chart not found in %s", chartHome)
+	}
+	if c.downloader == nil {
+		return nil, fmt.Errorf("downloading charts is not supported")
+	}
+	chartPath, err := c.downloader.Download(ctx, chartSource(helmChart))
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	return loader.Load(chartPath)
+}
+
+// chartSource returns the source of a helmChart pulled from its repo. An OCI
+// repo is a registry path the chart name is appended to, as kustomize does.
+func chartSource(helmChart types.HelmChart) install.Instance {
+	return install.Instance{
+		Repository: helm.ChartURL(helmChart.Repo, helmChart.Name),
+		Chart:      helmChart.Name,
+		Version:    helmChart.Version,
+	}
+}
+
+func loadChartFromFs(memfs filesys.FileSystem, dir string) (*chart.Chart, error) {
+	var files []*loader.BufferedFile
+	err := memfs.Walk(dir, func(name string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := memfs.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, &loader.BufferedFile{Name: strings.TrimPrefix(name, dir+"/"), Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loader.LoadFiles(files)
+}
+
+// chartValues merges the values files and the inline values of helmChart as
+// kustomize does: inline values override the files unless valuesMerge is
+// "merge", which keeps values set in the files, or "replace".
+func chartValues(memfs filesys.FileSystem, dir string, helmChart types.HelmChart) (map[string]any, error) {
+	values := map[string]any{}
+	files := helmChart.AdditionalValuesFiles
+	if helmChart.ValuesFile != "" {
+		files = append([]string{helmChart.ValuesFile}, files...)
+	}
+	for _, file := range files {
+		data, err := memfs.ReadFile(resolvePath(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("read values file: %w", err)
+		}
+		fileValues := map[string]any{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("parse values file %s: %w", file, err)
+		}
+		values = chartutil.CoalesceTables(fileValues, values)
+	}
+	if helmChart.ValuesInline == nil {
+		return values, nil
+	}
+	inline := map[string]any{}
+	if err := convertField(helmChart.ValuesInline, &inline); err != nil {
+		return nil, err
+	}
+	switch helmChart.ValuesMerge {
+	case "replace":
+		return inline, nil
+	case "merge":
+		return chartutil.CoalesceTables(values, inline), nil
+	case "", "override":
+		return chartutil.CoalesceTables(inline, values), nil
+	default:
+		return nil, fmt.Errorf("unknown valuesMerge %q", helmChart.ValuesMerge)
+	}
+}
+
+func resolvePath(dir, name string) string {
+	if path.IsAbs(name) {
+		return name
+	}
+	return path.Join(dir, name)
+}
+
+// convertField converts a field of an untyped kustomization into into.
+func convertField(field any, into any) error {
+	if field == nil {
+		return nil
+	}
+	data, err := json.Marshal(field)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
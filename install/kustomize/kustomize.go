@@ -2,19 +2,78 @@ package kustomize
 
 import (
 	"context"
+	"fmt"
 
 	"sigs.k8s.io/kustomize/api/krusty"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/download"
 )
 
+// NewKustomizeBuildFunc returns a build function pulling the charts of
+// helmCharts through downloader.
+func NewKustomizeBuildFunc(downloader *download.Downloader) func(ctx context.Context, instance install.Instance) ([]byte, error) {
+	return Kustomizer{Downloader: downloader}.Build
+}
+
+// KustomizeBuildFunc builds instances without pulling charts, helmCharts must
+// be vendored under their chartHome.
 func KustomizeBuildFunc(ctx context.Context, instance install.Instance) ([]byte, error) {
-	return KustomizeBuild(ctx, instance.Location)
+	return Kustomizer{}.Build(ctx, instance)
+}
+
+type Kustomizer struct {
+	Downloader *download.Downloader
+}
+
+// Build builds the kustomization at the instance location with the overlay
+// of the instance, inflating helmCharts and substituting variables.
+func (k Kustomizer) Build(ctx context.Context, instance install.Instance) ([]byte, error) {
+	out, err := k.build(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Kustomize != nil && instance.Kustomize.Substitute {
+		return SubstituteVariables(out, ValuesVariables(instance.Values))
+	}
+	return out, nil
+}
+
+func (k Kustomizer) build(ctx context.Context, instance install.Instance) ([]byte, error) {
+	inflate, err := hasHelmCharts(instance.Location)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Kustomize == nil && !inflate {
+		return KustomizeBuild(ctx, instance.Location)
+	}
+	// the generated files are kept in memory, the location may be shared
+	memfs := filesys.MakeFsInMemory()
+	if err := copyToFs(memfs, instance.Location, overlayBaseDir); err != nil {
+		return nil, err
+	}
+	if inflate {
+		if err := (&chartInflater{downloader: k.Downloader}).inflate(ctx, memfs, overlayBaseDir); err != nil {
+			return nil, err
+		}
+	}
+	if instance.Kustomize == nil {
+		return KustomizeBuildFs(ctx, memfs, overlayBaseDir)
+	}
+	if err := writeOverlay(memfs, instance.Kustomize); err != nil {
+		return nil, fmt.Errorf("generate overlay: %w", err)
+	}
+	return KustomizeBuildFs(ctx, memfs, overlayDir)
 }
 
 func KustomizeBuild(ctx context.Context, dir string) ([]byte, error) {
+	return KustomizeBuildFs(ctx, filesys.MakeFsOnDisk(), dir)
+}
+
+// KustomizeBuildFs builds the kustomization in dir of fs.
+func KustomizeBuildFs(ctx context.Context, fs filesys.FileSystem, dir string) ([]byte, error) {
 	k := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
-	m, err := k.Run(filesys.MakeFsOnDisk(), dir)
+	m, err := k.Run(fs, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,333 @@
+package kustomize
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/types"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/download"
+	"xiaoshiai.cn/installer/utils"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const testDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.25
+`
+
+func TestKustomizeBuildWithOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"kustomization.yaml": "resources:\n- deployment.yaml\n",
+		"deployment.yaml":    testDeployment,
+		"components/debug/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1alpha1
+kind: Component
+patches:
+- patch: |-
+    apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: web
+      annotations:
+        debug: "true"
+`,
+	})
+	before, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := KustomizeBuildFunc(context.Background(), install.Instance{
+		Location: dir,
+		Kustomize: &appsv1.KustomizeOverlay{
+			NamePrefix:   "team-",
+			NameSuffix:   "-v2",
+			CommonLabels: map[string]string{"team": "a"},
+			Images:       []appsv1.KustomizeImage{{Name: "nginx", NewName: "registry.example.com/nginx", NewTag: "1.27"}},
+			Patches: []appsv1.KustomizePatch{
+				{Patch: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: 3\n"},
+				{
+					Patch:  `[{"op": "add", "path": "/metadata/annotations/patched", "value": "json6902"}]`,
+					Target: &appsv1.KustomizeSelector{Kind: "Deployment", Name: "web"},
+				},
+			},
+			Components: []string{"components/debug"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("KustomizeBuildFunc() error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("got %d objects, want 1:\n%s", len(objects), out)
+	}
+	deploy := objects[0]
+	if deploy.GetName() != "team-web-v2" {
+		t.Errorf("name = %s, want team-web-v2", deploy.GetName())
+	}
+	if deploy.GetLabels()["team"] != "a" {
+		t.Errorf("labels = %v, want team=a", deploy.GetLabels())
+	}
+	annotations := deploy.GetAnnotations()
+	if annotations["patched"] != "json6902" || annotations["debug"] != "true" {
+		t.Errorf("annotations = %v, want the JSON6902 patch and the component", annotations)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(deploy.Object, "spec", "replicas"); replicas != 3 {
+		t.Errorf("replicas = %d, want 3", replicas)
+	}
+	containers, _, _ := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", "containers")
+	if image := containers[0].(map[string]any)["image"]; image != "registry.example.com/nginx:1.27" {
+		t.Errorf("image = %v", image)
+	}
+
+	// the base on disk is left untouched
+	after, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("base kustomization changed:\n%s", after)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "overlay")); !os.IsNotExist(err) {
+		t.Errorf("overlay written to disk: %v", err)
+	}
+}
+
+func TestKustomizeBuildWithoutOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"kustomization.yaml": "resources:\n- deployment.yaml\n",
+		"deployment.yaml":    testDeployment,
+	})
+	out, err := KustomizeBuildFunc(context.Background(), install.Instance{Location: dir})
+	if err != nil {
+		t.Fatalf("KustomizeBuildFunc() error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].GetName() != "web" {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func writeTestChart(t *testing.T, dir string) {
+	t.Helper()
+	writeFiles(t, dir, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: demo\nversion: 0.1.0\n",
+		"values.yaml": "greeting: hello\nreplicas: 1\n",
+		"templates/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-demo
+data:
+  greeting: {{ .Values.greeting | quote }}
+  replicas: {{ .Values.replicas | quote }}
+`,
+		"templates/hook.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-test
+  annotations:
+    helm.sh/hook: test
+`,
+		"crds/widget.yaml": `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+`,
+	})
+}
+
+func TestKustomizeBuildInflatesHelmCharts(t *testing.T) {
+	remote := t.TempDir()
+	writeTestChart(t, remote)
+	dir := t.TempDir()
+	writeTestChart(t, filepath.Join(dir, "charts", "demo"))
+	writeFiles(t, dir, map[string]string{
+		"values-extra.yaml": "greeting: from-file\nreplicas: 2\n",
+		"kustomization.yaml": `helmCharts:
+- name: demo
+  releaseName: local
+  includeCRDs: true
+  skipTests: true
+  valuesFile: values-extra.yaml
+  valuesInline:
+    replicas: 3
+- name: demo
+  repo: file://` + remote + `
+  releaseName: remote
+`,
+	})
+
+	out, err := NewKustomizeBuildFunc(download.NewDownloader(t.TempDir()))(context.Background(), install.Instance{Location: dir})
+	if err != nil {
+		t.Fatalf("build error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]map[string]any{}
+	for _, obj := range objects {
+		byName[obj.GetName()] = obj.Object
+	}
+	if _, ok := byName["widgets.example.com"]; !ok {
+		t.Errorf("CRD not included:\n%s", out)
+	}
+	if _, ok := byName["local-test"]; ok {
+		t.Errorf("test hook not skipped:\n%s", out)
+	}
+	if _, ok := byName["remote-test"]; !ok {
+		t.Errorf("hook of the downloaded chart missing:\n%s", out)
+	}
+	local, _, _ := unstructured.NestedStringMap(byName["local-demo"], "data")
+	if local["greeting"] != "from-file" || local["replicas"] != "3" {
+		t.Errorf("local chart data = %v, want values file overridden inline", local)
+	}
+	remoteData, _, _ := unstructured.NestedStringMap(byName["remote-demo"], "data")
+	if remoteData["greeting"] != "hello" {
+		t.Errorf("downloaded chart data = %v, want chart defaults", remoteData)
+	}
+
+	// the kustomization on disk still uses helmCharts
+	data, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "helmCharts") {
+		t.Errorf("kustomization on disk rewritten:\n%s", data)
+	}
+}
+
+func TestChartSource(t *testing.T) {
+	for _, tt := range []struct {
+		repo string
+		want string
+	}{
+		{repo: "oci://registry.example.com/charts", want: "oci://registry.example.com/charts/demo"},
+		{repo: "oci://registry.example.com/charts/", want: "oci://registry.example.com/charts/demo"},
+		{repo: "https://charts.example.com", want: "https://charts.example.com"},
+	} {
+		got := chartSource(types.HelmChart{Name: "demo", Version: "1.0.0", Repo: tt.repo})
+		if got.Repository != tt.want || got.Chart != "demo" || got.Version != "1.0.0" {
+			t.Errorf("chartSource(%q) = %q %q %q, want %q", tt.repo, got.Repository, got.Chart, got.Version, tt.want)
+		}
+	}
+}
+
+func TestKustomizeBuildSubstitutesVariables(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"kustomization.yaml": "resources:\n- configmap.yaml\n- script.yaml\n- deployment.yaml\n",
+		"deployment.yaml":    strings.Replace(testDeployment, "replicas: 1", "replicas: ${replicas}", 1),
+		"configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  domain: ${domain}
+  tag: ${image_tag}
+  replicas: "${replicas}"
+  region: ${region:=us-east-1}
+  shell: echo $HOME $${domain}
+`,
+		"script.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: script
+  annotations:
+    apps.xiaoshiai.cn/substitute: disabled
+data:
+  run: echo ${domain}
+`,
+	})
+	out, err := KustomizeBuildFunc(context.Background(), install.Instance{
+		Location:  dir,
+		Values:    map[string]any{"domain": "example.com", "replicas": float64(3), "image": map[string]any{"tag": "v1.2"}},
+		Kustomize: &appsv1.KustomizeOverlay{Substitute: true},
+	})
+	if err != nil {
+		t.Fatalf("build error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]map[string]string{}
+	var replicas any
+	for _, obj := range objects {
+		if obj.GetKind() == "Deployment" {
+			replicas, _, _ = unstructured.NestedFieldNoCopy(obj.Object, "spec", "replicas")
+			continue
+		}
+		data[obj.GetName()], _, _ = unstructured.NestedStringMap(obj.Object, "data")
+	}
+	if replicas != int64(3) {
+		t.Errorf("deployment replicas = %#v, want 3", replicas)
+	}
+	want := map[string]string{
+		"domain":   "example.com",
+		"tag":      "v1.2",
+		"replicas": "3",
+		"region":   "us-east-1",
+		"shell":    "echo $HOME ${domain}",
+	}
+	for key, value := range want {
+		if data["settings"][key] != value {
+			t.Errorf("settings %s = %q, want %q", key, data["settings"][key], value)
+		}
+	}
+	if data["script"]["run"] != "echo ${domain}" {
+		t.Errorf("disabled resource substituted: %q", data["script"]["run"])
+	}
+}
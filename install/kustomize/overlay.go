@@ -0,0 +1,98 @@
+package kustomize
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/yaml"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+// the overlay is a sibling of the base, kustomize refuses a base containing
+// the kustomization referring to it
+const (
+	overlayBaseDir = "/base"
+	overlayDir     = "/overlay"
+)
+
+// writeOverlay writes an overlay applying overlay over the copy of the
+// kustomization at overlayBaseDir into memfs.
+func writeOverlay(memfs filesys.FileSystem, overlay *appsv1.KustomizeOverlay) error {
+	kustomization, err := yaml.Marshal(overlayKustomization(overlay))
+	if err != nil {
+		return err
+	}
+	if err := memfs.MkdirAll(overlayDir); err != nil {
+		return err
+	}
+	return memfs.WriteFile(path.Join(overlayDir, "kustomization.yaml"), kustomization)
+}
+
+func overlayKustomization(overlay *appsv1.KustomizeOverlay) *types.Kustomization {
+	base := path.Join("..", path.Base(overlayBaseDir))
+	k := &types.Kustomization{
+		TypeMeta:     types.TypeMeta{APIVersion: types.KustomizationVersion, Kind: types.KustomizationKind},
+		Resources:    []string{base},
+		NamePrefix:   overlay.NamePrefix,
+		NameSuffix:   overlay.NameSuffix,
+		CommonLabels: overlay.CommonLabels,
+	}
+	for _, image := range overlay.Images {
+		k.Images = append(k.Images, types.Image{
+			Name:    image.Name,
+			NewName: image.NewName,
+			NewTag:  image.NewTag,
+			Digest:  image.Digest,
+		})
+	}
+	for _, patch := range overlay.Patches {
+		p := types.Patch{Patch: patch.Patch}
+		if target := patch.Target; target != nil {
+			p.Target = &types.Selector{
+				ResId: resid.ResId{
+					Gvk:       resid.Gvk{Group: target.Group, Version: target.Version, Kind: target.Kind},
+					Name:      target.Name,
+					Namespace: target.Namespace,
+				},
+				LabelSelector:      target.LabelSelector,
+				AnnotationSelector: target.AnnotationSelector,
+			}
+		}
+		k.Patches = append(k.Patches, p)
+	}
+	for _, component := range overlay.Components {
+		k.Components = append(k.Components, path.Join(base, component))
+	}
+	return k
+}
+
+// copyToFs copies the files under dir on disk into into of memfs, skipping
+// version control metadata.
+func copyToFs(memfs filesys.FileSystem, dir, into string) error {
+	return filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		target := path.Join(into, filepath.ToSlash(rel))
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return memfs.MkdirAll(target)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return memfs.WriteFile(target, data)
+	})
+}
@@ -0,0 +1,178 @@
+package kustomize
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/drone/envsubst"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// AnnotationSubstitute set to SubstituteDisabled keeps ${VAR} placeholders
+	// of a resource.
+	AnnotationSubstitute = "apps.xiaoshiai.cn/substitute"
+	SubstituteDisabled   = "disabled"
+)
+
+// ValuesVariables returns the substitution variables of values. Scalars are
+// named by their keys joined with "_", lists are skipped.
+func ValuesVariables(values map[string]any) map[string]string {
+	vars := map[string]string{}
+	flattenVariables(vars, "", values)
+	return vars
+}
+
+func flattenVariables(vars map[string]string, prefix string, values map[string]any) {
+	for key, value := range values {
+		name := key
+		if prefix != "" {
+			name = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flattenVariables(vars, name, v)
+		case []any:
+		case nil:
+			vars[name] = ""
+		case string:
+			vars[name] = v
+		case float64:
+			vars[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			vars[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// SubstituteVariables replaces the ${VAR} placeholders in the string values
+// of the resources of manifests with vars, unset variables are replaced with
+// their default (${VAR:=default}) or the empty string. Bare $VAR is left as
+// is, as are resources annotated with AnnotationSubstitute disabled.
+//
+// A value made of a single placeholder takes the type of the substituted
+// text, so "replicas: ${replicas}" becomes a number, except in the string
+// maps of ConfigMaps, Secrets and metadata which always stay strings.
+func SubstituteVariables(manifests []byte, vars map[string]string) ([]byte, error) {
+	nodes, err := kio.FromBytes(manifests)
+	if err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	for _, node := range nodes {
+		if node.GetAnnotations()[AnnotationSubstitute] != SubstituteDisabled {
+			if err := substituteResource(node, vars); err != nil {
+				return nil, fmt.Errorf("substitute %s %s: %w", node.GetKind(), node.GetName(), err)
+			}
+		}
+		data, err := node.String()
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.WriteString(data)
+	}
+	return out.Bytes(), nil
+}
+
+func substituteResource(node *kyaml.RNode, vars map[string]string) error {
+	kind := node.GetKind()
+	root := node.YNode()
+	if root.Kind != kyaml.MappingNode {
+		return substituteNode(root, false, vars)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch {
+		case key == "metadata" && value.Kind == kyaml.MappingNode:
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				field := value.Content[j].Value
+				if err := substituteNode(value.Content[j+1], field == "labels" || field == "annotations", vars); err != nil {
+					return err
+				}
+			}
+		case (kind == "ConfigMap" || kind == "Secret") && (key == "data" || key == "stringData" || key == "binaryData"):
+			if err := substituteNode(value, true, vars); err != nil {
+				return err
+			}
+		default:
+			if err := substituteNode(value, false, vars); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// substituteNode substitutes the string scalars under node, map keys are
+// left as is.
+func substituteNode(node *kyaml.Node, keepString bool, vars map[string]string) error {
+	switch node.Kind {
+	case kyaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := substituteNode(node.Content[i], keepString, vars); err != nil {
+				return err
+			}
+		}
+	case kyaml.SequenceNode, kyaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := substituteNode(child, keepString, vars); err != nil {
+				return err
+			}
+		}
+	case kyaml.ScalarNode:
+		if node.Tag != kyaml.NodeTagString || !strings.Contains(node.Value, "$") {
+			return nil
+		}
+		value, err := Substitute(node.Value, vars)
+		if err != nil {
+			return err
+		}
+		if !keepString && value != "" && isPlaceholder(node.Value) {
+			// resolve the type of the substituted text as if written plain
+			resolved := &kyaml.Node{Kind: kyaml.ScalarNode, Value: value}
+			node.Tag, node.Style = resolved.ShortTag(), 0
+		}
+		node.Value = value
+	}
+	return nil
+}
+
+// Substitute replaces the ${VAR} placeholders of text with vars, unset
+// variables are replaced with their default (${VAR:=default}) or the empty
+// string. Bare $VAR is left as is and $${VAR} keeps the placeholder.
+func Substitute(text string, vars map[string]string) (string, error) {
+	return envsubst.Eval(escapeBareDollars(text), func(name string) string {
+		return vars[name]
+	})
+}
+
+// isPlaceholder reports whether s is a single ${VAR} placeholder.
+func isPlaceholder(s string) bool {
+	return strings.HasPrefix(s, "${") && strings.Index(s, "}") == len(s)-1
+}
+
+// escapeBareDollars escapes every $ not starting a ${VAR} placeholder or an
+// escaped $$, so only braced placeholders are substituted.
+func escapeBareDollars(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch {
+		case i+1 < len(s) && s[i+1] == '{':
+			b.WriteByte('$')
+		case i+1 < len(s) && s[i+1] == '$':
+			b.WriteString("$$")
+			i++
+		default:
+			b.WriteString("$$")
+		}
+	}
+	return b.String()
+}
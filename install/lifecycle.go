@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 )
 
 const (
@@ -51,3 +53,23 @@ func ValidateLifecycleStrategies(obj metav1.Object) error {
 	_, err := RemoveStrategy(obj)
 	return err
 }
+
+// dataKinds are the kinds kept by the OrphanData deletion policy.
+var dataKinds = map[schema.GroupKind]bool{
+	{Kind: "PersistentVolumeClaim"}: true,
+	{Kind: "Secret"}:                true,
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}: true,
+}
+
+// Orphans reports whether the removal of an Instance with the deletion policy
+// leaves the objects of gk in the cluster.
+func Orphans(policy appsv1.DeletionPolicy, gk schema.GroupKind) bool {
+	switch policy {
+	case appsv1.DeletionPolicyOrphan:
+		return true
+	case appsv1.DeletionPolicyOrphanData:
+		return dataKinds[gk]
+	default:
+		return false
+	}
+}
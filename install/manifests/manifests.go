@@ -0,0 +1,169 @@
+package manifests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Masterminds/sprig/v3"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/kustomize"
+)
+
+// ManifestsRenderFunc reads the YAML and JSON files at the instance
+// location, a directory or a single file, and renders them with the
+// templating of the instance.
+func ManifestsRenderFunc(ctx context.Context, instance install.Instance) ([]byte, error) {
+	files, err := manifestFiles(instance.Location)
+	if err != nil {
+		return nil, err
+	}
+	templating := appsv1.ManifestsTemplatingNone
+	if instance.Manifests != nil && instance.Manifests.Templating != "" {
+		templating = instance.Manifests.Templating
+	}
+	out := bytes.NewBuffer(nil)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		name, err := filepath.Rel(instance.Location, file)
+		if err != nil || name == "." {
+			name = filepath.Base(file)
+		}
+		rendered, err := render(templating, name, data, instance)
+		if err != nil {
+			return nil, fmt.Errorf("render %s: %w", name, err)
+		}
+		fmt.Fprintf(out, "---\n# Source: %s\n%s\n", filepath.ToSlash(name), rendered)
+	}
+	return out.Bytes(), nil
+}
+
+// manifestFiles returns the YAML and JSON files under location in lexical
+// order, hidden files and directories are skipped.
+func manifestFiles(location string) ([]string, error) {
+	fi, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{location}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(location, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != location && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && isManifest(name) {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no YAML or JSON files found in %s", location)
+	}
+	return files, nil
+}
+
+func isManifest(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func render(templating appsv1.ManifestsTemplating, name string, data []byte, instance install.Instance) ([]byte, error) {
+	switch templating {
+	case appsv1.ManifestsTemplatingNone:
+		return data, nil
+	case appsv1.ManifestsTemplatingEnvsubst:
+		out, err := kustomize.Substitute(string(data), kustomize.ValuesVariables(instance.Values))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(out), nil
+	case appsv1.ManifestsTemplatingGoTemplate:
+		funcs := sprig.TxtFuncMap()
+		funcs[noValueFunc] = func(value any) any {
+			if value == nil {
+				return ""
+			}
+			return value
+		}
+		tpl, err := template.New(name).Option("missingkey=zero").Funcs(funcs).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tpl.Templates() {
+			if t.Tree != nil {
+				emptyNoValues(t.Tree, t.Tree.Root)
+			}
+		}
+		values := instance.Values
+		if values == nil {
+			values = map[string]any{}
+		}
+		out := bytes.NewBuffer(nil)
+		if err := tpl.Execute(out, map[string]any{
+			"Values":  values,
+			"Release": map[string]any{"Name": instance.Name, "Namespace": instance.Namespace},
+		}); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown templating %q", templating)
+	}
+}
+
+// noValueFunc is the template function emptyNoValues pipes actions to.
+const noValueFunc = "installerNoValue"
+
+// emptyNoValues pipes the output of every action under node to noValueFunc,
+// so missing values render empty rather than as "<no value>" while the same
+// text in the manifest or in a value is kept.
+func emptyNoValues(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			emptyNoValues(tree, child)
+		}
+	case *parse.ActionNode:
+		// declarations and assignments print nothing
+		if len(n.Pipe.Decl) == 0 {
+			ident := parse.NewIdentifier(noValueFunc).SetTree(tree).SetPos(n.Pos)
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: n.Pos, Args: []parse.Node{ident}})
+		}
+	case *parse.IfNode:
+		emptyNoValues(tree, n.List)
+		emptyNoValues(tree, n.ElseList)
+	case *parse.RangeNode:
+		emptyNoValues(tree, n.List)
+		emptyNoValues(tree, n.ElseList)
+	case *parse.WithNode:
+		emptyNoValues(tree, n.List)
+		emptyNoValues(tree, n.ElseList)
+	}
+}
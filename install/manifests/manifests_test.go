@@ -0,0 +1,125 @@
+package manifests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/utils"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func renderObjects(t *testing.T, instance install.Instance) []*unstructured.Unstructured {
+	t.Helper()
+	out, err := ManifestsRenderFunc(context.Background(), instance)
+	if err != nil {
+		t.Fatalf("render error = %v", err)
+	}
+	objects, err := utils.SplitYAML(out)
+	if err != nil {
+		t.Fatalf("split error = %v\n%s", err, out)
+	}
+	return objects
+}
+
+func TestManifestsRenderDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"namespace.yaml":        "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: operator\n",
+		"crds/crd.json":         `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "from-json"}}`,
+		"rbac/accounts.yml":     "apiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: b\n",
+		"README.md":             "not a manifest",
+		".github/workflow.yaml": "name: ci\n",
+	})
+	objects := renderObjects(t, install.Instance{Location: dir})
+	var names []string
+	for _, obj := range objects {
+		names = append(names, obj.GetName())
+	}
+	want := []string{"from-json", "operator", "a", "b"}
+	if len(names) != len(want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("names = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestManifestsRenderTemplating(t *testing.T) {
+	const configMap = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n"
+	tests := []struct {
+		name       string
+		templating appsv1.ManifestsTemplating
+		data       string
+		want       map[string]string
+	}{
+		{
+			name: "none",
+			data: "  domain: ${domain}\n",
+			want: map[string]string{"domain": "${domain}"},
+		},
+		{
+			name:       "envsubst",
+			templating: appsv1.ManifestsTemplatingEnvsubst,
+			data:       "  domain: ${domain}\n  tag: ${image_tag:=latest}\n  shell: echo $HOME\n",
+			want:       map[string]string{"domain": "example.com", "tag": "latest", "shell": "echo $HOME"},
+		},
+		{
+			name:       "go template",
+			templating: appsv1.ManifestsTemplatingGoTemplate,
+			data:       "  domain: {{ .Values.domain | upper }}\n  release: {{ .Release.Name }}\n  missing: \"{{ .Values.missing }}\"\n",
+			want:       map[string]string{"domain": "EXAMPLE.COM", "release": "demo", "missing": ""},
+		},
+		{
+			name:       "go template keeps literal no value",
+			templating: appsv1.ManifestsTemplatingGoTemplate,
+			data:       "  literal: \"<no value>\"\n  missing: \"{{ .Values.missing }}{{ $x := 1 }}{{ if true }}{{ .Values.absent }}{{ end }}\"\n  domain: \"{{ .Values.domain }}\"\n",
+			want:       map[string]string{"literal": "<no value>", "missing": "", "domain": "example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "configmap.yaml")
+			writeFiles(t, filepath.Dir(file), map[string]string{"configmap.yaml": configMap + tt.data})
+			objects := renderObjects(t, install.Instance{
+				Name:      "demo",
+				Location:  file,
+				Values:    map[string]any{"domain": "example.com"},
+				Manifests: &appsv1.ManifestsSource{Templating: tt.templating},
+			})
+			if len(objects) != 1 {
+				t.Fatalf("got %d objects, want 1", len(objects))
+			}
+			data, _, _ := unstructured.NestedStringMap(objects[0].Object, "data")
+			for key, value := range tt.want {
+				if data[key] != value {
+					t.Errorf("%s = %q, want %q", key, data[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestManifestsRenderEmptyDirectory(t *testing.T) {
+	if _, err := ManifestsRenderFunc(context.Background(), install.Instance{Location: t.TempDir()}); err == nil {
+		t.Fatal("expected an error for a directory without manifests")
+	}
+}
@@ -0,0 +1,103 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+// adoptableManager reports whether the fields of manager are taken over on
+// adoption: kubectl and helm client-side writes. Fields written by controllers
+// are left to them.
+func adoptableManager(manager string) bool {
+	return strings.HasPrefix(manager, "kubectl") || manager == "before-first-apply" || manager == "helm"
+}
+
+// AdoptResources takes over the existing objects in resources which are not
+// managed yet, moving the fields written by client-side apply to fieldOwner
+// so the next server-side apply removes fields no longer rendered instead of
+// keeping them. The adopted objects are returned.
+func AdoptResources(ctx context.Context, cli client.Client, resources []*unstructured.Unstructured, fieldOwner string) ([]appsv1.ManagedResource, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	if fieldOwner == "" {
+		fieldOwner = DefaultFieldOwner
+	}
+	var adopted []appsv1.ManagedResource
+	for _, item := range resources {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(item.GroupVersionKind())
+		if err := cli.Get(ctx, client.ObjectKeyFromObject(item), live); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			continue
+		}
+		managers := sets.New[string]()
+		for _, entry := range live.GetManagedFields() {
+			if entry.Operation == metav1.ManagedFieldsOperationUpdate && entry.Subresource == "" && adoptableManager(entry.Manager) {
+				managers.Insert(entry.Manager)
+			}
+		}
+		ref := appsv1.GetReference(item)
+		patch, err := csaupgrade.UpgradeManagedFieldsPatch(live, managers, fieldOwner)
+		if err != nil {
+			return nil, fmt.Errorf("adopt %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		if patch != nil {
+			if err := cli.Patch(ctx, live, client.RawPatch(types.JSONPatchType, patch)); err != nil {
+				return nil, fmt.Errorf("adopt %s %s: %w", ref.Kind, ref.Name, err)
+			}
+		}
+		log.Info("adopted existing object", "kind", ref.Kind, "name", ref.Name, "namespace", ref.Namespace, "managers", sets.List(managers))
+		adopted = append(adopted, ref)
+	}
+	return adopted, nil
+}
+
+// managedBy reports whether obj has fields owned by one of managers.
+func managedBy(obj client.Object, managers []string) bool {
+	for _, entry := range obj.GetManagedFields() {
+		if entry.Subresource == "" && slices.Contains(managers, entry.Manager) {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateManagedFields moves the fields of live owned by the legacy field
+// managers to manager, so the fields they applied which are no longer
+// rendered are removed by the next apply of manager instead of being kept.
+func migrateManagedFields(ctx context.Context, cli client.Client, live client.Object, legacy []string, manager string) error {
+	managers := sets.New[string]()
+	var entries []metav1.ManagedFieldsEntry
+	for _, entry := range live.GetManagedFields() {
+		if entry.Subresource == "" && entry.Manager != manager && slices.Contains(legacy, entry.Manager) {
+			// applied and created fields alike are merged as client-side writes
+			managers.Insert(entry.Manager)
+			entry.Operation = metav1.ManagedFieldsOperationUpdate
+		}
+		entries = append(entries, entry)
+	}
+	if managers.Len() == 0 {
+		return nil
+	}
+	obj, _ := live.DeepCopyObject().(client.Object)
+	obj.SetManagedFields(entries)
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(obj, managers, manager)
+	if err != nil || patch == nil {
+		return err
+	}
+	logr.FromContextOrDiscard(ctx).Info("migrating field manager", "kind", obj.GetObjectKind().GroupVersionKind().Kind,
+		"name", obj.GetName(), "namespace", obj.GetNamespace(), "from", sets.List(managers), "to", manager)
+	return cli.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+}
@@ -0,0 +1,99 @@
+package native
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+func TestApplyAdoptsExistingResources(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewClientBuilder().WithReturnManagedFields().Build()
+	// created as kubectl create would, managed with an Update operation
+	live := testResource("settings", "old", nil)
+	live.Object["data"] = map[string]any{"value": "old", "stale": "true"}
+	if err := cli.Create(ctx, live, client.FieldOwner("kubectl-create")); err != nil {
+		t.Fatalf("create live object: %v", err)
+	}
+	// written by a controller, must keep its fields
+	if err := cli.Patch(ctx, live.DeepCopy(), client.RawPatch(types.MergePatchType,
+		[]byte(`{"metadata":{"annotations":{"controller":"owned"}}}`)), client.FieldOwner("some-controller")); err != nil {
+		t.Fatalf("patch live object: %v", err)
+	}
+
+	apply := New(cli, func(context.Context, install.Instance) ([]byte, error) {
+		return []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: default\ndata:\n  value: new\n"), nil
+	})
+	status, err := apply.Apply(ctx, install.Instance{Name: "demo", Namespace: "default", Adopt: &appsv1.Adopt{}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(status.Adopted) != 1 || status.Adopted[0].Name != "settings" {
+		t.Fatalf("adopted = %#v, want settings", status.Adopted)
+	}
+	if status.Description != "Adopted 1 existing objects" {
+		t.Fatalf("description = %q", status.Description)
+	}
+	if len(status.Resources) != 1 {
+		t.Fatalf("resources = %#v, want settings", status.Resources)
+	}
+
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(configMapGVK)
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(live), got); err != nil {
+		t.Fatalf("get adopted object: %v", err)
+	}
+	data, _, _ := unstructured.NestedStringMap(got.Object, "data")
+	if data["value"] != "new" {
+		t.Fatalf("data = %#v, want the rendered value", data)
+	}
+	if _, ok := data["stale"]; ok {
+		t.Fatalf("field written by kubectl was kept: %#v", data)
+	}
+	if got.GetAnnotations()["controller"] != "owned" {
+		t.Fatalf("field written by a controller was removed: %#v", got.GetAnnotations())
+	}
+	for _, entry := range got.GetManagedFields() {
+		if entry.Manager == "kubectl-create" {
+			t.Fatalf("kubectl-create still manages fields: %#v", got.GetManagedFields())
+		}
+		if entry.Manager == DefaultFieldOwner && entry.Operation != metav1.ManagedFieldsOperationApply {
+			t.Fatalf("%s operation = %s, want Apply", DefaultFieldOwner, entry.Operation)
+		}
+	}
+}
+
+func TestApplyWithoutAdoptKeepsClientSideFields(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewClientBuilder().WithReturnManagedFields().Build()
+	live := testResource("settings", "old", nil)
+	live.Object["data"] = map[string]any{"value": "old", "stale": "true"}
+	if err := cli.Create(ctx, live, client.FieldOwner("kubectl-create")); err != nil {
+		t.Fatalf("create live object: %v", err)
+	}
+	apply := New(cli, func(context.Context, install.Instance) ([]byte, error) {
+		return []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: default\ndata:\n  value: new\n"), nil
+	})
+	status, err := apply.Apply(ctx, install.Instance{Name: "demo", Namespace: "default"})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(status.Adopted) != 0 {
+		t.Fatalf("adopted = %#v, want none", status.Adopted)
+	}
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(configMapGVK)
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(live), got); err != nil {
+		t.Fatalf("get object: %v", err)
+	}
+	if data, _, _ := unstructured.NestedStringMap(got.Object, "data"); data["stale"] != "true" {
+		t.Fatalf("data = %#v, want the kubectl field kept", data)
+	}
+}
@@ -75,6 +75,10 @@ func NewDefaultSyncOptions() *SyncOptions {
 		CreateNamespace: true,
 		CleanCRD:        false,
 		DeleteTimeout:   2 * time.Minute,
+		WaveTimeout:     DefaultWaveTimeout,
+		HookTimeout:     DefaultHookTimeout,
+		Concurrency:     DefaultConcurrency,
+		Force:           true,
 	}
 }
 
@@ -85,6 +89,52 @@ type SyncOptions struct {
 	// DeleteTimeout bounds foreground deletion for resources using the
 	// Recreate upgrade strategy.
 	DeleteTimeout time.Duration
+	// WaveTimeout bounds the wait for the resources of an apply wave to be
+	// ready, and for the resources of a removed wave to be gone. The sync
+	// does not block on a wave, it stops with an install.WaitingError and is
+	// resumed with Waiting.
+	WaveTimeout time.Duration
+	// HookTimeout bounds the wait for a Job or Pod hook to complete.
+	HookTimeout time.Duration
+	// Transactional dry-runs the objects before the sync, stops it at the
+	// first error and rolls the objects back to their previous state. A sync
+	// waiting for a wave is not rolled back, a resumed sync rolls back to the
+	// state the waiting one left.
+	Transactional bool
+	// Concurrency bounds the objects of a wave applied at once.
+	Concurrency int
+	// FieldManager is the field manager of the server-side apply,
+	// DefaultFieldOwner when empty.
+	FieldManager string
+	// Force takes over the fields owned with another value by other field
+	// managers, otherwise they fail the apply as conflicts.
+	Force bool
+	// DeletePropagation is the propagation policy of the deletes, the server
+	// default when empty.
+	DeletePropagation metav1.DeletionPropagation
+	// Recorder records the objects created and deleted by the sync.
+	Recorder install.Recorder
+	// Waiting is the stage an earlier sync stopped to wait at.
+	Waiting *appsv1.InstanceWait
+	// Hooks are the hook results of the earlier sync, a resumed sync keeps
+	// the results of the hooks it does not run again.
+	Hooks []appsv1.HookResult
+}
+
+func (o *SyncOptions) fieldManager() string {
+	if o.FieldManager == "" {
+		return DefaultFieldOwner
+	}
+	return o.FieldManager
+}
+
+func (o *SyncOptions) applyOptions() ApplyOptions {
+	options := ApplyOptions{ServerSideApply: o.ServerSideApply, FieldOwner: o.fieldManager(), Force: o.Force}
+	if options.FieldOwner != DefaultFieldOwner {
+		// objects applied before the field manager was configurable
+		options.MigrateFrom = []string{DefaultFieldOwner}
+	}
+	return options
 }
 
 type ClientApply struct {
@@ -136,92 +186,244 @@ func (a *ClientApply) SyncDiff(ctx context.Context, diff DiffResult, options *Sy
 		}
 		diff.Removes[i] = live
 	}
+	applyWaves, err := groupWaves(append(append([]*unstructured.Unstructured{}, diff.Creats...), diff.Applys...))
+	if err != nil {
+		return nil, err
+	}
+	removeWaves, err := groupWaves(diff.Removes)
+	if err != nil {
+		return nil, err
+	}
+	if options.WaveTimeout <= 0 {
+		options.WaveTimeout = DefaultWaveTimeout
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	var snapshots []snapshot
+	if options.Transactional {
+		if err := a.dryRun(ctx, diff, options); err != nil {
+			return nil, err
+		}
+		if snapshots, err = a.snapshot(ctx, diff); err != nil {
+			return nil, err
+		}
+	}
 
-	errs := []string{}
+	errs := []error{}
+	// waiting is the wait for a wave which stopped the sync
+	var waiting *install.WaitingError
+	// waitFor stops the sync at stage when items are pending, or fails it
+	// once they were pending for the wave timeout
+	waitFor := func(stage, state string, pending []string, err error) {
+		if err != nil {
+			err = fmt.Errorf("%s: %w", stage, err)
+		} else if len(pending) > 0 {
+			err = waitAt(stage, state, pending, options, options.WaveTimeout)
+		}
+		if err == nil || errors.As(err, &waiting) {
+			return
+		}
+		log.Error(err, "waiting for wave", "stage", stage)
+		errs = append(errs, err)
+	}
 
 	managed := []appsv1.ManagedResource{}
-	// create
+	// create and apply, wave by wave
+	created := map[*unstructured.Unstructured]bool{}
 	for _, item := range diff.Creats {
-		log.Info("creating resource", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
-		if options.CreateNamespace {
-			a.createNsIfNotExists(ctx, item.GetNamespace())
-		}
-		if err := ApplyResource(ctx, a.Client, item, ApplyOptions{ServerSideApply: options.ServerSideApply}); err != nil {
-			err = fmt.Errorf("%s %s/%s: %v", item.GetObjectKind().GroupVersionKind().String(), item.GetNamespace(), item.GetName(), err)
-			log.Error(err, "creating resource")
-			errs = append(errs, err.Error())
-			continue
+		created[item] = true
+	}
+	// keepManaged keeps the resources already managed of items which are not applied
+	keepManaged := func(items []*unstructured.Unstructured) {
+		for _, item := range items {
+			if !created[item] {
+				managed = append(managed, appsv1.GetReference(item))
+			}
 		}
-		managed = append(managed, appsv1.GetReference(item)) // set managed
 	}
-
-	// apply
-	for _, item := range diff.Applys {
-		managed = append(managed, appsv1.GetReference(item)) // set managed
-
-		if IsSkipUpdate(item) {
-			log.Info("ignoring update", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
+	resume := resumeAt(options.Waiting)
+	for i, wave := range applyWaves {
+		if len(errs) > 0 || waiting != nil {
+			// a failed or waiting wave stops the later ones, resources already managed stay managed
+			keepManaged(wave.items)
 			continue
 		}
-		if IsRecreateUpdate(item) {
-			log.Info("recreating resource", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
-			if options.CreateNamespace {
-				a.createNsIfNotExists(ctx, item.GetNamespace())
+		stage := fmt.Sprintf("wave %d", wave.number)
+		batches := splitBatches(wave.items)
+		for j, batch := range batches {
+			if options.Transactional && len(errs) > 0 || waiting != nil {
+				keepManaged(batch)
+				continue
 			}
-			if err := a.recreateResource(ctx, item, options.DeleteTimeout); err != nil {
-				err = fmt.Errorf("%s %s/%s: %v", item.GetObjectKind().GroupVersionKind().String(), item.GetNamespace(), item.GetName(), err)
-				log.Error(err, "recreating resource")
-				errs = append(errs, err.Error())
+			if resume.applied(wave.number, batchStage(batch[0]), batch, created) {
+				// applied before the sync waited
+				keepManaged(batch)
+			} else {
+				batchManaged, batchErrs := a.applyBatch(ctx, batch, created, options)
+				managed = append(managed, batchManaged...)
+				errs = append(errs, batchErrs...)
+			}
+			// custom resources of the wave require the definitions to be established
+			if IsCRD(batch[0]) && j+1 < len(batches) && len(errs) == 0 {
+				pending, err := a.notReady(ctx, batch)
+				waitFor(stage+" definitions", "ready", pending, err)
 			}
-			continue
-		}
-
-		log.Info("applying resource", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
-		if options.CreateNamespace {
-			a.createNsIfNotExists(ctx, item.GetNamespace())
 		}
-		if err := ApplyResource(ctx, a.Client, item, ApplyOptions{ServerSideApply: options.ServerSideApply}); err != nil {
-			err = fmt.Errorf("%s %s/%s: %v", item.GetObjectKind().GroupVersionKind().String(), item.GetNamespace(), item.GetName(), err)
-			log.Error(err, "applying resource")
-			errs = append(errs, err.Error())
-			continue
+		if len(errs) == 0 && waiting == nil && i+1 < len(applyWaves) {
+			log.Info("checking wave", "wave", wave.number)
+			pending, err := a.notReady(ctx, wave.items)
+			waitFor(stage, "ready", pending, err)
 		}
 	}
-	// remove
-	for _, item := range diff.Removes {
-		if IsCRD(item) && !options.CleanCRD {
+	// remove, in the reverse order of apply
+	removeWaves = reverseWaves(removeWaves)
+	removed := 0
+	for i, wave := range removeWaves {
+		if waiting != nil {
+			// removed once the sync is resumed
+			for _, item := range wave.items {
+				managed = append(managed, appsv1.GetReference(item))
+			}
 			continue
 		}
-		if IsSkipDelete(item) {
-			log.Info("ignoring delete", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
-			continue
+		if options.Transactional && len(errs) > 0 {
+			break
 		}
-		partial := item
-		log.Info("deleting resource", "resource", partial.GetObjectKind().GroupVersionKind().String(), "name", partial.GetName(), "namespace", partial.GetNamespace())
-		if err := a.Client.Delete(ctx, partial, &client.DeleteOptions{}); err != nil {
-			if !apierrors.IsNotFound(err) {
-				err = fmt.Errorf("%s %s/%s: %v", partial.GetObjectKind().GroupVersionKind().String(), partial.GetNamespace(), partial.GetName(), err)
-				log.Error(err, "deleting resource")
-				errs = append(errs, err.Error())
-				// if not removed, keep in managed
-				managed = append(managed, appsv1.GetReference(item)) // set managed
+		var deleted []*unstructured.Unstructured
+		for _, item := range wave.items {
+			if IsCRD(item) && !options.CleanCRD {
+				continue
+			}
+			if IsSkipDelete(item) {
+				log.Info("ignoring delete", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
 				continue
 			}
+			partial := item
+			log.Info("deleting resource", "resource", partial.GetObjectKind().GroupVersionKind().String(), "name", partial.GetName(), "namespace", partial.GetNamespace())
+			deleteOptions := &client.DeleteOptions{}
+			if options.DeletePropagation != "" {
+				deleteOptions.PropagationPolicy = &options.DeletePropagation
+			}
+			if err := a.Client.Delete(ctx, partial, deleteOptions); err != nil {
+				if !apierrors.IsNotFound(err) {
+					err = describeError(partial, err)
+					log.Error(err, "deleting resource")
+					errs = append(errs, err)
+					// if not removed, keep in managed
+					managed = append(managed, appsv1.GetReference(item)) // set managed
+				}
+				continue
+			}
+			deleted = append(deleted, item)
+			removed++
+		}
+		if len(deleted) == 0 || i+1 == len(removeWaves) {
+			continue
 		}
+		// earlier waves are deleted once this one is gone
+		failed := len(errs)
+		pending, err := a.notDeleted(ctx, deleted)
+		waitFor(fmt.Sprintf("wave %d removal", wave.number), "deleted", pending, err)
+		if waiting != nil {
+			// checked again when the sync is resumed
+			for _, item := range deleted {
+				managed = append(managed, appsv1.GetReference(item))
+			}
+		} else if len(errs) > failed {
+			for _, rest := range removeWaves[i+1:] {
+				for _, item := range rest.items {
+					managed = append(managed, appsv1.GetReference(item))
+				}
+			}
+			break
+		}
+	}
+
+	syncErr := errors.Join(errs...)
+	if conflicts := fieldConflicts(errs); len(conflicts) > 0 {
+		syncErr = &install.ApplyConflictError{Conflicts: conflicts, Err: syncErr}
 	}
+	if options.Transactional && syncErr != nil {
+		log.Error(syncErr, "rolling back sync")
+		if err := a.rollback(ctx, snapshots, options); err != nil {
+			// objects of both states may be left
+			return mergeManaged(managed, previouslyManaged(diff)), errors.Join(syncErr, fmt.Errorf("rollback: %w", err))
+		}
+		return previouslyManaged(diff), &install.RollbackError{Err: syncErr}
+	}
+	a.recordSync(diff, managed, removed, options)
 
 	// sort manged
 	sort.Slice(managed, func(i, j int) bool {
 		return strings.Compare(managed[i].APIVersion, managed[j].APIVersion) == 1
 	})
-	if len(errs) > 0 {
-		return managed, errors.New(strings.Join(errs, "\n"))
-	} else {
-		return managed, nil
+	if syncErr == nil && waiting != nil {
+		log.Info("waiting for wave", "stage", waiting.Wait.Stage, "since", waiting.Wait.Since)
+		return managed, waiting
+	}
+	return managed, syncErr
+}
+
+// recordSync records the number of objects created and deleted by a sync.
+func (a *ClientApply) recordSync(diff DiffResult, managed []appsv1.ManagedResource, removed int, options *SyncOptions) {
+	if options.Recorder == nil {
+		return
+	}
+	isManaged := make(map[appsv1.ManagedResource]bool, len(managed))
+	for _, ref := range managed {
+		isManaged[ref] = true
+	}
+	created := 0
+	for _, item := range diff.Creats {
+		if isManaged[appsv1.GetReference(item)] {
+			created++
+		}
+	}
+	if created == 0 && removed == 0 {
+		return
 	}
+	options.Recorder.Eventf(install.EventTypeNormal, "ResourcesSynced", "Created %d and deleted %d objects", created, removed)
 }
 
-func (a *ClientApply) recreateResource(ctx context.Context, obj *unstructured.Unstructured, timeout time.Duration) error {
+func (a *ClientApply) createResource(ctx context.Context, item *unstructured.Unstructured, live liveObjects, options *SyncOptions) error {
+	log := logr.FromContextOrDiscard(ctx)
+	log.Info("creating resource", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
+	if err := a.apply(ctx, item, live, options); err != nil {
+		err = describeError(item, err)
+		log.Error(err, "creating resource")
+		return err
+	}
+	return nil
+}
+
+func (a *ClientApply) applyResource(ctx context.Context, item *unstructured.Unstructured, live liveObjects, options *SyncOptions) error {
+	log := logr.FromContextOrDiscard(ctx)
+	if IsSkipUpdate(item) {
+		log.Info("ignoring update", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
+		return nil
+	}
+	if IsRecreateUpdate(item) {
+		log.Info("recreating resource", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
+		if err := a.recreateResource(ctx, item, options); err != nil {
+			err = describeError(item, err)
+			log.Error(err, "recreating resource")
+			return err
+		}
+		return nil
+	}
+
+	log.Info("applying resource", "resource", item.GetObjectKind().GroupVersionKind().String(), "name", item.GetName(), "namespace", item.GetNamespace())
+	if err := a.apply(ctx, item, live, options); err != nil {
+		err = describeError(item, err)
+		log.Error(err, "applying resource")
+		return err
+	}
+	return nil
+}
+
+func (a *ClientApply) recreateResource(ctx context.Context, obj *unstructured.Unstructured, options *SyncOptions) error {
+	timeout := options.DeleteTimeout
 	if timeout <= 0 {
 		timeout = 2 * time.Minute
 	}
@@ -229,7 +431,7 @@ func (a *ClientApply) recreateResource(ctx context.Context, obj *unstructured.Un
 	err := a.Client.Get(ctx, client.ObjectKeyFromObject(obj), live)
 	switch {
 	case apierrors.IsNotFound(err):
-		return a.Client.Create(ctx, obj)
+		return a.Client.Create(ctx, obj, client.FieldOwner(options.fieldManager()))
 	case err != nil:
 		return fmt.Errorf("get before recreate: %w", err)
 	}
@@ -250,7 +452,7 @@ func (a *ClientApply) recreateResource(ctx context.Context, obj *unstructured.Un
 	}
 	obj.SetResourceVersion("")
 	obj.SetUID("")
-	return a.Client.Create(ctx, obj)
+	return a.Client.Create(ctx, obj, client.FieldOwner(options.fieldManager()))
 }
 
 func (a *ClientApply) createNsIfNotExists(ctx context.Context, name string) error {
@@ -262,14 +464,23 @@ func (a *ClientApply) createNsIfNotExists(ctx context.Context, name string) erro
 	return err
 }
 
+// DefaultFieldOwner is the field manager used to apply native resources when
+// none is set, and the one of all Instances before it was configurable.
+const DefaultFieldOwner = "bundler"
+
 type ApplyOptions struct {
 	ServerSideApply bool
 	FieldOwner      string
+	// Force takes over the fields conflicting with other field managers.
+	Force bool
+	// MigrateFrom are former field managers of the object, their fields are
+	// moved to FieldOwner before the server-side apply.
+	MigrateFrom []string
 }
 
 func ApplyResource(ctx context.Context, cli client.Client, obj client.Object, options ApplyOptions) error {
 	if options.FieldOwner == "" {
-		options.FieldOwner = "bundler"
+		options.FieldOwner = DefaultFieldOwner
 	}
 
 	exists, _ := obj.DeepCopyObject().(client.Object)
@@ -277,25 +488,43 @@ func ApplyResource(ctx context.Context, cli client.Client, obj client.Object, op
 		if !apierrors.IsNotFound(err) {
 			return err
 		}
-		return cli.Create(ctx, obj)
+		exists = nil
+	}
+	return applyLive(ctx, cli, obj, exists, options)
+}
+
+// applyLive creates obj when exists is nil, and patches it otherwise. A
+// server-side apply only requires the metadata of exists.
+func applyLive(ctx context.Context, cli client.Client, obj, exists client.Object, options ApplyOptions) error {
+	if options.FieldOwner == "" {
+		options.FieldOwner = DefaultFieldOwner
+	}
+	if exists == nil {
+		return cli.Create(ctx, obj, client.FieldOwner(options.FieldOwner))
 	}
 
 	var patch client.Patch
 	var patchoptions []client.PatchOption
 	if options.ServerSideApply {
+		// only objects applied by a former field manager are migrated
+		if managedBy(exists, options.MigrateFrom) {
+			if err := migrateManagedFields(ctx, cli, exists, options.MigrateFrom, options.FieldOwner); err != nil {
+				return fmt.Errorf("migrate field manager: %w", err)
+			}
+		}
 		obj.SetManagedFields(nil)
 		patch = client.Apply
-		patchoptions = append(patchoptions,
-			client.FieldOwner(options.FieldOwner),
-			client.ForceOwnership,
-		)
+		patchoptions = append(patchoptions, client.FieldOwner(options.FieldOwner))
+		if options.Force {
+			patchoptions = append(patchoptions, client.ForceOwnership)
+		}
 	} else {
 		patch = client.StrategicMergeFrom(exists)
 	}
 
 	// patch
 	if err := cli.Patch(ctx, obj, patch, patchoptions...); err != nil {
-		return err
+		return conflictError(obj, err)
 	}
 	return nil
 }
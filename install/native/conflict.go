@@ -0,0 +1,52 @@
+package native
+
+import (
+	"errors"
+	"regexp"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// conflictManager matches the manager in the message of a field manager
+// conflict: conflict with "kube-controller-manager" using apps/v1.
+var conflictManager = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// conflictError returns the field manager conflicts of a server-side apply of
+// obj as an *install.ApplyConflictError, other errors as they are.
+func conflictError(obj client.Object, err error) error {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		return err
+	}
+	var conflicts []install.FieldConflict
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflict := install.FieldConflict{Object: appsv1.GetReference(obj), Field: cause.Field}
+		if match := conflictManager.FindStringSubmatch(cause.Message); match != nil {
+			conflict.Manager = match[1]
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	if len(conflicts) == 0 {
+		return err
+	}
+	return &install.ApplyConflictError{Conflicts: conflicts, Err: err}
+}
+
+// fieldConflicts returns the conflicts of errs, in their order.
+func fieldConflicts(errs []error) []install.FieldConflict {
+	var conflicts []install.FieldConflict
+	for _, err := range errs {
+		var conflictErr *install.ApplyConflictError
+		if errors.As(err, &conflictErr) {
+			conflicts = append(conflicts, conflictErr.Conflicts...)
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,137 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"xiaoshiai.cn/installer/install"
+)
+
+func managersOf(t *testing.T, cli client.Client, name string) map[string]bool {
+	t.Helper()
+	cm := &corev1.ConfigMap{}
+	if err := cli.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, cm); err != nil {
+		t.Fatal(err)
+	}
+	managers := map[string]bool{}
+	for _, entry := range cm.ManagedFields {
+		managers[entry.Manager] = true
+	}
+	return managers
+}
+
+func TestSyncDiffReportsApplyConflicts(t *testing.T) {
+	ctx := context.Background()
+	cli := namespacedClientBuilder().WithReturnManagedFields().Build()
+	if err := cli.Patch(ctx, testResource("settings", "edited", nil), client.Apply, client.FieldOwner("kubectl-edit")); err != nil {
+		t.Fatal(err)
+	}
+	options := testSyncOptions()
+	options.FieldManager = "web"
+	options.Force = false
+	diff := DiffResult{Applys: []*unstructured.Unstructured{testResource("settings", "rendered", nil)}}
+	_, err := (&ClientApply{Client: cli}).SyncDiff(ctx, diff, options)
+	var conflictErr *install.ApplyConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("SyncDiff() error = %v, want an apply conflict", err)
+	}
+	if len(conflictErr.Conflicts) != 1 {
+		t.Fatalf("conflicts = %+v", conflictErr.Conflicts)
+	}
+	if conflict := conflictErr.Conflicts[0]; conflict.Manager != "kubectl-edit" || conflict.Field != ".data.value" || conflict.Object.Name != "settings" {
+		t.Errorf("conflict = %+v", conflict)
+	}
+
+	options.Force = true
+	diff = DiffResult{Applys: []*unstructured.Unstructured{testResource("settings", "rendered", nil)}}
+	if _, err := (&ClientApply{Client: cli}).SyncDiff(ctx, diff, options); err != nil {
+		t.Fatalf("SyncDiff() with force error = %v", err)
+	}
+}
+
+func TestSyncDiffMigratesFieldManager(t *testing.T) {
+	ctx := context.Background()
+	cli := namespacedClientBuilder().WithReturnManagedFields().Build()
+	if err := cli.Create(ctx, testResource("created", "old", nil), client.FieldOwner(DefaultFieldOwner)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Patch(ctx, testResource("applied", "old", nil), client.Apply, client.FieldOwner(DefaultFieldOwner)); err != nil {
+		t.Fatal(err)
+	}
+	options := testSyncOptions()
+	options.FieldManager = "web"
+	options.Force = false
+	diff := DiffResult{Applys: []*unstructured.Unstructured{testResource("created", "new", nil), testResource("applied", "new", nil)}}
+	// the fields of the former manager are taken over without conflicts
+	if _, err := (&ClientApply{Client: cli}).SyncDiff(ctx, diff, options); err != nil {
+		t.Fatalf("SyncDiff() error = %v", err)
+	}
+	for _, name := range []string{"created", "applied"} {
+		if managers := managersOf(t, cli, name); managers[DefaultFieldOwner] || !managers["web"] {
+			t.Errorf("%s managers = %v, want web only", name, managers)
+		}
+	}
+}
+
+func TestParseFieldManagerOptions(t *testing.T) {
+	options := []install.Option{{Name: "fieldManager", Value: "platform"}, {Name: "force", Value: "false"}}
+	sync, err := ParseSyncOptions(context.Background(), options)
+	if err != nil || sync.FieldManager != "platform" || sync.Force {
+		t.Fatalf("ParseSyncOptions() = %+v, %v", sync, err)
+	}
+	if got := FieldManagerOf("web", options); got != "platform" {
+		t.Errorf("FieldManagerOf() = %q, want platform", got)
+	}
+	if got := FieldManagerOf("web", nil); got != "web" {
+		t.Errorf("FieldManagerOf() = %q, want the instance name", got)
+	}
+	if sync, _ := ParseSyncOptions(context.Background(), nil); !sync.Force {
+		t.Error("force is off by default")
+	}
+}
+
+// dryRunClient runs the dry-runs against shadow, a copy of the objects of the
+// client, as the fake client does not dry-run patches.
+type dryRunClient struct {
+	client.Client
+	shadow client.Client
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if !slices.Contains(opts, client.PatchOption(client.DryRunAll)) {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	opts = slices.DeleteFunc(slices.Clone(opts), func(opt client.PatchOption) bool { return opt == client.DryRunAll })
+	return c.shadow.Patch(ctx, obj.DeepCopyObject().(client.Object), patch, opts...)
+}
+
+func TestSyncDiffDryRunReportsConflicts(t *testing.T) {
+	ctx := context.Background()
+	cli := &dryRunClient{
+		Client: namespacedClientBuilder().WithReturnManagedFields().Build(),
+		shadow: namespacedClientBuilder().WithReturnManagedFields().Build(),
+	}
+	for _, c := range []client.Client{cli.Client, cli.shadow} {
+		if err := c.Patch(ctx, testResource("settings", "edited", nil), client.Apply, client.FieldOwner("kubectl-edit")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := transactionalOptions()
+	options.FieldManager = "web"
+	options.Force = false
+	diff := DiffResult{Applys: []*unstructured.Unstructured{testResource("settings", "rendered", nil)}}
+	_, err := (&ClientApply{Client: cli}).SyncDiff(ctx, diff, options)
+	var conflictErr *install.ApplyConflictError
+	if !errors.As(err, &conflictErr) || !strings.HasPrefix(err.Error(), "dry-run") {
+		t.Fatalf("SyncDiff() error = %v, want the dry-run to report the conflict", err)
+	}
+	if managers := managersOf(t, cli, "settings"); managers["web"] {
+		t.Errorf("managers = %v, want the conflicting object unchanged", managers)
+	}
+}
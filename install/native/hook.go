@@ -0,0 +1,379 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"helm.sh/helm/v3/pkg/release"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// Hook annotations, the helm.sh/hook annotations of helm charts are
+// accepted as well.
+const (
+	AnnotationHook             = "apps.xiaoshiai.cn/hook"
+	AnnotationHookWeight       = "apps.xiaoshiai.cn/hook-weight"
+	AnnotationHookDeletePolicy = "apps.xiaoshiai.cn/hook-delete-policy"
+)
+
+// Hook events of native instances.
+const (
+	HookPreApply   = "pre-apply"
+	HookPostApply  = "post-apply"
+	HookPreDelete  = "pre-delete"
+	HookPostDelete = "post-delete"
+)
+
+// Hook delete policies, before-hook-creation applies when none is set.
+const (
+	HookBeforeHookCreation = string(release.HookBeforeHookCreation)
+	HookSucceeded          = string(release.HookSucceeded)
+	HookFailed             = string(release.HookFailed)
+)
+
+// DefaultHookTimeout is the default of SyncOptions.HookTimeout.
+const DefaultHookTimeout = 5 * time.Minute
+
+// knownHookEvents are the accepted events, helm events without a native
+// counterpart are accepted but never run.
+var knownHookEvents = []string{
+	HookPreApply, HookPostApply, HookPreDelete, HookPostDelete,
+	string(release.HookPreInstall), string(release.HookPostInstall),
+	string(release.HookPreUpgrade), string(release.HookPostUpgrade),
+	string(release.HookPreRollback), string(release.HookPostRollback),
+	string(release.HookTest), "test-success",
+}
+
+// Hook is an object created at lifecycle events of the instance rather than
+// managed with the other objects.
+type Hook struct {
+	Object         *unstructured.Unstructured
+	Events         []string
+	Weight         int
+	DeletePolicies []string
+}
+
+// IsHook reports whether obj is annotated as a hook.
+func IsHook(obj client.Object) bool {
+	_, ok := hookAnnotation(obj, AnnotationHook, release.HookAnnotation)
+	return ok
+}
+
+// SplitHooks separates the hooks from the objects of resources.
+func SplitHooks(resources []*unstructured.Unstructured) ([]*unstructured.Unstructured, []Hook, error) {
+	var objects []*unstructured.Unstructured
+	var hooks []Hook
+	for _, item := range resources {
+		if !IsHook(item) {
+			objects = append(objects, item)
+			continue
+		}
+		hook, err := parseHook(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return objects, hooks, nil
+}
+
+func parseHook(obj *unstructured.Unstructured) (Hook, error) {
+	hook := Hook{Object: obj}
+	events, _ := hookAnnotation(obj, AnnotationHook, release.HookAnnotation)
+	for _, event := range splitList(events) {
+		if !slices.Contains(knownHookEvents, event) {
+			return hook, fmt.Errorf("invalid hook event %q on %s", event, describe(obj))
+		}
+		hook.Events = append(hook.Events, event)
+	}
+	if weight, ok := hookAnnotation(obj, AnnotationHookWeight, release.HookWeightAnnotation); ok {
+		value, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil {
+			return hook, fmt.Errorf("invalid hook weight %q on %s", weight, describe(obj))
+		}
+		hook.Weight = value
+	}
+	policies, _ := hookAnnotation(obj, AnnotationHookDeletePolicy, release.HookDeleteAnnotation)
+	for _, policy := range splitList(policies) {
+		switch policy {
+		case HookBeforeHookCreation, HookSucceeded, HookFailed:
+			hook.DeletePolicies = append(hook.DeletePolicies, policy)
+		default:
+			return hook, fmt.Errorf("invalid hook delete policy %q on %s", policy, describe(obj))
+		}
+	}
+	if len(hook.DeletePolicies) == 0 {
+		hook.DeletePolicies = []string{HookBeforeHookCreation}
+	}
+	return hook, nil
+}
+
+// hookAnnotation returns the annotation key of obj, falling back to the helm
+// annotation.
+func hookAnnotation(obj client.Object, key, helmKey string) (string, bool) {
+	annotations := obj.GetAnnotations()
+	if value, ok := annotations[key]; ok {
+		return value, true
+	}
+	value, ok := annotations[helmKey]
+	return value, ok
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// runsAt reports whether the hook runs at event, helm install and upgrade
+// events run at the apply of a new or an existing instance respectively.
+func (h Hook) runsAt(event string, installing bool) bool {
+	aliases := []string{event}
+	switch {
+	case event == HookPreApply && installing:
+		aliases = append(aliases, string(release.HookPreInstall))
+	case event == HookPreApply:
+		aliases = append(aliases, string(release.HookPreUpgrade))
+	case event == HookPostApply && installing:
+		aliases = append(aliases, string(release.HookPostInstall))
+	case event == HookPostApply:
+		aliases = append(aliases, string(release.HookPostUpgrade))
+	}
+	for _, alias := range aliases {
+		if slices.Contains(h.Events, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunHooks runs the hooks of event by ascending weight. Jobs and Pods must
+// complete before the next hook runs: a hook still running or terminating
+// stops the run with an *install.HookError wrapping an *install.WaitingError,
+// the sync resumes at that hook with options.Waiting and keeps the results
+// of the hooks before it from options.Hooks. The first failed hook
+// stops the run with an *install.HookError holding the results so far.
+func (a *ClientApply) RunHooks(ctx context.Context, hooks []Hook, event string, installing bool, options *SyncOptions) ([]appsv1.HookResult, error) {
+	if options == nil {
+		options = NewDefaultSyncOptions()
+	}
+	timeout := options.HookTimeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	selected := selectHooks(hooks, event, installing)
+	// the hooks before the one waited for completed already
+	var results []appsv1.HookResult
+	resuming := false
+	if waiting := options.Waiting; waiting != nil {
+		for i, hook := range selected {
+			if hookStage(event, hook.Object) == waiting.Stage {
+				results = earlierResults(options.Hooks, selected[:i], event)
+				selected, resuming = selected[i:], true
+				break
+			}
+		}
+	}
+
+	for i, hook := range selected {
+		result, err := a.runHook(ctx, hook, event, options, timeout, resuming && i == 0)
+		results = append(results, result)
+		var waitErr *install.WaitingError
+		if errors.As(err, &waitErr) {
+			return results, &install.HookError{Results: results, Err: err}
+		}
+		if err != nil {
+			return results, &install.HookError{Results: results, Err: fmt.Errorf("%s hook %s: %w", event, describe(hook.Object), err)}
+		}
+	}
+	return results, nil
+}
+
+// selectHooks returns the hooks run at event by ascending weight.
+func selectHooks(hooks []Hook, event string, installing bool) []Hook {
+	var selected []Hook
+	for _, hook := range hooks {
+		if hook.runsAt(event, installing) {
+			selected = append(selected, hook)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].Weight != selected[j].Weight {
+			return selected[i].Weight < selected[j].Weight
+		}
+		return selected[i].Object.GetName() < selected[j].Object.GetName()
+	})
+	return selected
+}
+
+// earlierResults returns the results of hooks at event recorded in earlier.
+// The hooks completed before the sync waited, one without a recorded result
+// is reported succeeded.
+func earlierResults(earlier []appsv1.HookResult, hooks []Hook, event string) []appsv1.HookResult {
+	var results []appsv1.HookResult
+	for _, hook := range hooks {
+		result := appsv1.HookResult{Name: hook.Object.GetName(), Kind: hook.Object.GetKind(), Event: event, Phase: appsv1.HookPhaseSucceeded}
+		for _, recorded := range earlier {
+			if recorded.Event == event && recorded.Kind == result.Kind && recorded.Name == result.Name {
+				result = recorded
+				break
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// hookStage is the wait stage of the hook obj at event.
+func hookStage(event string, obj client.Object) string {
+	return fmt.Sprintf("%s hook %s", event, describe(obj))
+}
+
+// waitsAtHook reports whether waiting is at a hook of event.
+func waitsAtHook(waiting *appsv1.InstanceWait, event string) bool {
+	return waiting != nil && strings.HasPrefix(waiting.Stage, event+" hook ")
+}
+
+// runHook creates the hook and checks it once for completion. A resumed hook
+// is created only if it is gone, otherwise its completion is checked again.
+func (a *ClientApply) runHook(ctx context.Context, hook Hook, event string, options *SyncOptions, timeout time.Duration, resuming bool) (appsv1.HookResult, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	obj := hook.Object.DeepCopy()
+	stage := hookStage(event, obj)
+	result := appsv1.HookResult{Name: obj.GetName(), Kind: obj.GetKind(), Event: event, Phase: appsv1.HookPhaseRunning}
+	fail := func(err error) (appsv1.HookResult, error) {
+		result.Phase, result.Message, result.CompletedAt = appsv1.HookPhaseFailed, err.Error(), metav1.Now()
+		if slices.Contains(hook.DeletePolicies, HookFailed) {
+			if err := a.deleteHook(ctx, obj); err != nil {
+				log.Error(err, "deleting failed hook", "hook", describe(obj))
+			}
+		}
+		return result, err
+	}
+	waitFor := func(state string) (appsv1.HookResult, error) {
+		err := waitAt(stage, state, []string{describe(obj) + " to be " + state}, options, timeout)
+		var waitErr *install.WaitingError
+		if errors.As(err, &waitErr) {
+			return result, err
+		}
+		return fail(fmt.Errorf("not %s after %s", state, timeout))
+	}
+
+	live, err := a.getHook(ctx, obj)
+	if err != nil {
+		return fail(err)
+	}
+	created := resuming && live != nil && live.GetDeletionTimestamp() == nil
+	if created {
+		result.StartedAt = options.Waiting.Since
+	} else {
+		if slices.Contains(hook.DeletePolicies, HookBeforeHookCreation) && live != nil {
+			if err := a.deleteHook(ctx, obj); err != nil {
+				return fail(err)
+			}
+			if live, err = a.getHook(ctx, obj); err != nil {
+				return fail(err)
+			}
+			if live != nil {
+				return waitFor("deleted")
+			}
+		}
+		log.Info("running hook", "event", event, "resource", obj.GroupVersionKind().String(), "name", obj.GetName(), "namespace", obj.GetNamespace())
+		if options.CreateNamespace {
+			a.createNsIfNotExists(ctx, obj.GetNamespace())
+		}
+		result.StartedAt = metav1.Now()
+		if err := ApplyResource(ctx, a.Client, obj, options.applyOptions()); err != nil {
+			return fail(err)
+		}
+	}
+	// Job and Pod hooks must complete, other hooks are done once created
+	if kind := obj.GroupVersionKind().GroupKind().String(); kind == "Job.batch" || kind == "Pod" {
+		live, err := a.getHook(ctx, obj)
+		if err != nil {
+			return fail(err)
+		}
+		completed := false
+		if live != nil {
+			if completed, err = hookCompleted(live); err != nil {
+				return fail(err)
+			}
+		}
+		if !completed {
+			return waitFor("completed")
+		}
+	}
+	result.Phase, result.CompletedAt = appsv1.HookPhaseSucceeded, metav1.Now()
+	if slices.Contains(hook.DeletePolicies, HookSucceeded) {
+		if err := a.deleteHook(ctx, obj); err != nil {
+			log.Error(err, "deleting succeeded hook", "hook", describe(obj))
+		}
+	}
+	return result, nil
+}
+
+// getHook returns the live object of the hook obj, nil if it does not exist.
+func (a *ClientApply) getHook(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := a.Client.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return live, nil
+}
+
+func (a *ClientApply) deleteHook(ctx context.Context, obj *unstructured.Unstructured) error {
+	// the pods of a job hook go along with it
+	propagation := metav1.DeletePropagationBackground
+	if err := a.Client.Delete(ctx, obj.DeepCopy(), &client.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func hookCompleted(live *unstructured.Unstructured) (bool, error) {
+	if live.GetKind() == "Pod" {
+		phase, _, _ := unstructured.NestedString(live.Object, "status", "phase")
+		switch phase {
+		case "Succeeded":
+			return true, nil
+		case "Failed":
+			message, _, _ := unstructured.NestedString(live.Object, "status", "message")
+			return false, fmt.Errorf("pod failed: %s", message)
+		}
+		return false, nil
+	}
+	conditions, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+	for _, condition := range conditions {
+		c, ok := condition.(map[string]any)
+		if !ok || c["status"] != "True" {
+			continue
+		}
+		switch c["type"] {
+		case "Complete":
+			return true, nil
+		case "Failed":
+			return false, fmt.Errorf("job failed: %v", c["message"])
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,185 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// jobStatusClient reports the jobs it gets with the given condition, running
+// without one.
+type jobStatusClient struct {
+	client.Client
+	condition string
+}
+
+func (c *jobStatusClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.Client.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok && u.GetKind() == "Job" && c.condition != "" {
+		condition := map[string]any{"type": c.condition, "status": "True", "message": "BackoffLimitExceeded"}
+		unstructured.SetNestedSlice(u.Object, []any{condition}, "status", "conditions")
+	}
+	return nil
+}
+
+func hookJob(name string, annotations map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]any{"name": name, "namespace": "default"},
+	}}
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func TestSplitHooks(t *testing.T) {
+	resources := []*unstructured.Unstructured{
+		testResource("settings", "", nil),
+		hookJob("migrate", map[string]string{
+			"helm.sh/hook":               "pre-install,pre-upgrade",
+			"helm.sh/hook-weight":        "-5",
+			"helm.sh/hook-delete-policy": "hook-succeeded",
+		}),
+		hookJob("smoke", map[string]string{AnnotationHook: "post-apply"}),
+	}
+	objects, hooks, err := SplitHooks(resources)
+	if err != nil {
+		t.Fatalf("SplitHooks() error = %v", err)
+	}
+	if len(objects) != 1 || objects[0].GetName() != "settings" || len(hooks) != 2 {
+		t.Fatalf("objects = %d, hooks = %d", len(objects), len(hooks))
+	}
+	if migrate := hooks[0]; migrate.Weight != -5 || strings.Join(migrate.DeletePolicies, ",") != HookSucceeded {
+		t.Errorf("migrate hook = %+v", migrate)
+	}
+	if smoke := hooks[1]; strings.Join(smoke.DeletePolicies, ",") != HookBeforeHookCreation {
+		t.Errorf("smoke delete policies = %v, want before-hook-creation", smoke.DeletePolicies)
+	}
+	if !hooks[0].runsAt(HookPreApply, true) || !hooks[0].runsAt(HookPreApply, false) || hooks[0].runsAt(HookPostApply, true) {
+		t.Error("helm install and upgrade events do not map to pre-apply")
+	}
+
+	for _, annotations := range []map[string]string{
+		{AnnotationHook: "pre-sync"},
+		{AnnotationHook: HookPreApply, AnnotationHookWeight: "first"},
+		{AnnotationHook: HookPreApply, AnnotationHookDeletePolicy: "never"},
+	} {
+		if _, _, err := SplitHooks([]*unstructured.Unstructured{hookJob("invalid", annotations)}); err == nil {
+			t.Errorf("SplitHooks() accepted %v", annotations)
+		}
+	}
+}
+
+func TestRunHooksByWeight(t *testing.T) {
+	ctx := context.Background()
+	stale := testResource("second", "stale", nil)
+	recording := &recordingClient{Client: fake.NewClientBuilder().WithObjects(stale).Build()}
+	_, hooks, err := SplitHooks([]*unstructured.Unstructured{
+		testResource("second", "", map[string]string{AnnotationHook: HookPostApply, AnnotationHookWeight: "2"}),
+		testResource("first", "", map[string]string{
+			AnnotationHook: HookPostApply, AnnotationHookWeight: "1", AnnotationHookDeletePolicy: HookSucceeded,
+		}),
+		testResource("before", "", map[string]string{AnnotationHook: HookPreApply}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := (&ClientApply{Client: recording}).RunHooks(ctx, hooks, HookPostApply, true, testSyncOptions())
+	if err != nil {
+		t.Fatalf("RunHooks() error = %v", err)
+	}
+	want := "apply ConfigMap/first,delete ConfigMap/first,delete ConfigMap/second,apply ConfigMap/second"
+	if got := strings.Join(recording.calls, ","); got != want {
+		t.Fatalf("calls = %s\nwant %s", got, want)
+	}
+	if len(results) != 2 || results[0].Name != "first" || results[1].Phase != appsv1.HookPhaseSucceeded || results[1].Event != HookPostApply {
+		t.Fatalf("results = %+v", results)
+	}
+}
+
+func TestRunHooksWaitsForJobs(t *testing.T) {
+	ctx := context.Background()
+
+	_, hooks, err := SplitHooks([]*unstructured.Unstructured{
+		hookJob("migrate", map[string]string{AnnotationHook: HookPreApply}),
+		hookJob("after", map[string]string{AnnotationHook: HookPreApply, AnnotationHookWeight: "1"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := &jobStatusClient{Client: fake.NewClientBuilder().Build(), condition: "Complete"}
+	results, err := (&ClientApply{Client: completed}).RunHooks(ctx, hooks, HookPreApply, false, testSyncOptions())
+	if err != nil || len(results) != 2 {
+		t.Fatalf("RunHooks() = %+v, %v", results, err)
+	}
+
+	failed := &jobStatusClient{Client: fake.NewClientBuilder().Build(), condition: "Failed"}
+	results, err = (&ClientApply{Client: failed}).RunHooks(ctx, hooks, HookPreApply, false, testSyncOptions())
+	var hookErr *install.HookError
+	if !errors.As(err, &hookErr) || !strings.Contains(err.Error(), "BackoffLimitExceeded") {
+		t.Fatalf("RunHooks() error = %v, want a hook error", err)
+	}
+	if len(results) != 1 || results[0].Phase != appsv1.HookPhaseFailed || len(hookErr.Results) != 1 {
+		t.Fatalf("results = %+v, want the failed migrate hook only", results)
+	}
+}
+
+func TestRunHooksResumesRunningJob(t *testing.T) {
+	ctx := context.Background()
+	_, hooks, err := SplitHooks([]*unstructured.Unstructured{
+		hookJob("migrate", map[string]string{AnnotationHook: HookPreApply}),
+		hookJob("after", map[string]string{AnnotationHook: HookPreApply, AnnotationHookWeight: "1"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := &jobStatusClient{Client: fake.NewClientBuilder().Build()}
+	recording := &recordingClient{Client: running}
+	cli := &ClientApply{Client: recording}
+	results, err := cli.RunHooks(ctx, hooks, HookPreApply, false, testSyncOptions())
+	var waitErr *install.WaitingError
+	if !errors.As(err, &waitErr) || waitErr.Wait.Stage != "pre-apply hook Job default/migrate" {
+		t.Fatalf("RunHooks() error = %v, want waiting at the migrate hook", err)
+	}
+	if len(results) != 1 || results[0].Phase != appsv1.HookPhaseRunning {
+		t.Fatalf("results = %+v, want the running migrate hook", results)
+	}
+
+	// the resumed run checks the created job again rather than recreating it
+	running.condition = "Complete"
+	recording.calls = nil
+	options := testSyncOptions()
+	options.Waiting = &waitErr.Wait
+	results, err = cli.RunHooks(ctx, hooks, HookPreApply, false, options)
+	if err != nil || len(results) != 2 || results[0].Phase != appsv1.HookPhaseSucceeded {
+		t.Fatalf("RunHooks() = %+v, %v", results, err)
+	}
+	if got := strings.Join(recording.calls, ","); got != "apply Job/after" {
+		t.Errorf("calls = %s, want the after hook only", got)
+	}
+
+	// the hooks before the one waited for keep their earlier results
+	options.Waiting = &appsv1.InstanceWait{Stage: "pre-apply hook Job default/after", Since: metav1.Now()}
+	options.Hooks = results[:1]
+	resumed, err := cli.RunHooks(ctx, hooks, HookPreApply, false, options)
+	if err != nil || len(resumed) != 2 || resumed[0] != results[0] || resumed[1].Name != "after" {
+		t.Fatalf("RunHooks() = %+v, %v, want the earlier migrate result kept", resumed, err)
+	}
+
+	running.condition = ""
+	options.Waiting = &appsv1.InstanceWait{Stage: "pre-apply hook Job default/after", Since: metav1.NewTime(time.Now().Add(-time.Hour))}
+	if _, err := cli.RunHooks(ctx, hooks, HookPreApply, false, options); errors.As(err, &waitErr) || !strings.Contains(err.Error(), "not completed after") {
+		t.Fatalf("RunHooks() error = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,269 @@
+package native
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+const (
+	// LabelInventory marks the inventory Secret of the Instance named by the
+	// label value.
+	LabelInventory = "apps.xiaoshiai.cn/inventory"
+
+	inventorySecretType = corev1.SecretType("apps.xiaoshiai.cn/inventory")
+	inventoryKey        = "inventory.json.gz"
+)
+
+// discoveryKinds are listed by the label discovery besides the kinds
+// rendered. PersistentVolumeClaims are never discovered, the claims of
+// StatefulSet volume templates carry the labels of their pods.
+var discoveryKinds = []schema.GroupVersionKind{
+	{Version: "v1", Kind: "ConfigMap"},
+	{Version: "v1", Kind: "Secret"},
+	{Version: "v1", Kind: "Service"},
+	{Version: "v1", Kind: "ServiceAccount"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+	{Group: "batch", Version: "v1", Kind: "Job"},
+	{Group: "batch", Version: "v1", Kind: "CronJob"},
+	{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"},
+	{Group: "policy", Version: "v1", Kind: "PodDisruptionBudget"},
+	{Group: "autoscaling", Version: "v2", Kind: "HorizontalPodAutoscaler"},
+}
+
+// Inventory is the persisted list of the objects applied for an Instance,
+// kept apart from the Instance status so a lost status does not leak them.
+type Inventory struct {
+	Entries []InventoryEntry `json:"entries"`
+}
+
+// InventoryEntry is an applied object with the digest of its rendered
+// manifest.
+type InventoryEntry struct {
+	appsv1.ManagedResource `json:",inline"`
+	Digest                 string `json:"digest,omitempty"`
+}
+
+// Resources returns the objects of the inventory.
+func (inv *Inventory) Resources() []appsv1.ManagedResource {
+	if inv == nil {
+		return nil
+	}
+	resources := make([]appsv1.ManagedResource, 0, len(inv.Entries))
+	for _, entry := range inv.Entries {
+		resources = append(resources, entry.ManagedResource)
+	}
+	return resources
+}
+
+// NewInventory returns the inventory of managed, with the digests of the
+// rendered resources.
+func NewInventory(managed []appsv1.ManagedResource, resources []*unstructured.Unstructured) (*Inventory, error) {
+	digests := map[appsv1.ManagedResource]string{}
+	for _, item := range resources {
+		data, err := json.Marshal(item.Object)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		digests[appsv1.GetReference(item)] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	inv := &Inventory{}
+	for _, ref := range managed {
+		inv.Entries = append(inv.Entries, InventoryEntry{ManagedResource: ref, Digest: digests[ref]})
+	}
+	sort.Slice(inv.Entries, func(i, j int) bool {
+		return inventoryKeyOf(inv.Entries[i].ManagedResource) < inventoryKeyOf(inv.Entries[j].ManagedResource)
+	})
+	return inv, nil
+}
+
+func inventoryKeyOf(ref appsv1.ManagedResource) string {
+	return ref.APIVersion + "/" + ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+}
+
+// InventoryName is the name of the inventory Secret of an Instance.
+func InventoryName(instance string) string {
+	return "installer.inventory." + instance
+}
+
+// ReadInventory returns the inventory of the Instance, nil when it has none.
+func ReadInventory(ctx context.Context, cli client.Client, namespace, instance string) (*Inventory, error) {
+	secret := &corev1.Secret{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: namespace, Name: InventoryName(instance)}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get inventory: %w", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(secret.Data[inventoryKey]))
+	if err != nil {
+		return nil, fmt.Errorf("decode inventory: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decode inventory: %w", err)
+	}
+	inv := &Inventory{}
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("decode inventory: %w", err)
+	}
+	return inv, nil
+}
+
+// WriteInventory stores inv as the inventory of the Instance.
+func WriteInventory(ctx context.Context, cli client.Client, namespace, instance string, inv *Inventory) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: InventoryName(instance), Namespace: namespace}}
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("get inventory: %w", err)
+		}
+		secret.Labels = map[string]string{LabelInventory: instance}
+		secret.Type = inventorySecretType
+		secret.Data = map[string][]byte{inventoryKey: buf.Bytes()}
+		if err := cli.Create(ctx, secret); err != nil {
+			return fmt.Errorf("create inventory: %w", err)
+		}
+		return nil
+	}
+	secret.Data = map[string][]byte{inventoryKey: buf.Bytes()}
+	if err := cli.Update(ctx, secret); err != nil {
+		return fmt.Errorf("update inventory: %w", err)
+	}
+	return nil
+}
+
+// DeleteInventory deletes the inventory of the Instance.
+func DeleteInventory(ctx context.Context, cli client.Client, namespace, instance string) error {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: InventoryName(instance), Namespace: namespace}}
+	if err := cli.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete inventory: %w", err)
+	}
+	return nil
+}
+
+// DiscoverResources lists the objects in namespace labelled with the identity
+// of the Instance and applied by one of fieldOwners, for the kinds given and
+// the common kinds. Objects controlled by another object and hooks are left
+// out.
+func DiscoverResources(ctx context.Context, cli client.Client, namespace, instance string, fieldOwners []string, kinds []schema.GroupVersionKind) ([]appsv1.ManagedResource, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	seen := map[schema.GroupKind]bool{{Kind: "PersistentVolumeClaim"}: true}
+	var resources []appsv1.ManagedResource
+	for _, gvk := range append(append([]schema.GroupVersionKind{}, kinds...), discoveryKinds...) {
+		if seen[gvk.GroupKind()] {
+			continue
+		}
+		seen[gvk.GroupKind()] = true
+		scope, err := NamespacedScopeOfGVK(cli, gvk)
+		if err != nil || scope != apimeta.RESTScopeNameNamespace {
+			// cluster-scoped objects may carry the identity of an Instance of
+			// the same name in another namespace
+			continue
+		}
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := cli.List(ctx, list, client.InNamespace(namespace), client.MatchingLabels{install.LabelInstance: instance}); err != nil {
+			if apimeta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("discover %s: %w", gvk.Kind, err)
+		}
+		for i := range list.Items {
+			item := &list.Items[i]
+			if metav1.GetControllerOf(item) != nil || IsHook(item) || !appliedBy(item, fieldOwners) {
+				continue
+			}
+			item.SetGroupVersionKind(gvk)
+			resources = append(resources, appsv1.GetReference(item))
+		}
+	}
+	log.Info("discovered resources by label", "count", len(resources))
+	return resources, nil
+}
+
+// appliedBy reports whether one of fieldOwners manages fields of obj, the
+// label alone may come from another tool installing a release of the same
+// name.
+func appliedBy(obj client.Object, fieldOwners []string) bool {
+	for _, entry := range obj.GetManagedFields() {
+		if slices.Contains(fieldOwners, entry.Manager) {
+			return true
+		}
+	}
+	return false
+}
+
+// inventoryResources returns the objects applied for the Instance: those of
+// status and of its inventory, or of the label discovery when it has none.
+func (a *ClientApply) inventoryResources(ctx context.Context, namespace, instance string,
+	status []appsv1.ManagedResource, resources []*unstructured.Unstructured, options *SyncOptions,
+) ([]appsv1.ManagedResource, error) {
+	inv, err := ReadInventory(ctx, a.Client, namespace, instance)
+	if err != nil {
+		return nil, err
+	}
+	known := inv.Resources()
+	if inv == nil {
+		kinds := []schema.GroupVersionKind{}
+		for _, ref := range status {
+			kinds = append(kinds, ref.GroupVersionKind())
+		}
+		for _, item := range resources {
+			kinds = append(kinds, item.GroupVersionKind())
+		}
+		// objects applied before the field manager was configurable
+		fieldOwners := []string{options.fieldManager(), DefaultFieldOwner}
+		if known, err = DiscoverResources(ctx, a.Client, namespace, instance, fieldOwners, kinds); err != nil {
+			return nil, err
+		}
+	}
+	merged := append([]appsv1.ManagedResource{}, status...)
+	CorrectNamespacesForRefrences(a.Client, namespace, merged)
+	seen := map[appsv1.ManagedResource]bool{}
+	for _, ref := range merged {
+		seen[ref] = true
+	}
+	for _, ref := range known {
+		if !seen[ref] {
+			seen[ref] = true
+			merged = append(merged, ref)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,212 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// namespacedClientBuilder returns a fake client builder mapping ConfigMaps and
+// Secrets as namespaced.
+func namespacedClientBuilder() *fake.ClientBuilder {
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), apimeta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), apimeta.RESTScopeNamespace)
+	return fake.NewClientBuilder().WithRESTMapper(mapper)
+}
+
+func renderConfigMaps(names ...string) TemplateFun {
+	return func(ctx context.Context, instance install.Instance) ([]byte, error) {
+		var docs []string
+		for _, name := range names {
+			docs = append(docs, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: "+name+"\n  labels:\n    app.kubernetes.io/instance: web\n")
+		}
+		return []byte(strings.Join(docs, "---\n")), nil
+	}
+}
+
+func configMapExists(t *testing.T, cli client.Client, name string) bool {
+	t.Helper()
+	err := cli.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &corev1.ConfigMap{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestInventoryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cli := fake.NewClientBuilder().Build()
+	resources := []*unstructured.Unstructured{testResource("settings", "one", nil)}
+	managed := []appsv1.ManagedResource{appsv1.GetReference(resources[0])}
+	inv, err := NewInventory(managed, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(inv.Entries[0].Digest, "sha256:") {
+		t.Fatalf("digest = %q", inv.Entries[0].Digest)
+	}
+	for range 2 {
+		if err := WriteInventory(ctx, cli, "default", "web", inv); err != nil {
+			t.Fatalf("WriteInventory() error = %v", err)
+		}
+	}
+	secret := &corev1.Secret{}
+	if err := cli.Get(ctx, client.ObjectKey{Namespace: "default", Name: InventoryName("web")}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if data := secret.Data[inventoryKey]; len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Fatal("inventory is not gzip compressed")
+	}
+	got, err := ReadInventory(ctx, cli, "default", "web")
+	if err != nil {
+		t.Fatalf("ReadInventory() error = %v", err)
+	}
+	if len(got.Entries) != 1 || got.Entries[0] != inv.Entries[0] {
+		t.Fatalf("ReadInventory() = %+v, want %+v", got, inv)
+	}
+	if err := DeleteInventory(ctx, cli, "default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadInventory(ctx, cli, "default", "web"); got != nil || err != nil {
+		t.Fatalf("ReadInventory() after delete = %v, %v", got, err)
+	}
+}
+
+func TestApplyPrunesWithLostStatus(t *testing.T) {
+	ctx := context.Background()
+	cli := namespacedClientBuilder().Build()
+	instance := install.Instance{Name: "web", Namespace: "default", Kind: install.InstanceKindKustomize}
+
+	if _, err := New(cli, renderConfigMaps("kept", "dropped")).Apply(ctx, instance); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	// the status is lost, the inventory still lists both objects
+	if _, err := New(cli, renderConfigMaps("kept")).Apply(ctx, instance); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !configMapExists(t, cli, "kept") || configMapExists(t, cli, "dropped") {
+		t.Fatal("object no longer rendered was not pruned")
+	}
+	if _, err := New(cli, renderConfigMaps()).Remove(ctx, instance); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if configMapExists(t, cli, "kept") {
+		t.Fatal("Remove() left an object of the inventory")
+	}
+	if inv, _ := ReadInventory(ctx, cli, "default", "web"); inv != nil {
+		t.Fatal("Remove() left the inventory")
+	}
+}
+
+func TestRemoveDiscoversLabelledObjects(t *testing.T) {
+	ctx := context.Background()
+	cli := namespacedClientBuilder().WithReturnManagedFields().Build()
+	create := func(name, manager string, owners ...metav1.OwnerReference) {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "default", Labels: map[string]string{install.LabelInstance: "web"}, OwnerReferences: owners,
+		}}
+		if err := cli.Create(ctx, cm, client.FieldOwner(manager)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isController := true
+	create("applied", DefaultFieldOwner)
+	create("foreign", "helm")
+	create("controlled", DefaultFieldOwner, metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", UID: "uid", Controller: &isController})
+
+	instance := install.Instance{Name: "web", Namespace: "default", Kind: install.InstanceKindKustomize}
+	removed, err := New(cli, renderConfigMaps()).Remove(ctx, instance)
+	if err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if configMapExists(t, cli, "applied") {
+		t.Error("discovered object was not removed")
+	}
+	if len(removed) != 1 || removed[0].Name != "applied" {
+		t.Errorf("Remove() = %v, want the discovered object", removed)
+	}
+	if !configMapExists(t, cli, "foreign") || !configMapExists(t, cli, "controlled") {
+		t.Error("Remove() deleted an object it did not apply")
+	}
+}
+
+func TestRemoveOrphansByDeletionPolicy(t *testing.T) {
+	render := func(ctx context.Context, instance install.Instance) ([]byte, error) {
+		return []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  labels:\n    app.kubernetes.io/instance: web\n" +
+			"---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\n  labels:\n    app.kubernetes.io/instance: web\n"), nil
+	}
+	for policy, keepsSettings := range map[appsv1.DeletionPolicy]bool{
+		appsv1.DeletionPolicyOrphan:     true,
+		appsv1.DeletionPolicyOrphanData: false,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx := context.Background()
+			cli := namespacedClientBuilder().Build()
+			instance := install.Instance{Name: "web", Namespace: "default", Kind: install.InstanceKindKustomize, DeletionPolicy: policy}
+			if _, err := New(cli, render).Apply(ctx, instance); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if _, err := New(cli, render).Remove(ctx, instance); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			if configMapExists(t, cli, "settings") != keepsSettings {
+				t.Errorf("settings kept = %v, want %v", !keepsSettings, keepsSettings)
+			}
+			secret := &corev1.Secret{}
+			if err := cli.Get(ctx, client.ObjectKey{Namespace: "default", Name: "credentials"}, secret); err != nil {
+				t.Fatalf("orphaned Secret: %v", err)
+			}
+			if _, ok := secret.Labels[install.LabelInstance]; ok {
+				t.Errorf("orphaned Secret kept the identity label: %v", secret.Labels)
+			}
+			if inv, _ := ReadInventory(ctx, cli, "default", "web"); inv != nil {
+				t.Error("Remove() left the inventory")
+			}
+		})
+	}
+}
+
+type sliceRecorder []string
+
+func (r *sliceRecorder) Eventf(eventtype, reason, messageFmt string, args ...any) {
+	*r = append(*r, eventtype+" "+reason+" "+fmt.Sprintf(messageFmt, args...))
+}
+
+func TestSyncRecordsObjectCounts(t *testing.T) {
+	ctx := context.Background()
+	cli := namespacedClientBuilder().Build()
+	recorder := &sliceRecorder{}
+	instance := install.Instance{Name: "web", Namespace: "default", Kind: install.InstanceKindKustomize, Recorder: recorder}
+
+	for _, names := range [][]string{{"kept", "dropped"}, {"kept"}, {"kept"}} {
+		if _, err := New(cli, renderConfigMaps(names...)).Apply(ctx, instance); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	if _, err := New(cli, renderConfigMaps()).Remove(ctx, instance); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	// an unchanged apply records nothing
+	expected := []string{
+		"Normal ResourcesSynced Created 2 and deleted 0 objects",
+		"Normal ResourcesSynced Created 0 and deleted 1 objects",
+		"Normal ResourcesSynced Created 0 and deleted 1 objects",
+	}
+	if !slices.Equal(*recorder, expected) {
+		t.Fatalf("recorded %q, want %q", *recorder, expected)
+	}
+}
@@ -56,7 +56,8 @@ func testResource(name, value string, annotations map[string]string) *unstructur
 }
 
 func testSyncOptions() *SyncOptions {
-	return &SyncOptions{ServerSideApply: true, CreateNamespace: false, DeleteTimeout: time.Second}
+	// objects are applied one by one, in a deterministic order
+	return &SyncOptions{ServerSideApply: true, CreateNamespace: false, DeleteTimeout: time.Second, Concurrency: 1, Force: true}
 }
 
 func TestSyncDiffRetainsExistingResourceOnUpgrade(t *testing.T) {
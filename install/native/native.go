@@ -2,10 +2,17 @@ package native
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
 	"xiaoshiai.cn/installer/install"
 	"xiaoshiai.cn/installer/utils"
 )
@@ -24,13 +31,27 @@ func New(cli client.Client, fun TemplateFun) *Apply {
 	}
 }
 
+// Template renders instance, followed by the post-render pipeline of the
+// instance.
 func (p *Apply) Template(ctx context.Context, instance install.Instance) ([]byte, error) {
-	return p.TemplateFun(ctx, instance)
+	rendered, err := p.TemplateFun(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	return install.PostRender(instance.PostRenderer, rendered)
 }
 
 func (p *Apply) Apply(ctx context.Context, instance install.Instance) (*install.InstanceStatus, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
+	options, err := ParseSyncOptions(ctx, instance.Options)
+	if err != nil {
+		return nil, err
+	}
+	options.FieldManager = FieldManagerOf(instance.Name, instance.Options)
+	options.Recorder = instance.Recorder
+	options.Waiting = instance.Waiting
+	options.Hooks = instance.Hooks
 	rendered, err := p.Template(ctx, instance)
 	if err != nil {
 		return nil, err
@@ -39,9 +60,17 @@ func (p *Apply) Apply(ctx context.Context, instance install.Instance) (*install.
 	if err != nil {
 		return nil, err
 	}
+	resources, hooks, err := SplitHooks(resources)
+	if err != nil {
+		return nil, err
+	}
 
 	ns := instance.Namespace
-	diffresult := DiffWithDefaultNamespace(p.Cli.Client, ns, instance.Resources, resources)
+	inventory, err := p.Cli.inventoryResources(ctx, ns, instance.Name, instance.Resources, resources, options)
+	if err != nil {
+		return nil, err
+	}
+	diffresult := DiffWithDefaultNamespace(p.Cli.Client, ns, inventory, resources)
 	if len(diffresult.Creats) == 0 &&
 		len(diffresult.Applys) == 0 &&
 		len(diffresult.Removes) == 0 {
@@ -55,10 +84,48 @@ func (p *Apply) Apply(ctx context.Context, instance install.Instance) (*install.
 			UpgradeTimestamp:  instance.UpgradeTimestamp,
 		}, nil
 	}
-	managedResources, err := p.Cli.SyncDiff(ctx, diffresult, NewDefaultSyncOptions())
+	var adopted []appsv1.ManagedResource
+	if instance.Adopt != nil {
+		// objects created by the sync which already exist are taken over
+		if adopted, err = AdoptResources(ctx, p.Cli.Client, diffresult.Creats, options.fieldManager()); err != nil {
+			return nil, err
+		}
+	}
+	installing := len(instance.Resources) == 0
+	CorrectNamespaces(p.Cli.Client, ns, hookObjects(hooks))
+	var hookResults []appsv1.HookResult
+	// a resumed sync ran its pre-apply hooks already unless it waits at one
+	if instance.Waiting == nil || waitsAtHook(instance.Waiting, HookPreApply) {
+		if hookResults, err = p.Cli.RunHooks(ctx, hooks, HookPreApply, installing, options); err != nil {
+			return nil, err
+		}
+	} else {
+		hookResults = earlierResults(instance.Hooks, selectHooks(hooks, HookPreApply, installing), HookPreApply)
+	}
+	managedResources, err := p.Cli.SyncDiff(ctx, diffresult, options)
+	if managedResources != nil {
+		// objects created by a failed sync are pruned even if the status is not updated
+		if inverr := p.writeInventory(ctx, instance, managedResources, resources); inverr != nil {
+			return nil, errors.Join(err, inverr)
+		}
+	}
+	var waitErr *install.WaitingError
+	if errors.As(err, &waitErr) && len(hookResults) > 0 {
+		// the results of the pre-apply hooks are kept for the resumed sync
+		return nil, &install.HookError{Results: hookResults, Err: err}
+	}
 	if err != nil {
 		return nil, err
 	}
+	postResults, err := p.Cli.RunHooks(ctx, hooks, HookPostApply, installing, options)
+	hookResults = append(hookResults, postResults...)
+	if err != nil {
+		return nil, &install.HookError{Results: hookResults, Err: err}
+	}
+	var description string
+	if len(adopted) > 0 {
+		description = fmt.Sprintf("Adopted %d existing objects", len(adopted))
+	}
 	return &install.InstanceStatus{
 		Resources:         managedResources,
 		Values:            instance.Values,
@@ -66,18 +133,135 @@ func (p *Apply) Apply(ctx context.Context, instance install.Instance) (*install.
 		Namespace:         ns,
 		CreationTimestamp: instance.CreationTimestamp,
 		UpgradeTimestamp:  time.Now(),
+		Description:       description,
+		Adopted:           adopted,
+		Hooks:             hookResults,
 	}, nil
 }
 
-func (p *Apply) Remove(ctx context.Context, instance install.Instance) error {
+func (p *Apply) Remove(ctx context.Context, instance install.Instance) ([]appsv1.ManagedResource, error) {
 	ns := instance.Namespace
-	if ns == "" {
-		ns = instance.Namespace
+	options, err := ParseSyncOptions(ctx, instance.Options)
+	if err != nil {
+		// invalid options do not block the removal
+		logr.FromContextOrDiscard(ctx).Error(err, "parsing options")
+		options = NewDefaultSyncOptions()
+	}
+	options.FieldManager = FieldManagerOf(instance.Name, instance.Options)
+	// a failed removal is retried rather than rolled back
+	options.Transactional = false
+	// workloads terminate once their pods are gone, the removal waits for them
+	options.DeletePropagation = metav1.DeletePropagationForeground
+	options.Recorder = instance.Recorder
+	options.Waiting = instance.Waiting
+	options.Hooks = instance.Hooks
+	// nothing is removed when every object is orphaned, neither are hooks run
+	var hooks []Hook
+	if instance.DeletionPolicy != appsv1.DeletionPolicyOrphan {
+		hooks = p.deleteHooks(ctx, instance)
 	}
-	managedResources, err := p.Cli.Sync(ctx, ns, instance.Resources, nil, NewDefaultSyncOptions())
+	// a resumed removal ran its pre-delete hooks already unless it waits at one
+	if instance.Waiting == nil || waitsAtHook(instance.Waiting, HookPreDelete) {
+		if _, err := p.Cli.RunHooks(ctx, hooks, HookPreDelete, false, options); err != nil {
+			return nil, err
+		}
+	}
+	inventory, err := p.Cli.inventoryResources(ctx, ns, instance.Name, instance.Resources, nil, options)
+	if err != nil {
+		return nil, err
+	}
+	removed, orphaned := splitOrphaned(inventory, instance.DeletionPolicy)
+	if err := p.Cli.orphan(ctx, ns, orphaned); err != nil {
+		return nil, err
+	}
+	if _, err := p.Cli.Sync(ctx, ns, removed, nil, options); err != nil {
+		return nil, err
+	}
+	// the inventory is deleted last, a removal resumed at a hook still reads it
+	if _, err := p.Cli.RunHooks(ctx, hooks, HookPostDelete, false, options); err != nil {
+		return removed, err
+	}
+	if err := DeleteInventory(ctx, p.Cli.Client, ns, instance.Name); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// splitOrphaned splits the objects of an Instance into those its removal
+// deletes and those the deletion policy leaves in the cluster.
+func splitOrphaned(resources []appsv1.ManagedResource, policy appsv1.DeletionPolicy) ([]appsv1.ManagedResource, []appsv1.ManagedResource) {
+	var removed, orphaned []appsv1.ManagedResource
+	for _, ref := range resources {
+		if install.Orphans(policy, ref.GroupVersionKind().GroupKind()) {
+			orphaned = append(orphaned, ref)
+		} else {
+			removed = append(removed, ref)
+		}
+	}
+	return removed, orphaned
+}
+
+// orphan removes the identity label of the Instance from the objects of refs
+// and leaves them in the cluster, so neither the label discovery nor a later
+// Instance of the same name takes them over. Pod templates keep the label,
+// their pods are not restarted.
+func (a *ClientApply) orphan(ctx context.Context, namespace string, refs []appsv1.ManagedResource) error {
+	log := logr.FromContextOrDiscard(ctx)
+	CorrectNamespacesForRefrences(a.Client, namespace, refs)
+	patch := client.RawPatch(types.MergePatchType, []byte(`{"metadata":{"labels":{"`+install.LabelInstance+`":null}}}`))
+	var errs []error
+	for _, ref := range refs {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(ref.GroupVersionKind())
+		obj.SetNamespace(ref.Namespace)
+		obj.SetName(ref.Name)
+		log.Info("orphaning resource", "resource", ref.GroupVersionKind().String(), "name", ref.Name, "namespace", ref.Namespace)
+		if err := a.Client.Patch(ctx, obj, patch); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, describeError(obj, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Apply) writeInventory(ctx context.Context, instance install.Instance, managed []appsv1.ManagedResource, resources []*unstructured.Unstructured) error {
+	inventory, err := NewInventory(managed, resources)
 	if err != nil {
 		return err
 	}
-	_ = managedResources
-	return nil
+	return WriteInventory(ctx, p.Cli.Client, instance.Namespace, instance.Name, inventory)
+}
+
+// deleteHooks renders the hooks of instance for its removal from the source
+// fetched by the caller. Without a location, or when the source no longer
+// renders, the removal goes on without hooks.
+func (p *Apply) deleteHooks(ctx context.Context, instance install.Instance) []Hook {
+	log := logr.FromContextOrDiscard(ctx)
+	if instance.Location == "" {
+		return nil
+	}
+	rendered, err := p.Template(ctx, instance)
+	if err != nil {
+		log.Error(err, "rendering delete hooks")
+		return nil
+	}
+	resources, err := utils.SplitYAML(rendered)
+	if err != nil {
+		log.Error(err, "rendering delete hooks")
+		return nil
+	}
+	_, hooks, err := SplitHooks(resources)
+	if err != nil {
+		log.Error(err, "rendering delete hooks")
+		return nil
+	}
+	CorrectNamespaces(p.Cli.Client, instance.Namespace, hookObjects(hooks))
+	return hooks
+}
+
+func hookObjects(hooks []Hook) []*unstructured.Unstructured {
+	objects := make([]*unstructured.Unstructured, 0, len(hooks))
+	for _, hook := range hooks {
+		objects = append(objects, hook.Object)
+	}
+	return objects
 }
@@ -0,0 +1,62 @@
+package native
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/controller/postrender"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/manifests"
+)
+
+func TestApplyPostRendersManifests(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: default\ndata:\n  value: rendered\n  mode: rendered\n"
+	if err := os.WriteFile(filepath.Join(dir, "settings.yaml"), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	live := testResource("settings", "edited", nil)
+	cli := fake.NewClientBuilder().WithObjects(live).Build()
+	ignore := &postrender.IgnoreDifferencesRenderer{
+		Rules: []appsv1.IgnoreDifference{{Kind: "ConfigMap", JSONPointers: []string{"/data/value"}}},
+		Live: func(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+			live := &unstructured.Unstructured{}
+			live.SetGroupVersionKind(obj.GroupVersionKind())
+			if err := cli.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+				if apierrors.IsNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return live, nil
+		},
+	}
+	apply := New(cli, manifests.ManifestsRenderFunc)
+	instance := install.Instance{
+		Name:         "demo",
+		Namespace:    "default",
+		Location:     dir,
+		Resources:    []appsv1.ManagedResource{appsv1.GetReference(live)},
+		PostRenderer: postrender.CompositeRenderer{Modifiers: []postrender.ObjectModifier{ignore}},
+	}
+	if _, err := apply.Apply(ctx, instance); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	got := testResource("settings", "", nil)
+	if err := cli.Get(ctx, client.ObjectKeyFromObject(got), got); err != nil {
+		t.Fatal(err)
+	}
+	data, _, _ := unstructured.NestedStringMap(got.Object, "data")
+	if data["value"] != "edited" || data["mode"] != "rendered" {
+		t.Fatalf("data = %v, want the ignored value kept and the others applied", data)
+	}
+}
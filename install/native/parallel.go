@@ -0,0 +1,211 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/sync/errgroup"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+)
+
+// DefaultConcurrency is the default of SyncOptions.Concurrency.
+const DefaultConcurrency = 8
+
+const (
+	// listThreshold is the number of objects of a kind in a namespace from
+	// which their live metadata is listed at once rather than got one by one.
+	listThreshold = 3
+	// listLimit bounds the objects of a list, the objects of a kind holding
+	// more in the namespace are got one by one.
+	listLimit = 500
+)
+
+type liveKey struct {
+	gk        schema.GroupKind
+	namespace string
+	name      string
+}
+
+func liveKeyOf(obj client.Object) liveKey {
+	return liveKey{
+		gk:        obj.GetObjectKind().GroupVersionKind().GroupKind(),
+		namespace: obj.GetNamespace(),
+		name:      obj.GetName(),
+	}
+}
+
+// liveObjects is the listed live metadata of a batch, nil for the objects
+// listed but absent. Objects not listed are got when applied.
+type liveObjects map[liveKey]*metav1.PartialObjectMetadata
+
+// splitBatches splits the sorted items of a wave into the batches applied one
+// after another: namespaces and custom resource definitions are applied
+// before the objects they hold or define, the other objects of the wave at
+// once.
+func splitBatches(items []*unstructured.Unstructured) [][]*unstructured.Unstructured {
+	var batches [][]*unstructured.Unstructured
+	for i, item := range items {
+		if i == 0 || batchStage(item) != batchStage(items[i-1]) {
+			batches = append(batches, nil)
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], item)
+	}
+	return batches
+}
+
+// Batch stages of the objects of a wave.
+const (
+	batchNamespaces = iota
+	batchDefinitions
+	batchResources
+)
+
+func batchStage(item *unstructured.Unstructured) int {
+	switch {
+	case item.GetKind() == "Namespace" && item.GroupVersionKind().Group == "":
+		return batchNamespaces
+	case IsCRD(item):
+		return batchDefinitions
+	default:
+		return batchResources
+	}
+}
+
+// listLive lists the live metadata of the namespaced items with one List per
+// kind and namespace holding several of them. The lists are metadata only, so
+// they are served by the metadata informers of the cache when the client has
+// one, as the controller watches the objects of its Instances with. The
+// objects of cluster-scoped kinds, and of kinds too large to list, are got one
+// by one.
+func (a *ClientApply) listLive(ctx context.Context, items []*unstructured.Unstructured) liveObjects {
+	log := logr.FromContextOrDiscard(ctx)
+	type listKey struct {
+		gvk       schema.GroupVersionKind
+		namespace string
+	}
+	groups := map[listKey][]*unstructured.Unstructured{}
+	for _, item := range items {
+		if item.GetNamespace() == "" {
+			continue
+		}
+		key := listKey{gvk: item.GroupVersionKind(), namespace: item.GetNamespace()}
+		groups[key] = append(groups[key], item)
+	}
+	live := liveObjects{}
+	for key, group := range groups {
+		if len(group) < listThreshold {
+			continue
+		}
+		list := &metav1.PartialObjectMetadataList{}
+		list.SetGroupVersionKind(key.gvk.GroupVersion().WithKind(key.gvk.Kind + "List"))
+		if err := a.Client.List(ctx, list, client.InNamespace(key.namespace), client.Limit(listLimit)); err != nil {
+			log.V(1).Info("listing live resources, getting them one by one", "resource", key.gvk.String(), "namespace", key.namespace, "error", err.Error())
+			continue
+		}
+		// an object absent from a partial list may exist
+		if list.GetContinue() == "" && len(list.Items) < listLimit {
+			for _, item := range group {
+				live[liveKeyOf(item)] = nil
+			}
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			obj.SetGroupVersionKind(key.gvk)
+			live[liveKeyOf(obj)] = obj
+		}
+	}
+	return live
+}
+
+// applyBatch creates or applies the items of a batch with at most
+// options.Concurrency at once. The managed resources and the errors are
+// returned in the order of items whatever the order of completion.
+func (a *ClientApply) applyBatch(ctx context.Context, items []*unstructured.Unstructured,
+	created map[*unstructured.Unstructured]bool, options *SyncOptions,
+) ([]appsv1.ManagedResource, []error) {
+	if options.CreateNamespace {
+		namespaces := map[string]bool{}
+		for _, item := range items {
+			if ns := item.GetNamespace(); !namespaces[ns] {
+				namespaces[ns] = true
+				a.createNsIfNotExists(ctx, ns)
+			}
+		}
+	}
+	// the patch of a server-side apply does not require the live object
+	var live liveObjects
+	if options.ServerSideApply {
+		live = a.listLive(ctx, items)
+	}
+
+	results := make([]error, len(items))
+	started := make([]bool, len(items))
+	var failed atomic.Bool
+	group := &errgroup.Group{}
+	group.SetLimit(options.Concurrency)
+	for i, item := range items {
+		group.Go(func() error {
+			// a transactional sync stops at the first error
+			if options.Transactional && failed.Load() {
+				return nil
+			}
+			started[i] = true
+			if created[item] {
+				results[i] = a.createResource(ctx, item, live, options)
+			} else {
+				results[i] = a.applyResource(ctx, item, live, options)
+			}
+			if results[i] != nil {
+				failed.Store(true)
+			}
+			return nil
+		})
+	}
+	_ = group.Wait()
+
+	managed := []appsv1.ManagedResource{}
+	errs := []error{}
+	for i, item := range items {
+		if !started[i] {
+			continue
+		}
+		if results[i] != nil {
+			errs = append(errs, results[i])
+			if created[item] {
+				continue
+			}
+		}
+		managed = append(managed, appsv1.GetReference(item)) // set managed
+	}
+	return managed, errs
+}
+
+// apply creates or patches item, from its listed live metadata when known.
+func (a *ClientApply) apply(ctx context.Context, item *unstructured.Unstructured, live liveObjects, options *SyncOptions) error {
+	applyOptions := options.applyOptions()
+	exists, listed := live[liveKeyOf(item)]
+	switch {
+	case !listed:
+		return ApplyResource(ctx, a.Client, item, applyOptions)
+	case exists == nil:
+		err := applyLive(ctx, a.Client, item, nil, applyOptions)
+		if apierrors.IsAlreadyExists(err) {
+			// created since it was listed
+			return ApplyResource(ctx, a.Client, item, applyOptions)
+		}
+		return err
+	default:
+		return applyLive(ctx, a.Client, item, exists.DeepCopy(), applyOptions)
+	}
+}
+
+func describeError(item client.Object, err error) error {
+	return fmt.Errorf("%s %s/%s: %w", item.GetObjectKind().GroupVersionKind().String(), item.GetNamespace(), item.GetName(), err)
+}
@@ -0,0 +1,158 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// concurrencyClient counts reads, records the most creates in flight at once
+// and fails the creation of objects named broken-*.
+type concurrencyClient struct {
+	client.Client
+	mu                  sync.Mutex
+	gets, lists         int
+	inFlight, maxFlight int
+}
+
+func (c *concurrencyClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *concurrencyClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.mu.Lock()
+	c.lists++
+	c.mu.Unlock()
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *concurrencyClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.mu.Lock()
+	c.inFlight++
+	c.maxFlight = max(c.maxFlight, c.inFlight)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if strings.HasPrefix(obj.GetName(), "broken-") {
+		return errors.New("admission denied")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestSyncDiffAppliesWaveConcurrently(t *testing.T) {
+	concurrent := &concurrencyClient{Client: fake.NewClientBuilder().Build()}
+	diff := DiffResult{}
+	for i := range 9 {
+		diff.Creats = append(diff.Creats, testResource(fmt.Sprintf("settings-%d", i), "", nil))
+	}
+	options := testSyncOptions()
+	options.Concurrency = 3
+	managed, err := (&ClientApply{Client: concurrent}).SyncDiff(context.Background(), diff, options)
+	if err != nil {
+		t.Fatalf("SyncDiff() error = %v", err)
+	}
+	if len(managed) != 9 {
+		t.Fatalf("managed = %d, want 9", len(managed))
+	}
+	if concurrent.maxFlight < 2 || concurrent.maxFlight > 3 {
+		t.Errorf("creates in flight = %d, want at most 3 and more than one", concurrent.maxFlight)
+	}
+	if concurrent.lists != 1 || concurrent.gets != 0 {
+		t.Errorf("lists = %d, gets = %d, want the live state listed once", concurrent.lists, concurrent.gets)
+	}
+}
+
+func TestSyncDiffListsLiveMetadata(t *testing.T) {
+	ctx := context.Background()
+	existing := testResource("settings-0", "old", nil)
+	namespace := waveResource("Namespace", "apps", "")
+	namespace.SetAPIVersion("v1")
+	namespace.SetNamespace("")
+	concurrent := &concurrencyClient{Client: fake.NewClientBuilder().WithObjects(existing).Build()}
+	diff := DiffResult{
+		Applys: []*unstructured.Unstructured{testResource("settings-0", "new", nil)},
+		Creats: []*unstructured.Unstructured{namespace, testResource("settings-1", "", nil), testResource("settings-2", "", nil)},
+	}
+	if _, err := (&ClientApply{Client: concurrent}).SyncDiff(ctx, diff, testSyncOptions()); err != nil {
+		t.Fatalf("SyncDiff() error = %v", err)
+	}
+	// the cluster-scoped namespace is got, the config maps are listed
+	if concurrent.lists != 1 || concurrent.gets != 1 {
+		t.Errorf("lists = %d, gets = %d, want one list and one get", concurrent.lists, concurrent.gets)
+	}
+	live := testResource("settings-0", "", nil)
+	if err := concurrent.Client.Get(ctx, client.ObjectKeyFromObject(live), live); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := unstructured.NestedString(live.Object, "data", "value"); value != "new" {
+		t.Errorf("value = %q, want the listed object patched", value)
+	}
+}
+
+func TestSyncDiffReportsErrorsInOrder(t *testing.T) {
+	names := []string{"broken-b", "settings", "broken-a", "broken-c"}
+	options := testSyncOptions()
+	options.Concurrency = 4
+	for range 3 {
+		var creats []*unstructured.Unstructured
+		for _, name := range names {
+			creats = append(creats, testResource(name, "", nil))
+		}
+		concurrent := &concurrencyClient{Client: fake.NewClientBuilder().Build()}
+		managed, err := (&ClientApply{Client: concurrent}).SyncDiff(context.Background(), DiffResult{Creats: creats}, options)
+		if err == nil {
+			t.Fatal("SyncDiff() error = nil")
+		}
+		var failed []string
+		for _, line := range strings.Split(err.Error(), "\n") {
+			failed = append(failed, strings.TrimSuffix(strings.Fields(line)[2], ":"))
+		}
+		if got := strings.Join(failed, ","); got != "default/broken-b,default/broken-a,default/broken-c" {
+			t.Fatalf("errors reported for %s, want the order of the objects", got)
+		}
+		if len(managed) != 1 || managed[0].Name != "settings" {
+			t.Fatalf("managed = %v, want the created settings only", managed)
+		}
+	}
+}
+
+func TestSplitBatches(t *testing.T) {
+	crd := waveResource("CustomResourceDefinition", "widgets", "")
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	waves, err := groupWaves([]*unstructured.Unstructured{
+		waveResource("Widget", "custom", ""),
+		crd,
+		waveResource("ConfigMap", "settings", ""),
+		waveResource("Namespace", "apps", ""),
+		waveResource("Secret", "credentials", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, batch := range splitBatches(waves[0].items) {
+		var names []string
+		for _, item := range batch {
+			names = append(names, item.GetName())
+		}
+		got = append(got, strings.Join(names, "+"))
+	}
+	if strings.Join(got, ",") != "apps,credentials+settings,widgets,custom" {
+		t.Fatalf("batches = %v", got)
+	}
+}
@@ -0,0 +1,237 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+// ParseSyncOptions returns the sync options set by the options of a native
+// instance. Unknown options are logged and ignored, so options of other
+// kinds or releases do not break the sync.
+func ParseSyncOptions(ctx context.Context, options []install.Option) (*SyncOptions, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	sync := NewDefaultSyncOptions()
+	for _, opt := range options {
+		switch opt.Name {
+		case "transactional":
+			b, err := strconv.ParseBool(opt.Value)
+			if err != nil {
+				return nil, fmt.Errorf("parse transactional: %w", err)
+			}
+			sync.Transactional = b
+		case "concurrency":
+			i, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return nil, fmt.Errorf("parse concurrency: %w", err)
+			}
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid concurrency %d, must be positive", i)
+			}
+			sync.Concurrency = i
+		case "fieldManager":
+			sync.FieldManager = opt.Value
+		case "force":
+			b, err := strconv.ParseBool(opt.Value)
+			if err != nil {
+				return nil, fmt.Errorf("parse force: %w", err)
+			}
+			sync.Force = b
+		default:
+			log.Info("ignoring unknown option", "option", opt.Name)
+		}
+	}
+	return sync, nil
+}
+
+// FieldManagerOf returns the field manager applying the objects of the
+// Instance named name: the fieldManager option, the name by default.
+func FieldManagerOf(name string, options []install.Option) string {
+	for _, opt := range options {
+		if opt.Name == "fieldManager" && opt.Value != "" {
+			return opt.Value
+		}
+	}
+	return name
+}
+
+// snapshot is the live state of an object before a transactional sync, live
+// is nil for an object the sync creates.
+type snapshot struct {
+	item     *unstructured.Unstructured
+	live     *unstructured.Unstructured
+	recreate bool
+}
+
+// dryRun server-side dry-runs the objects the sync creates or updates, so
+// invalid objects fail the sync before any change.
+func (a *ClientApply) dryRun(ctx context.Context, diff DiffResult, options *SyncOptions) error {
+	var errs []error
+	for _, item := range diff.Creats {
+		errs = append(errs, a.dryRunItem(ctx, item, options))
+	}
+	for _, item := range diff.Applys {
+		if IsSkipUpdate(item) {
+			continue
+		}
+		errs = append(errs, a.dryRunItem(ctx, item, options))
+	}
+	return errors.Join(errs...)
+}
+
+func (a *ClientApply) dryRunItem(ctx context.Context, item *unstructured.Unstructured, options *SyncOptions) error {
+	desired := item.DeepCopy()
+	desired.SetManagedFields(nil)
+	desired.SetResourceVersion("")
+	patchOptions := []client.PatchOption{client.DryRunAll, client.FieldOwner(options.fieldManager())}
+	if options.Force {
+		patchOptions = append(patchOptions, client.ForceOwnership)
+	}
+	err := conflictError(item, a.Client.Patch(ctx, desired, client.Apply, patchOptions...))
+	var conflictErr *install.ApplyConflictError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &conflictErr) && migratedConflicts(conflictErr.Conflicts, options.applyOptions().MigrateFrom):
+		// the fields of former field managers are migrated before the apply
+		return nil
+	case apimeta.IsNoMatchError(err):
+		// the kind is defined by a CRD of the same sync
+		return nil
+	case apierrors.IsNotFound(err) && options.CreateNamespace:
+		// the namespace is created on sync
+		return nil
+	}
+	return fmt.Errorf("dry-run %s: %w", describe(item), err)
+}
+
+// migratedConflicts reports whether conflicts are all with the field managers
+// in legacy.
+func migratedConflicts(conflicts []install.FieldConflict, legacy []string) bool {
+	for _, conflict := range conflicts {
+		if !slices.Contains(legacy, conflict.Manager) {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshot records the live state of the objects the sync changes. Removed
+// objects were resolved from the API during validation.
+func (a *ClientApply) snapshot(ctx context.Context, diff DiffResult) ([]snapshot, error) {
+	var snapshots []snapshot
+	record := func(item *unstructured.Unstructured, recreate bool) error {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(item.GroupVersionKind())
+		if err := a.Client.Get(ctx, client.ObjectKeyFromObject(item), live); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("snapshot %s: %w", describe(item), err)
+			}
+			live = nil
+		}
+		snapshots = append(snapshots, snapshot{item: item, live: live, recreate: recreate})
+		return nil
+	}
+	for _, item := range diff.Creats {
+		if err := record(item, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range diff.Applys {
+		if IsSkipUpdate(item) {
+			continue
+		}
+		if err := record(item, IsRecreateUpdate(item)); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range diff.Removes {
+		// removed objects not found were dropped before
+		if item.GetResourceVersion() != "" {
+			snapshots = append(snapshots, snapshot{item: item, live: item.DeepCopy()})
+		}
+	}
+	return snapshots, nil
+}
+
+// rollback restores the snapshots in the reverse order: objects the sync
+// created are deleted, changed and deleted objects get their previous state
+// back.
+func (a *ClientApply) rollback(ctx context.Context, snapshots []snapshot, options *SyncOptions) error {
+	log := logr.FromContextOrDiscard(ctx)
+	var errs []error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snap := snapshots[i]
+		log.Info("rolling back resource", "resource", snap.item.GroupVersionKind().String(), "name", snap.item.GetName(), "namespace", snap.item.GetNamespace())
+		if err := a.restore(ctx, snap, options); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", describe(snap.item), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *ClientApply) restore(ctx context.Context, snap snapshot, options *SyncOptions) error {
+	if snap.live == nil {
+		if err := a.Client.Delete(ctx, snap.item.DeepCopy()); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	previous := snap.live.DeepCopy()
+	for _, field := range []string{"resourceVersion", "uid", "creationTimestamp", "generation", "managedFields", "deletionTimestamp"} {
+		unstructured.RemoveNestedField(previous.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(previous.Object, "status")
+	if snap.recreate {
+		return a.recreateResource(ctx, previous, options)
+	}
+
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(previous.GroupVersionKind())
+	if err := a.Client.Get(ctx, client.ObjectKeyFromObject(previous), current); err != nil {
+		if apierrors.IsNotFound(err) {
+			return a.Client.Create(ctx, previous, client.FieldOwner(options.fieldManager()))
+		}
+		return err
+	}
+	previous.SetResourceVersion(current.GetResourceVersion())
+	return a.Client.Update(ctx, previous, client.FieldOwner(options.fieldManager()))
+}
+
+// previouslyManaged returns the objects managed before the sync of diff.
+func previouslyManaged(diff DiffResult) []appsv1.ManagedResource {
+	managed := []appsv1.ManagedResource{}
+	for _, item := range diff.Applys {
+		managed = append(managed, appsv1.GetReference(item))
+	}
+	for _, item := range diff.Removes {
+		if item.GetResourceVersion() != "" {
+			managed = append(managed, appsv1.GetReference(item))
+		}
+	}
+	return managed
+}
+
+func mergeManaged(lists ...[]appsv1.ManagedResource) []appsv1.ManagedResource {
+	seen := map[appsv1.ManagedResource]bool{}
+	merged := []appsv1.ManagedResource{}
+	for _, list := range lists {
+		for _, ref := range list {
+			if !seen[ref] {
+				seen[ref] = true
+				merged = append(merged, ref)
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,108 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"xiaoshiai.cn/installer/install"
+)
+
+// failingClient fails the creation of the object named create and the
+// dry-run of the object named dryRun.
+type failingClient struct {
+	client.Client
+	create, dryRun string
+	mutations      int
+}
+
+func (c *failingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if obj.GetName() == c.create {
+		return errors.New("admission denied")
+	}
+	c.mutations++
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *failingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if slices.Contains(opts, client.PatchOption(client.DryRunAll)) {
+		if obj.GetName() == c.dryRun {
+			return errors.New("invalid object")
+		}
+		return nil
+	}
+	c.mutations++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func transactionalOptions() *SyncOptions {
+	options := testSyncOptions()
+	options.Transactional = true
+	return options
+}
+
+func TestParseSyncOptions(t *testing.T) {
+	ctx := context.Background()
+	options, err := ParseSyncOptions(ctx, []install.Option{{Name: "transactional", Value: "true"}})
+	if err != nil || !options.Transactional {
+		t.Fatalf("ParseSyncOptions() = %+v, %v", options, err)
+	}
+	if _, err := ParseSyncOptions(ctx, []install.Option{{Name: "transactional", Value: "yes please"}}); err == nil {
+		t.Error("ParseSyncOptions() accepted an invalid boolean")
+	}
+	if options, err := ParseSyncOptions(ctx, []install.Option{{Name: "concurrency", Value: "32"}}); err != nil || options.Concurrency != 32 {
+		t.Errorf("ParseSyncOptions() = %+v, %v", options, err)
+	}
+	if _, err := ParseSyncOptions(ctx, []install.Option{{Name: "concurrency", Value: "0"}}); err == nil {
+		t.Error("ParseSyncOptions() accepted a concurrency of 0")
+	}
+	if options, err := ParseSyncOptions(ctx, []install.Option{{Name: "atomic", Value: "true"}}); err != nil || options.Transactional {
+		t.Errorf("ParseSyncOptions() = %+v, %v, want the unknown option ignored", options, err)
+	}
+}
+
+func TestSyncDiffRollsBack(t *testing.T) {
+	ctx := context.Background()
+	live := testResource("settings", "old", nil)
+	failing := &failingClient{Client: fake.NewClientBuilder().WithObjects(live).Build(), create: "broken"}
+	diff := DiffResult{
+		Creats: []*unstructured.Unstructured{
+			testResource("extra", "new", nil),
+			testResource("broken", "new", map[string]string{AnnotationApplyWave: "1"}),
+		},
+		Applys: []*unstructured.Unstructured{testResource("settings", "new", nil)},
+	}
+	managed, err := (&ClientApply{Client: failing}).SyncDiff(ctx, diff, transactionalOptions())
+	var rollbackErr *install.RollbackError
+	if !errors.As(err, &rollbackErr) {
+		t.Fatalf("SyncDiff() error = %v, want a rollback error", err)
+	}
+	if len(managed) != 1 || managed[0].Name != "settings" {
+		t.Fatalf("managed = %v, want the previously managed settings", managed)
+	}
+	got := testResource("settings", "", nil)
+	if err := failing.Get(ctx, client.ObjectKeyFromObject(live), got); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := unstructured.NestedString(got.Object, "data", "value"); value != "old" {
+		t.Errorf("settings value = %q, want old restored", value)
+	}
+	if err := failing.Get(ctx, client.ObjectKey{Namespace: "default", Name: "extra"}, testResource("extra", "", nil)); err == nil {
+		t.Error("object created by the failed sync was not deleted")
+	}
+}
+
+func TestSyncDiffDryRunFailsBeforeMutation(t *testing.T) {
+	failing := &failingClient{Client: fake.NewClientBuilder().Build(), dryRun: "invalid"}
+	diff := DiffResult{Creats: []*unstructured.Unstructured{testResource("valid", "", nil), testResource("invalid", "", nil)}}
+	if _, err := (&ClientApply{Client: failing}).SyncDiff(context.Background(), diff, transactionalOptions()); err == nil {
+		t.Fatal("SyncDiff() error = nil, want the dry-run error")
+	}
+	if failing.mutations != 0 {
+		t.Fatalf("dry-run failure caused %d mutations", failing.mutations)
+	}
+}
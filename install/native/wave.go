@@ -0,0 +1,272 @@
+package native
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+	"xiaoshiai.cn/installer/install/health"
+)
+
+// AnnotationApplyWave orders the apply of a resource, resources of a wave are
+// applied once the resources of the lower waves are ready. Waves are
+// integers, 0 by default, and may be negative.
+const AnnotationApplyWave = "apps.xiaoshiai.cn/apply-wave"
+
+// DefaultWaveTimeout is the default of SyncOptions.WaveTimeout.
+const DefaultWaveTimeout = 5 * time.Minute
+
+var installOrder = func() map[string]int {
+	order := map[string]int{}
+	for i, kind := range releaseutil.InstallOrder {
+		order[kind] = i
+	}
+	return order
+}()
+
+// ApplyWave returns the apply wave of obj.
+func ApplyWave(obj client.Object) (int, error) {
+	value, ok := obj.GetAnnotations()[AnnotationApplyWave]
+	if !ok {
+		return 0, nil
+	}
+	wave, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q on %s %s/%s", AnnotationApplyWave, value,
+			obj.GetObjectKind().GroupVersionKind().Kind, obj.GetNamespace(), obj.GetName())
+	}
+	return wave, nil
+}
+
+type wave struct {
+	number int
+	items  []*unstructured.Unstructured
+}
+
+// groupWaves groups items by ascending wave, the items of a wave are sorted
+// by the install order of their kind as helm does, unknown kinds last.
+func groupWaves(items []*unstructured.Unstructured) ([]wave, error) {
+	byNumber := map[int][]*unstructured.Unstructured{}
+	for _, item := range items {
+		number, err := ApplyWave(item)
+		if err != nil {
+			return nil, err
+		}
+		byNumber[number] = append(byNumber[number], item)
+	}
+	waves := make([]wave, 0, len(byNumber))
+	for number, items := range byNumber {
+		sort.SliceStable(items, func(i, j int) bool {
+			return kindLess(items[i].GetKind(), items[j].GetKind())
+		})
+		waves = append(waves, wave{number: number, items: items})
+	}
+	sort.Slice(waves, func(i, j int) bool { return waves[i].number < waves[j].number })
+	return waves, nil
+}
+
+func kindLess(a, b string) bool {
+	first, aok := installOrder[a]
+	second, bok := installOrder[b]
+	switch {
+	case aok && bok:
+		return first < second
+	case aok != bok:
+		return aok
+	default:
+		return a < b
+	}
+}
+
+// reverseWaves returns waves in the reverse order, items included, to
+// delete resources in the reverse of the install order.
+func reverseWaves(waves []wave) []wave {
+	reversed := make([]wave, 0, len(waves))
+	for i := len(waves) - 1; i >= 0; i-- {
+		items := make([]*unstructured.Unstructured, 0, len(waves[i].items))
+		for j := len(waves[i].items) - 1; j >= 0; j-- {
+			items = append(items, waves[i].items[j])
+		}
+		reversed = append(reversed, wave{number: waves[i].number, items: items})
+	}
+	return reversed
+}
+
+// notReady returns the items whose live state is not ready, with the reason.
+func (a *ClientApply) notReady(ctx context.Context, items []*unstructured.Unstructured) ([]string, error) {
+	var pending []string
+	for _, item := range items {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(item.GroupVersionKind())
+		if err := a.Client.Get(ctx, client.ObjectKeyFromObject(item), live); err != nil {
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, describe(item)+": not found")
+				continue
+			}
+			return nil, err
+		}
+		ready, message, err := resourceReady(live)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", describe(item), err)
+		}
+		if !ready {
+			pending = append(pending, describe(item)+": "+message)
+		}
+	}
+	return pending, nil
+}
+
+// notDeleted returns the items which still exist.
+func (a *ClientApply) notDeleted(ctx context.Context, items []*unstructured.Unstructured) ([]string, error) {
+	var remaining []string
+	for _, item := range items {
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(item.GroupVersionKind())
+		if err := a.Client.Get(ctx, client.ObjectKeyFromObject(item), live); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		remaining = append(remaining, describe(item))
+	}
+	return remaining, nil
+}
+
+// waveResume is the point a sync resumes at, from the stage of its earlier
+// wait: the waves up to it were applied and are not applied again.
+type waveResume struct {
+	ok bool
+	// wave is the number of the wave waited for.
+	wave int
+	// definitions is set when the wave waited for its CRDs to be
+	// established, removal when it waited for a removed wave.
+	definitions, removal bool
+}
+
+// resumeAt returns the point a sync waiting at waiting resumes at.
+func resumeAt(waiting *appsv1.InstanceWait) waveResume {
+	if waiting == nil {
+		return waveResume{}
+	}
+	fields := strings.Fields(waiting.Stage)
+	if len(fields) < 2 || len(fields) > 3 || fields[0] != "wave" {
+		return waveResume{}
+	}
+	number, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return waveResume{}
+	}
+	resume := waveResume{ok: true, wave: number}
+	if len(fields) == 3 {
+		switch fields[2] {
+		case "definitions":
+			resume.definitions = true
+		case "removal":
+			resume.removal = true
+		default:
+			return waveResume{}
+		}
+	}
+	return resume
+}
+
+// applied reports whether the batch of wave was applied before the sync
+// waited. Batches with objects to create changed since and are applied.
+func (r waveResume) applied(wave, stage int, batch []*unstructured.Unstructured, created map[*unstructured.Unstructured]bool) bool {
+	if !r.ok {
+		return false
+	}
+	for _, item := range batch {
+		if created[item] {
+			return false
+		}
+	}
+	switch {
+	case r.removal, wave < r.wave:
+		return true
+	case wave > r.wave:
+		return false
+	case r.definitions:
+		// the resources of the wave were not applied yet
+		return stage < batchResources
+	default:
+		return true
+	}
+}
+
+// waitAt returns a WaitingError for stage, which waits for pending since the
+// earlier wait of the same stage, or an error once it waited for timeout.
+func waitAt(stage, state string, pending []string, options *SyncOptions, timeout time.Duration) error {
+	since := time.Now()
+	if waiting := options.Waiting; waiting != nil && waiting.Stage == stage {
+		since = waiting.Since.Time
+	}
+	if time.Since(since) > timeout {
+		return fmt.Errorf("%s: not %s after %s: %s", stage, state, timeout, strings.Join(pending, ", "))
+	}
+	return &install.WaitingError{
+		Wait:    appsv1.InstanceWait{Stage: stage, Since: metav1.NewTime(since)},
+		Message: "waiting for " + strings.Join(pending, ", "),
+	}
+}
+
+// observedKinds are the kinds whose state is unknown until their controller
+// observed them, an empty status would otherwise read as paused.
+var observedKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+	"Instance":    true,
+}
+
+// resourceReady reports whether live is ready with the workload states of
+// the instance status; CRDs must be established, resources without a state
+// are ready once their status observed their generation.
+func resourceReady(live *unstructured.Unstructured) (bool, string, error) {
+	observed, ok, _ := unstructured.NestedInt64(live.Object, "status", "observedGeneration")
+	if ok && observed < live.GetGeneration() || !ok && observedKinds[live.GetKind()] {
+		return false, "generation not observed", nil
+	}
+	if IsCRD(live) {
+		conditions, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+		for _, condition := range conditions {
+			if c, ok := condition.(map[string]any); ok && c["type"] == "Established" && c["status"] == "True" {
+				return true, "", nil
+			}
+		}
+		return false, "not established", nil
+	}
+	states := health.States([]*unstructured.Unstructured{live})
+	if len(states) == 0 {
+		return true, "", nil
+	}
+	switch status := states[0].Status; {
+	case status == health.StatusRunning && live.GetKind() == "Job":
+		// a job is ready once it completed
+		return false, status, nil
+	case health.IsHealthy(status), status == health.StatusPaused, status == string(appsv1.PhaseInstalled):
+		return true, "", nil
+	case status == health.StatusFailed:
+		return false, "", fmt.Errorf("failed: %s", states[0].Message)
+	default:
+		if states[0].Message != "" {
+			return false, status + ": " + states[0].Message, nil
+		}
+		return false, status, nil
+	}
+}
+
+func describe(obj client.Object) string {
+	return fmt.Sprintf("%s %s/%s", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetNamespace(), obj.GetName())
+}
@@ -0,0 +1,253 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	appsv1 "xiaoshiai.cn/installer/apis/apps/v1"
+	"xiaoshiai.cn/installer/install"
+)
+
+type recordingClient struct {
+	client.Client
+	calls []string
+}
+
+func (c *recordingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.calls = append(c.calls, "apply "+obj.GetObjectKind().GroupVersionKind().Kind+"/"+obj.GetName())
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *recordingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.calls = append(c.calls, "apply "+obj.GetObjectKind().GroupVersionKind().Kind+"/"+obj.GetName())
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *recordingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.calls = append(c.calls, "delete "+obj.GetObjectKind().GroupVersionKind().Kind+"/"+obj.GetName())
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func waveResource(kind, name, wave string) *unstructured.Unstructured {
+	obj := testResource(name, "", nil)
+	obj.SetKind(kind)
+	if wave != "" {
+		obj.SetAnnotations(map[string]string{AnnotationApplyWave: wave})
+	}
+	return obj
+}
+
+func TestSyncDiffAppliesWavesInOrder(t *testing.T) {
+	ctx := context.Background()
+	recording := &recordingClient{Client: fake.NewClientBuilder().Build()}
+	diff := DiffResult{
+		Creats: []*unstructured.Unstructured{
+			waveResource("ConfigMap", "late", "1"),
+			waveResource("Widget", "custom", ""),
+			waveResource("ConfigMap", "settings", ""),
+		},
+		Applys: []*unstructured.Unstructured{
+			waveResource("Secret", "credentials", ""),
+			waveResource("ConfigMap", "early", "-1"),
+		},
+	}
+	if _, err := (&ClientApply{Client: recording}).SyncDiff(ctx, diff, testSyncOptions()); err != nil {
+		t.Fatalf("SyncDiff() error = %v", err)
+	}
+	want := "apply ConfigMap/early,apply Secret/credentials,apply ConfigMap/settings,apply Widget/custom,apply ConfigMap/late"
+	if got := strings.Join(recording.calls, ","); got != want {
+		t.Fatalf("calls = %s\nwant %s", got, want)
+	}
+}
+
+func TestSyncDiffStopsAtUnreadyWave(t *testing.T) {
+	ctx := context.Background()
+	recording := &recordingClient{Client: fake.NewClientBuilder().Build()}
+	deployment := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "database", "namespace": "default"},
+	}}
+	options := testSyncOptions()
+	diff := DiffResult{
+		Creats: []*unstructured.Unstructured{deployment, waveResource("ConfigMap", "after", "1")},
+		Applys: []*unstructured.Unstructured{waveResource("ConfigMap", "managed", "2")},
+	}
+	managed, err := (&ClientApply{Client: recording}).SyncDiff(ctx, diff, options)
+	var waiting *install.WaitingError
+	if !errors.As(err, &waiting) || waiting.Wait.Stage != "wave 0" || !strings.Contains(err.Error(), "Deployment default/database") {
+		t.Fatalf("SyncDiff() error = %v, want to wait for the deployment of wave 0", err)
+	}
+	if got := strings.Join(recording.calls, ","); got != "apply Deployment/database" {
+		t.Fatalf("calls = %s, want only the first wave", got)
+	}
+	var names []string
+	for _, ref := range managed {
+		names = append(names, ref.Name)
+	}
+	if got := strings.Join(names, ","); !strings.Contains(got, "database") || !strings.Contains(got, "managed") || strings.Contains(got, "after") {
+		t.Fatalf("managed = %s, want database and managed", got)
+	}
+
+	// the wait of the stage started before the wave timeout
+	options.WaveTimeout = time.Minute
+	options.Waiting = &appsv1.InstanceWait{Stage: "wave 0", Since: metav1.NewTime(time.Now().Add(-time.Hour))}
+	diff.Creats, diff.Applys = diff.Creats[1:], append(diff.Applys, deployment)
+	if _, err := (&ClientApply{Client: recording}).SyncDiff(ctx, diff, options); err == nil || errors.As(err, &waiting) ||
+		!strings.Contains(err.Error(), "wave 0: not ready after 1m0s") {
+		t.Fatalf("SyncDiff() error = %v, want wave 0 timed out", err)
+	}
+}
+
+func TestSyncDiffResumesWaitingWave(t *testing.T) {
+	ctx := context.Background()
+	widget := waveResource("Widget", "database", "")
+	widget.SetGeneration(2)
+	_ = unstructured.SetNestedField(widget.Object, int64(1), "status", "observedGeneration")
+	recording := &recordingClient{Client: fake.NewClientBuilder().Build()}
+	diff := DiffResult{Creats: []*unstructured.Unstructured{widget, waveResource("ConfigMap", "after", "1")}}
+	managed, err := (&ClientApply{Client: recording}).SyncDiff(ctx, diff, testSyncOptions())
+	var waiting *install.WaitingError
+	if !errors.As(err, &waiting) {
+		t.Fatalf("SyncDiff() error = %v, want to wait for wave 0", err)
+	}
+
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(widget.GroupVersionKind())
+	if err := recording.Get(ctx, client.ObjectKeyFromObject(widget), live); err != nil {
+		t.Fatal(err)
+	}
+	_ = unstructured.SetNestedField(live.Object, live.GetGeneration(), "status", "observedGeneration")
+	if err := recording.Client.Update(ctx, live); err != nil {
+		t.Fatal(err)
+	}
+	recording.calls = nil
+	options := testSyncOptions()
+	options.Waiting = &waiting.Wait
+	resumed := Diff(managed, []*unstructured.Unstructured{waveResource("Widget", "database", ""), waveResource("ConfigMap", "after", "1")})
+	if _, err := (&ClientApply{Client: recording}).SyncDiff(ctx, resumed, options); err != nil {
+		t.Fatalf("resumed SyncDiff() error = %v", err)
+	}
+	if got := strings.Join(recording.calls, ","); got != "apply ConfigMap/after" {
+		t.Fatalf("calls = %s, want the next wave only", got)
+	}
+}
+
+func TestSyncDiffWaitsForRemovedWave(t *testing.T) {
+	ctx := context.Background()
+	first := waveResource("ConfigMap", "first", "")
+	second := waveResource("ConfigMap", "second", "1")
+	second.SetFinalizers([]string{"example.com/cleanup"})
+	recording := &recordingClient{Client: fake.NewClientBuilder().WithObjects(first, second).Build()}
+	var refs []*unstructured.Unstructured
+	for _, obj := range []*unstructured.Unstructured{first, second} {
+		ref := &unstructured.Unstructured{}
+		ref.SetGroupVersionKind(obj.GroupVersionKind())
+		ref.SetName(obj.GetName())
+		ref.SetNamespace(obj.GetNamespace())
+		refs = append(refs, ref)
+	}
+	managed, err := (&ClientApply{Client: recording}).SyncDiff(ctx, DiffResult{Removes: refs}, testSyncOptions())
+	var waiting *install.WaitingError
+	if !errors.As(err, &waiting) || waiting.Wait.Stage != "wave 1 removal" {
+		t.Fatalf("SyncDiff() error = %v, want to wait for the removal of wave 1", err)
+	}
+	if got := strings.Join(recording.calls, ","); got != "delete ConfigMap/second" {
+		t.Fatalf("calls = %s, want the later wave deleted first", got)
+	}
+	var names []string
+	for _, ref := range managed {
+		names = append(names, ref.Name)
+	}
+	slices.Sort(names)
+	if got := strings.Join(names, ","); got != "first,second" {
+		t.Fatalf("managed = %s, want both kept until removed", got)
+	}
+}
+
+func TestSyncDiffRemovesWavesInReverse(t *testing.T) {
+	ctx := context.Background()
+	first := waveResource("ConfigMap", "first", "")
+	second := waveResource("ConfigMap", "second", "1")
+	secret := waveResource("Secret", "credentials", "")
+	recording := &recordingClient{Client: fake.NewClientBuilder().WithObjects(first, second, secret).Build()}
+	var refs []*unstructured.Unstructured
+	for _, obj := range []*unstructured.Unstructured{first, second, secret} {
+		ref := &unstructured.Unstructured{}
+		ref.SetGroupVersionKind(obj.GroupVersionKind())
+		ref.SetName(obj.GetName())
+		ref.SetNamespace(obj.GetNamespace())
+		refs = append(refs, ref)
+	}
+	if _, err := (&ClientApply{Client: recording}).SyncDiff(ctx, DiffResult{Removes: refs}, testSyncOptions()); err != nil {
+		t.Fatalf("SyncDiff() error = %v", err)
+	}
+	want := "delete ConfigMap/second,delete ConfigMap/first,delete Secret/credentials"
+	if got := strings.Join(recording.calls, ","); got != want {
+		t.Fatalf("calls = %s\nwant %s", got, want)
+	}
+}
+
+func TestSyncDiffRejectsInvalidWaveBeforeMutation(t *testing.T) {
+	recording := &recordingClient{Client: fake.NewClientBuilder().Build()}
+	diff := DiffResult{Creats: []*unstructured.Unstructured{
+		waveResource("ConfigMap", "valid", ""),
+		waveResource("ConfigMap", "invalid", "first"),
+	}}
+	if _, err := (&ClientApply{Client: recording}).SyncDiff(context.Background(), diff, testSyncOptions()); err == nil {
+		t.Fatal("SyncDiff() error = nil, want invalid wave error")
+	}
+	if len(recording.calls) != 0 {
+		t.Fatalf("invalid wave caused mutations: %v", recording.calls)
+	}
+}
+
+func TestResourceReady(t *testing.T) {
+	crd := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]any{"name": "widgets.example.com"},
+	}}
+	if ready, _, _ := resourceReady(crd); ready {
+		t.Error("CRD without Established condition is ready")
+	}
+	unstructured.SetNestedSlice(crd.Object, []any{map[string]any{"type": "Established", "status": "True"}}, "status", "conditions")
+	if ready, _, _ := resourceReady(crd); !ready {
+		t.Error("established CRD is not ready")
+	}
+
+	deployment := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]any{"name": "web", "generation": int64(2)},
+		"status":     map[string]any{"observedGeneration": int64(1), "replicas": int64(1), "readyReplicas": int64(1)},
+	}}
+	if ready, _, _ := resourceReady(deployment); ready {
+		t.Error("deployment with an unobserved generation is ready")
+	}
+	unstructured.SetNestedField(deployment.Object, int64(2), "status", "observedGeneration")
+	if ready, _, _ := resourceReady(deployment); !ready {
+		t.Error("running deployment is not ready")
+	}
+
+	job := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]any{"name": "migrate"},
+	}}
+	if ready, _, _ := resourceReady(job); ready {
+		t.Error("running job is ready")
+	}
+	unstructured.SetNestedSlice(job.Object, []any{map[string]any{"type": "Failed", "status": "True", "message": "BackoffLimitExceeded"}}, "status", "conditions")
+	if _, _, err := resourceReady(job); err == nil {
+		t.Error("failed job did not fail the wave")
+	}
+}
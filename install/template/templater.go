@@ -100,7 +100,7 @@ func (t Templater) Template(ctx context.Context, instance install.Instance) ([]b
 		}
 	}
 
-	_, manifests, err := releaseutil.SortManifests(renderdFiles, caps.APIVersions, releaseutil.InstallOrder)
+	hooks, manifests, err := releaseutil.SortManifests(renderdFiles, caps.APIVersions, releaseutil.InstallOrder)
 	if err != nil {
 		out := os.Stderr
 		for file, val := range renderdFiles {
@@ -116,6 +116,10 @@ func (t Templater) Template(ctx context.Context, instance install.Instance) ([]b
 	for _, m := range manifests {
 		fmt.Fprintf(out, "---\n# Source: %s\n%s\n", m.Name, m.Content)
 	}
+	// hooks keep their annotations and are run by the native applier
+	for _, hook := range hooks {
+		fmt.Fprintf(out, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
 	return out.Bytes(), nil
 }
 
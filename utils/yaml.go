@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -100,6 +102,61 @@ func ConvertToTyped(uns []*unstructured.Unstructured, scheme *runtime.Scheme) []
 	return typedobjs
 }
 
+// ObjectsYAML returns the Kubernetes objects of value as YAML documents.
+// value is an object, a List, or arrays and maps holding them, decoded from
+// JSON; objects are ordered as the arrays and the sorted keys of maps.
+func ObjectsYAML(value any) ([]byte, error) {
+	var objects []map[string]any
+	if err := collectObjects(value, "", &objects); err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(nil)
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+	return out.Bytes(), nil
+}
+
+func collectObjects(value any, path string, objects *[]map[string]any) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		for i, item := range v {
+			if err := collectObjects(item, fmt.Sprintf("%s[%d]", path, i), objects); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		kind, _ := v["kind"].(string)
+		if _, ok := v["apiVersion"].(string); ok && kind != "" {
+			if items, ok := v["items"].([]any); ok && strings.HasSuffix(kind, "List") {
+				return collectObjects(items, path+".items", objects)
+			}
+			*objects = append(*objects, v)
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := collectObjects(v[key], path+"."+key, objects); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("output%s is %T, not a Kubernetes object", path, value)
+	}
+}
+
 // https://github.com/helm/helm/blob/bed1a42a398b30a63a279d68cc7319ceb4618ec3/pkg/chartutil/coalesce.go#L37
 // helm CoalesceValues cant handle nested null,like `{a: {b: null}}`, which want to be `{}`
 func RemoveNulls(m any) {